@@ -13,6 +13,14 @@ type CanAppendAdditionalContext interface {
 	GetLogEntries() []LogEntry
 }
 
+// KeyHistoryContext is implemented by the contexts giving the key statements
+// known by a log, so access controllers can check the keys of the entries
+// using KeyHistory.CheckKey.
+type KeyHistoryContext interface {
+	CanAppendAdditionalContext
+	GetKeyHistory() *identityprovider.KeyHistory
+}
+
 type Interface interface {
 	CanAppend(LogEntry, identityprovider.Interface, CanAppendAdditionalContext) error
 }
@@ -0,0 +1,125 @@
+package enc
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// AlgorithmSecretbox is the algorithm of the keys created by
+	// NewSecretbox, it is assumed for entries recording no algorithm.
+	AlgorithmSecretbox = "xsalsa20-poly1305"
+
+	// AlgorithmXChaCha20Poly1305 is the algorithm of the keys created by
+	// NewXChaCha20Poly1305.
+	AlgorithmXChaCha20Poly1305 = "xchacha20-poly1305"
+)
+
+var ErrUnsupportedAlgorithm = fmt.Errorf("unsupported algorithm")
+
+// AlgorithmKey is implemented by the shared keys which are not secretbox
+// keys.
+type AlgorithmKey interface {
+	Algorithm() string
+}
+
+// AlgorithmOf returns the algorithm of a key.
+func AlgorithmOf(key SharedKey) string {
+	if k, ok := key.(AlgorithmKey); ok {
+		return k.Algorithm()
+	}
+
+	return AlgorithmSecretbox
+}
+
+// NewSharedKey creates a key for the given algorithm, an empty algorithm
+// stands for secretbox.
+func NewSharedKey(algorithm string, key []byte) (SharedKey, error) {
+	switch algorithm {
+	case "", AlgorithmSecretbox:
+		return NewSecretbox(key)
+	case AlgorithmXChaCha20Poly1305:
+		return NewXChaCha20Poly1305(key)
+	}
+
+	return nil, ErrUnsupportedAlgorithm
+}
+
+type xchacha struct {
+	aead cipher.AEAD
+}
+
+// NewXChaCha20Poly1305 creates a key using XChaCha20-Poly1305. Unlike
+// secretbox keys, nonces are always random: DeriveNonce ignores its input so
+// identical content is never sealed with the same nonce.
+func NewXChaCha20Poly1305(key []byte) (SharedKey, error) {
+	if len(key) != chacha20poly1305.KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	return &xchacha{aead: aead}, nil
+}
+
+func (x *xchacha) Algorithm() string {
+	return AlgorithmXChaCha20Poly1305
+}
+
+func (x *xchacha) DeriveNonce(_ []byte) ([]byte, error) {
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("unable to generate a nonce: %w", err)
+	}
+
+	return nonce, nil
+}
+
+func (x *xchacha) Open(payload []byte) ([]byte, error) {
+	if len(payload) < chacha20poly1305.NonceSizeX+x.aead.Overhead() {
+		return nil, ErrCannotDecrypt
+	}
+
+	return x.OpenWithNonce(payload[chacha20poly1305.NonceSizeX:], payload[:chacha20poly1305.NonceSizeX])
+}
+
+func (x *xchacha) Seal(encrypted []byte) ([]byte, error) {
+	nonce, err := x.DeriveNonce(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := x.SealWithNonce(encrypted, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(nonce, sealed...), nil
+}
+
+func (x *xchacha) OpenWithNonce(payload []byte, nonce []byte) ([]byte, error) {
+	if len(nonce) != chacha20poly1305.NonceSizeX {
+		return nil, ErrInvalidNonce
+	}
+
+	dec, err := x.aead.Open(nil, nonce, payload, nil)
+	if err != nil {
+		return nil, ErrCannotDecrypt
+	}
+
+	return dec, nil
+}
+
+func (x *xchacha) SealWithNonce(encrypted []byte, nonce []byte) ([]byte, error) {
+	if len(nonce) != chacha20poly1305.NonceSizeX {
+		return nil, ErrInvalidNonce
+	}
+
+	return x.aead.Seal(nil, nonce, encrypted, nil), nil
+}
@@ -0,0 +1,56 @@
+package enc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestXChaCha20Poly1305(t *testing.T) {
+	keyBytes := []byte("0123456789abcdef0123456789abcdef")
+
+	_, err := NewXChaCha20Poly1305(keyBytes[:31])
+	require.Error(t, err)
+
+	key, err := NewSharedKey(AlgorithmXChaCha20Poly1305, keyBytes)
+	require.NoError(t, err)
+	require.Equal(t, AlgorithmXChaCha20Poly1305, AlgorithmOf(key))
+
+	sealed1, err := key.Seal([]byte("hello"))
+	require.NoError(t, err)
+
+	sealed2, err := key.Seal([]byte("hello"))
+	require.NoError(t, err)
+	require.NotEqual(t, sealed1, sealed2)
+
+	opened, err := key.Open(sealed1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), opened)
+
+	nonce1, err := key.DeriveNonce([]byte("ref"))
+	require.NoError(t, err)
+
+	nonce2, err := key.DeriveNonce([]byte("ref"))
+	require.NoError(t, err)
+	require.NotEqual(t, nonce1, nonce2)
+
+	sealed, err := key.SealWithNonce([]byte("links"), nonce1)
+	require.NoError(t, err)
+
+	opened, err = key.OpenWithNonce(sealed, nonce1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("links"), opened)
+
+	_, err = key.OpenWithNonce(sealed, nonce2)
+	require.ErrorIs(t, err, ErrCannotDecrypt)
+
+	box, err := NewSharedKey("", keyBytes)
+	require.NoError(t, err)
+	require.Equal(t, AlgorithmSecretbox, AlgorithmOf(box))
+
+	_, err = box.Open(sealed1)
+	require.Error(t, err)
+
+	_, err = NewSharedKey("rot13", keyBytes)
+	require.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+}
@@ -0,0 +1,157 @@
+package enc
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Purpose identifies what a derived key is used for, keys derived for
+// different purposes are unrelated.
+type Purpose string
+
+const (
+	PurposeLinks    Purpose = "links"
+	PurposePayload  Purpose = "payload"
+	PurposeMetadata Purpose = "metadata"
+)
+
+// PassphraseKDF is the function used to stretch a passphrase.
+type PassphraseKDF string
+
+const (
+	KDFArgon2id PassphraseKDF = "argon2id"
+	KDFScrypt   PassphraseKDF = "scrypt"
+)
+
+const (
+	MasterKeyMinSize = 32
+	SaltMinSize      = 16
+
+	defaultArgon2Time    = 3
+	defaultArgon2Memory  = 64 * 1024
+	defaultArgon2Threads = 4
+	defaultScryptN       = 1 << 15
+)
+
+var (
+	ErrInvalidPurpose = fmt.Errorf("invalid purpose")
+	ErrInvalidSalt    = fmt.Errorf("invalid salt")
+)
+
+// PassphraseOptions defines how a passphrase is stretched, zero values are
+// replaced by defaults.
+type PassphraseOptions struct {
+	// KDF defaults to Argon2id.
+	KDF PassphraseKDF
+
+	// Time is the number of passes of Argon2id, defaults to 3.
+	Time uint32
+
+	// Memory is the memory used by Argon2id in KiB, defaults to 64 MiB.
+	Memory uint32
+
+	// Threads is the parallelism of Argon2id, defaults to 4.
+	Threads uint8
+
+	// ScryptN is the cost parameter of scrypt, defaults to 2^15.
+	ScryptN int
+}
+
+// MasterKey derives the keys of every log from a single secret, so a key is
+// never reused across logs or purposes.
+type MasterKey struct {
+	secret []byte
+}
+
+// NewMasterKey creates a master key from a random secret of at least 32
+// bytes.
+func NewMasterKey(secret []byte) (*MasterKey, error) {
+	if len(secret) < MasterKeyMinSize {
+		return nil, ErrInvalidKey
+	}
+
+	return &MasterKey{secret: append([]byte{}, secret...)}, nil
+}
+
+// NewMasterKeyFromPassphrase creates a master key by stretching a
+// passphrase, salt must be random, at least 16 bytes long, and stored along
+// with the KDF options to derive the same key again.
+func NewMasterKeyFromPassphrase(passphrase []byte, salt []byte, options *PassphraseOptions) (*MasterKey, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrInvalidKey
+	}
+
+	if len(salt) < SaltMinSize {
+		return nil, ErrInvalidSalt
+	}
+
+	if options == nil {
+		options = &PassphraseOptions{}
+	}
+
+	switch options.KDF {
+	case "", KDFArgon2id:
+		time, memory, threads := options.Time, options.Memory, options.Threads
+		if time == 0 {
+			time = defaultArgon2Time
+		}
+
+		if memory == 0 {
+			memory = defaultArgon2Memory
+		}
+
+		if threads == 0 {
+			threads = defaultArgon2Threads
+		}
+
+		return &MasterKey{secret: argon2.IDKey(passphrase, salt, time, memory, threads, MasterKeyMinSize)}, nil
+
+	case KDFScrypt:
+		n := options.ScryptN
+		if n == 0 {
+			n = defaultScryptN
+		}
+
+		secret, err := scrypt.Key(passphrase, salt, n, 8, 1, MasterKeyMinSize)
+		if err != nil {
+			return nil, fmt.Errorf("unable to stretch passphrase: %w", err)
+		}
+
+		return &MasterKey{secret: secret}, nil
+	}
+
+	return nil, ErrUnsupportedAlgorithm
+}
+
+// DeriveKey derives a 32 bytes key for a log and a purpose using HKDF-SHA256.
+func (m *MasterKey) DeriveKey(logID string, purpose Purpose) ([]byte, error) {
+	if purpose == "" || strings.Contains(string(purpose), "/") {
+		return nil, ErrInvalidPurpose
+	}
+
+	info := []byte(fmt.Sprintf("go-ipfs-log/%s/%s", purpose, logID))
+	key := make([]byte, SecretBoxKeySize)
+
+	if _, err := io.ReadFull(hkdf.New(sha256.New, m.secret, nil, info), key); err != nil {
+		return nil, fmt.Errorf("unable to derive key: %w", err)
+	}
+
+	return key, nil
+}
+
+// SharedKey derives the key of a log for a purpose, using the given
+// algorithm, an empty algorithm stands for secretbox.
+func (m *MasterKey) SharedKey(logID string, purpose Purpose, algorithm string) (SharedKey, error) {
+	key, err := m.DeriveKey(logID, purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSharedKey(algorithm, key)
+}
@@ -0,0 +1,92 @@
+package enc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMasterKey(t *testing.T) {
+	_, err := NewMasterKey([]byte("too short"))
+	require.ErrorIs(t, err, ErrInvalidKey)
+
+	master, err := NewMasterKey([]byte("0123456789abcdef0123456789abcdef"))
+	require.NoError(t, err)
+
+	other, err := NewMasterKey([]byte("0123456789abcdef0123456789abcdeg"))
+	require.NoError(t, err)
+
+	linksA, err := master.DeriveKey("A", PurposeLinks)
+	require.NoError(t, err)
+	require.Len(t, linksA, SecretBoxKeySize)
+
+	again, err := master.DeriveKey("A", PurposeLinks)
+	require.NoError(t, err)
+	require.Equal(t, linksA, again)
+
+	for _, derive := range []func() ([]byte, error){
+		func() ([]byte, error) { return master.DeriveKey("B", PurposeLinks) },
+		func() ([]byte, error) { return master.DeriveKey("A", PurposePayload) },
+		func() ([]byte, error) { return master.DeriveKey("A", PurposeMetadata) },
+		func() ([]byte, error) { return other.DeriveKey("A", PurposeLinks) },
+	} {
+		key, err := derive()
+		require.NoError(t, err)
+		require.NotEqual(t, linksA, key)
+	}
+
+	_, err = master.DeriveKey("A", "")
+	require.ErrorIs(t, err, ErrInvalidPurpose)
+
+	_, err = master.DeriveKey("A", "links/B")
+	require.ErrorIs(t, err, ErrInvalidPurpose)
+
+	key, err := master.SharedKey("A", PurposePayload, AlgorithmXChaCha20Poly1305)
+	require.NoError(t, err)
+	require.Equal(t, AlgorithmXChaCha20Poly1305, AlgorithmOf(key))
+
+	sealed, err := key.Seal([]byte("hello"))
+	require.NoError(t, err)
+
+	key, err = master.SharedKey("A", PurposePayload, AlgorithmXChaCha20Poly1305)
+	require.NoError(t, err)
+
+	opened, err := key.Open(sealed)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), opened)
+}
+
+func TestMasterKeyFromPassphrase(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+
+	_, err := NewMasterKeyFromPassphrase([]byte("passphrase"), salt[:8], nil)
+	require.ErrorIs(t, err, ErrInvalidSalt)
+
+	_, err = NewMasterKeyFromPassphrase(nil, salt, nil)
+	require.ErrorIs(t, err, ErrInvalidKey)
+
+	_, err = NewMasterKeyFromPassphrase([]byte("passphrase"), salt, &PassphraseOptions{KDF: "md5"})
+	require.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+
+	argonOptions := &PassphraseOptions{Time: 1, Memory: 1024, Threads: 1}
+	scryptOptions := &PassphraseOptions{KDF: KDFScrypt, ScryptN: 1 << 10}
+
+	derive := func(passphrase string, salt []byte, options *PassphraseOptions) []byte {
+		master, err := NewMasterKeyFromPassphrase([]byte(passphrase), salt, options)
+		require.NoError(t, err)
+
+		key, err := master.DeriveKey("A", PurposePayload)
+		require.NoError(t, err)
+
+		return key
+	}
+
+	for _, options := range []*PassphraseOptions{argonOptions, scryptOptions} {
+		key := derive("passphrase", salt, options)
+		require.Equal(t, key, derive("passphrase", salt, options))
+		require.NotEqual(t, key, derive("passphrase2", salt, options))
+		require.NotEqual(t, key, derive("passphrase", []byte("fedcba9876543210"), options))
+	}
+
+	require.NotEqual(t, derive("passphrase", salt, argonOptions), derive("passphrase", salt, scryptOptions))
+}
@@ -0,0 +1,143 @@
+package enc
+
+import (
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrInvalidKeyID = fmt.Errorf("invalid key id")
+	ErrUnknownKeyID = fmt.Errorf("unknown key id")
+)
+
+// KeyRing holds shared keys addressed by their ID, the current key is used to
+// encrypt while every key of the ring can be used to decrypt. Keys are never
+// removed so entries sealed before a rotation can still be read.
+type KeyRing struct {
+	lock    sync.RWMutex
+	keys    map[string]SharedKey
+	ids     []string
+	current string
+}
+
+// NewKeyRing creates an empty key ring.
+func NewKeyRing() *KeyRing {
+	return &KeyRing{
+		keys: map[string]SharedKey{},
+	}
+}
+
+// NewKeyRingFromKey creates a key ring holding a single key without ID, as
+// used before key rings were introduced.
+func NewKeyRingFromKey(key SharedKey) *KeyRing {
+	k := NewKeyRing()
+	k.keys[""] = key
+	k.ids = []string{""}
+
+	return k
+}
+
+// Add adds a key to the ring without changing the current key, unless the
+// ring was empty.
+func (k *KeyRing) Add(id string, key SharedKey) error {
+	if id == "" {
+		return ErrInvalidKeyID
+	}
+
+	if key == nil {
+		return ErrInvalidKey
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if _, ok := k.keys[id]; !ok {
+		k.ids = append(k.ids, id)
+	}
+
+	k.keys[id] = key
+
+	if len(k.ids) == 1 {
+		k.current = id
+	}
+
+	return nil
+}
+
+// Rotate adds a key to the ring and makes it the current one.
+func (k *KeyRing) Rotate(id string, key SharedKey) error {
+	if err := k.Add(id, key); err != nil {
+		return err
+	}
+
+	return k.SetCurrent(id)
+}
+
+// SetCurrent selects the key used to encrypt.
+func (k *KeyRing) SetCurrent(id string) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if _, ok := k.keys[id]; !ok {
+		return ErrUnknownKeyID
+	}
+
+	k.current = id
+
+	return nil
+}
+
+// Current returns the key used to encrypt and its ID, key is nil if the ring
+// is empty.
+func (k *KeyRing) Current() (string, SharedKey) {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+
+	return k.current, k.keys[k.current]
+}
+
+// Get returns the key with the given ID.
+func (k *KeyRing) Get(id string) (SharedKey, bool) {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+
+	key, ok := k.keys[id]
+
+	return key, ok
+}
+
+// IDs returns the IDs of the keys, the most recently added first.
+func (k *KeyRing) IDs() []string {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+
+	ids := make([]string, len(k.ids))
+	for i, id := range k.ids {
+		ids[len(k.ids)-1-i] = id
+	}
+
+	return ids
+}
+
+// Open decrypts a value using the key with the given ID, or by trying every
+// key of the ring, the most recent first, if no ID has been recorded.
+func (k *KeyRing) Open(id string, open func(key SharedKey) ([]byte, error)) ([]byte, error) {
+	if id != "" {
+		key, ok := k.Get(id)
+		if !ok {
+			return nil, ErrUnknownKeyID
+		}
+
+		return open(key)
+	}
+
+	for _, id := range k.IDs() {
+		key, _ := k.Get(id)
+
+		if out, err := open(key); err == nil {
+			return out, nil
+		}
+	}
+
+	return nil, ErrCannotDecrypt
+}
@@ -0,0 +1,79 @@
+package enc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyRing(t *testing.T) {
+	key1, err := NewSecretbox([]byte("0123456789abcdef0123456789abcdef"))
+	require.NoError(t, err)
+
+	key2, err := NewSecretbox([]byte("0123456789abcdef0123456789abcdeg"))
+	require.NoError(t, err)
+
+	ring := NewKeyRing()
+
+	id, current := ring.Current()
+	require.Empty(t, id)
+	require.Nil(t, current)
+
+	require.Error(t, ring.Add("", key1))
+	require.Error(t, ring.Add("k1", nil))
+	require.Error(t, ring.SetCurrent("k1"))
+
+	require.NoError(t, ring.Add("k1", key1))
+	id, current = ring.Current()
+	require.Equal(t, "k1", id)
+	require.Equal(t, key1, current)
+
+	sealed1, err := key1.Seal([]byte("one"))
+	require.NoError(t, err)
+
+	require.NoError(t, ring.Rotate("k2", key2))
+	id, current = ring.Current()
+	require.Equal(t, "k2", id)
+	require.Equal(t, key2, current)
+	require.Equal(t, []string{"k2", "k1"}, ring.IDs())
+
+	sealed2, err := key2.Seal([]byte("two"))
+	require.NoError(t, err)
+
+	open := func(sealed []byte) func(key SharedKey) ([]byte, error) {
+		return func(key SharedKey) ([]byte, error) {
+			return key.Open(sealed)
+		}
+	}
+
+	opened, err := ring.Open("k1", open(sealed1))
+	require.NoError(t, err)
+	require.Equal(t, []byte("one"), opened)
+
+	_, err = ring.Open("k2", open(sealed1))
+	require.Error(t, err)
+
+	_, err = ring.Open("k3", open(sealed1))
+	require.ErrorIs(t, err, ErrUnknownKeyID)
+
+	opened, err = ring.Open("", open(sealed1))
+	require.NoError(t, err)
+	require.Equal(t, []byte("one"), opened)
+
+	opened, err = ring.Open("", open(sealed2))
+	require.NoError(t, err)
+	require.Equal(t, []byte("two"), opened)
+
+	require.NoError(t, ring.SetCurrent("k1"))
+	id, _ = ring.Current()
+	require.Equal(t, "k1", id)
+
+	legacy := NewKeyRingFromKey(key1)
+	id, current = legacy.Current()
+	require.Empty(t, id)
+	require.Equal(t, key1, current)
+
+	opened, err = legacy.Open("", open(sealed1))
+	require.NoError(t, err)
+	require.Equal(t, []byte("one"), opened)
+}
@@ -0,0 +1,69 @@
+package enc
+
+import (
+	"fmt"
+)
+
+// PaddingISO7816 identifies the padding applied by Pad: a 0x80 byte followed
+// by zeros, which can be removed without knowing the padded size.
+const PaddingISO7816 = "iso7816-4"
+
+var ErrInvalidPadding = fmt.Errorf("invalid padding")
+
+// Padding returns the padded size of a message of the given size, which
+// must be greater than size.
+type Padding func(size int) int
+
+// PadToPowerOfTwo pads messages to the next power of two, and to at least min
+// bytes.
+func PadToPowerOfTwo(min int) Padding {
+	return func(size int) int {
+		padded := 1
+		for padded <= size || padded < min {
+			padded <<= 1
+		}
+
+		return padded
+	}
+}
+
+// PadToMultiple pads messages to the next multiple of block bytes.
+func PadToMultiple(block int) Padding {
+	if block < 1 {
+		block = 1
+	}
+
+	return func(size int) int {
+		return (size/block + 1) * block
+	}
+}
+
+// Pad pads data to the size returned by padding.
+func Pad(data []byte, padding Padding) []byte {
+	size := padding(len(data))
+	if size <= len(data) {
+		size = len(data) + 1
+	}
+
+	out := make([]byte, size)
+	copy(out, data)
+	out[len(data)] = 0x80
+
+	return out
+}
+
+// Unpad removes the padding added by Pad.
+func Unpad(data []byte) ([]byte, error) {
+	for i := len(data) - 1; i >= 0; i-- {
+		switch data[i] {
+		case 0x00:
+			continue
+		case 0x80:
+			return data[:i], nil
+		}
+
+		break
+	}
+
+	return nil, ErrInvalidPadding
+}
@@ -0,0 +1,45 @@
+package enc
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPadding(t *testing.T) {
+	powerOfTwo := PadToPowerOfTwo(16)
+	require.Equal(t, 16, powerOfTwo(0))
+	require.Equal(t, 16, powerOfTwo(15))
+	require.Equal(t, 32, powerOfTwo(16))
+	require.Equal(t, 128, powerOfTwo(100))
+
+	multiple := PadToMultiple(64)
+	require.Equal(t, 64, multiple(0))
+	require.Equal(t, 64, multiple(63))
+	require.Equal(t, 128, multiple(64))
+
+	for _, padding := range []Padding{powerOfTwo, multiple, PadToMultiple(0)} {
+		for _, data := range [][]byte{
+			nil,
+			[]byte("hello"),
+			[]byte("trailing zeros\x00\x00"),
+			[]byte("trailing marker\x80"),
+			bytes.Repeat([]byte{0x80}, 64),
+		} {
+			padded := Pad(data, padding)
+			require.Equal(t, padding(len(data)), len(padded))
+
+			unpadded, err := Unpad(padded)
+			require.NoError(t, err)
+			require.True(t, bytes.Equal(data, unpadded))
+		}
+	}
+
+	require.Len(t, Pad([]byte("hello"), func(int) int { return 0 }), 6)
+
+	for _, invalid := range [][]byte{nil, {0x00, 0x00}, []byte("hello"), {0x80, 0x01, 0x00}} {
+		_, err := Unpad(invalid)
+		require.ErrorIs(t, err, ErrInvalidPadding)
+	}
+}
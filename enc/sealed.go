@@ -0,0 +1,219 @@
+package enc
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"fmt"
+	"math/big"
+
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+	pb "github.com/libp2p/go-libp2p/core/crypto/pb"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+
+	"berty.tech/go-ipfs-log/identityprovider"
+)
+
+const BoxKeySize = 32
+
+var (
+	ErrUnsupportedKeyType = fmt.Errorf("unsupported key type")
+	ErrNotRecipient       = fmt.Errorf("not a recipient")
+)
+
+// curve25519P is the prime of the field of curve25519, 2^255 - 19.
+var curve25519P = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// BoxPublicKey is the X25519 public key of a recipient.
+type BoxPublicKey [BoxKeySize]byte
+
+// BoxKeyPair is the X25519 key pair of a recipient, used to open the keys
+// sealed for it.
+type BoxKeyPair struct {
+	Public  *BoxPublicKey
+	private *[BoxKeySize]byte
+}
+
+// GenerateBoxKeyPair creates a random key pair.
+func GenerateBoxKeyPair() (*BoxKeyPair, error) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BoxKeyPair{Public: (*BoxPublicKey)(pub), private: priv}, nil
+}
+
+// NewBoxKeyPair creates a key pair from a X25519 private key.
+func NewBoxKeyPair(priv []byte) (*BoxKeyPair, error) {
+	if len(priv) != BoxKeySize {
+		return nil, ErrInvalidKey
+	}
+
+	pub, err := curve25519.X25519(priv, curve25519.Basepoint)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	out := &BoxKeyPair{Public: &BoxPublicKey{}, private: &[BoxKeySize]byte{}}
+	copy(out.Public[:], pub)
+	copy(out.private[:], priv)
+
+	return out, nil
+}
+
+// BoxKeyPairFromPrivKey converts an Ed25519 private key, such as the one of
+// an identity, to the matching X25519 key pair.
+func BoxKeyPairFromPrivKey(priv ic.PrivKey) (*BoxKeyPair, error) {
+	if priv == nil || priv.Type() != pb.KeyType_Ed25519 {
+		return nil, ErrUnsupportedKeyType
+	}
+
+	raw, err := priv.Raw()
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	// the X25519 scalar is the clamped hash of the seed, as done by Ed25519,
+	// clamping is applied by X25519 itself
+	digest := sha512.Sum512(raw[:32])
+
+	return NewBoxKeyPair(digest[:BoxKeySize])
+}
+
+// BoxPublicKeyFromPubKey converts an Ed25519 public key to the matching
+// X25519 public key.
+func BoxPublicKeyFromPubKey(pub ic.PubKey) (*BoxPublicKey, error) {
+	if pub == nil || pub.Type() != pb.KeyType_Ed25519 {
+		return nil, ErrUnsupportedKeyType
+	}
+
+	raw, err := pub.Raw()
+	if err != nil || len(raw) != BoxKeySize {
+		return nil, ErrInvalidKey
+	}
+
+	// the Edwards y coordinate is encoded in little endian, the top bit
+	// holding the sign of x
+	le := make([]byte, BoxKeySize)
+	for i := range raw {
+		le[BoxKeySize-1-i] = raw[i]
+	}
+	le[0] &= 0x7f
+
+	// u = (1 + y) / (1 - y)
+	y := new(big.Int).SetBytes(le)
+	den := new(big.Int).Sub(big.NewInt(1), y)
+	den.Mod(den, curve25519P)
+	if den.Sign() == 0 {
+		return nil, ErrInvalidKey
+	}
+
+	u := new(big.Int).Add(big.NewInt(1), y)
+	u.Mul(u, den.ModInverse(den, curve25519P))
+	u.Mod(u, curve25519P)
+
+	out := &BoxPublicKey{}
+	be := u.FillBytes(make([]byte, BoxKeySize))
+	for i := range be {
+		out[BoxKeySize-1-i] = be[i]
+	}
+
+	return out, nil
+}
+
+// BoxPublicKeyFromIdentity returns the X25519 public key of an identity.
+//
+// The identity must use Ed25519 keys, created with the KeyType option of
+// identityprovider.CreateIdentity. Identities using the default secp256k1
+// keys fail with ErrUnsupportedKeyType, their members need a key pair of
+// their own, such as one created with GenerateBoxKeyPair.
+func BoxPublicKeyFromIdentity(identity *identityprovider.Identity) (*BoxPublicKey, error) {
+	if identity == nil {
+		return nil, ErrInvalidKey
+	}
+
+	var (
+		pub ic.PubKey
+		err error
+	)
+
+	if identity.Provider != nil {
+		pub, err = identity.Provider.UnmarshalPublicKey(identity.PublicKey)
+	} else {
+		pub, err = identity.GetPublicKey()
+	}
+
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+
+	if pub.Type() != pb.KeyType_Ed25519 {
+		return nil, fmt.Errorf("%w: %s identity, an Ed25519 identity is required", ErrUnsupportedKeyType, pub.Type())
+	}
+
+	return BoxPublicKeyFromPubKey(pub)
+}
+
+// SealForRecipients wraps a key in an anonymous sealed box for each of the
+// recipients, the recipients can't be told from the output.
+func SealForRecipients(key []byte, recipients []*BoxPublicKey) ([][]byte, error) {
+	out := make([][]byte, len(recipients))
+
+	for i, r := range recipients {
+		if r == nil {
+			return nil, ErrInvalidKey
+		}
+
+		sealed, err := box.SealAnonymous(nil, key, (*[BoxKeySize]byte)(r), rand.Reader)
+		if err != nil {
+			return nil, ErrCannotEncrypt
+		}
+
+		out[i] = sealed
+	}
+
+	return out, nil
+}
+
+// OpenAsRecipient unwraps a key sealed by SealForRecipients, by trying each
+// sealed box with the key pair.
+func OpenAsRecipient(sealed [][]byte, kp *BoxKeyPair) ([]byte, error) {
+	if kp == nil {
+		return nil, ErrInvalidKey
+	}
+
+	for _, s := range sealed {
+		if key, ok := box.OpenAnonymous(nil, s, (*[BoxKeySize]byte)(kp.Public), kp.private); ok {
+			return key, nil
+		}
+	}
+
+	return nil, ErrNotRecipient
+}
+
+// SealPseudonymProof seals the proof linking a pseudonym to its identity for
+// the verifiers allowed to link them.
+func SealPseudonymProof(proof *identityprovider.PseudonymProof, recipients []*BoxPublicKey) ([][]byte, error) {
+	if proof == nil {
+		return nil, ErrCannotEncrypt
+	}
+
+	data, err := proof.Marshal()
+	if err != nil {
+		return nil, ErrCannotEncrypt
+	}
+
+	return SealForRecipients(data, recipients)
+}
+
+// OpenPseudonymProof opens a proof sealed by SealPseudonymProof, it must be
+// checked using identityprovider.VerifyPseudonymProof.
+func OpenPseudonymProof(sealed [][]byte, kp *BoxKeyPair) (*identityprovider.PseudonymProof, error) {
+	data, err := OpenAsRecipient(sealed, kp)
+	if err != nil {
+		return nil, err
+	}
+
+	return identityprovider.UnmarshalPseudonymProof(data)
+}
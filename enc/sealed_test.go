@@ -0,0 +1,108 @@
+package enc
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/stretchr/testify/require"
+
+	"berty.tech/go-ipfs-log/identityprovider"
+	"berty.tech/go-ipfs-log/keystore"
+)
+
+func TestBoxKeysFromEd25519(t *testing.T) {
+	priv, pub, err := ic.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+
+	kp, err := BoxKeyPairFromPrivKey(priv)
+	require.NoError(t, err)
+
+	boxPub, err := BoxPublicKeyFromPubKey(pub)
+	require.NoError(t, err)
+	require.Equal(t, kp.Public, boxPub)
+
+	secpPriv, secpPub, err := ic.GenerateSecp256k1Key(rand.Reader)
+	require.NoError(t, err)
+
+	_, err = BoxKeyPairFromPrivKey(secpPriv)
+	require.ErrorIs(t, err, ErrUnsupportedKeyType)
+
+	_, err = BoxPublicKeyFromPubKey(secpPub)
+	require.ErrorIs(t, err, ErrUnsupportedKeyType)
+}
+
+func TestBoxPublicKeyFromIdentity(t *testing.T) {
+	ctx := context.Background()
+
+	ks, err := keystore.NewKeystore(dssync.MutexWrap(ds.NewMapDatastore()))
+	require.NoError(t, err)
+
+	identity, err := identityprovider.CreateIdentity(ctx, &identityprovider.CreateIdentityOptions{
+		Keystore: ks,
+		ID:       "userA",
+		Type:     "orbitdb",
+		KeyType:  keystore.KeyTypeEd25519,
+	})
+	require.NoError(t, err)
+
+	priv, err := ks.GetKey(ctx, identity.ID)
+	require.NoError(t, err)
+
+	kp, err := BoxKeyPairFromPrivKey(priv)
+	require.NoError(t, err)
+
+	pub, err := BoxPublicKeyFromIdentity(identity)
+	require.NoError(t, err)
+	require.Equal(t, kp.Public, pub)
+
+	identity, err = identityprovider.CreateIdentity(ctx, &identityprovider.CreateIdentityOptions{
+		Keystore: ks,
+		ID:       "userB",
+		Type:     "orbitdb",
+	})
+	require.NoError(t, err)
+
+	_, err = BoxPublicKeyFromIdentity(identity)
+	require.ErrorIs(t, err, ErrUnsupportedKeyType)
+	require.Contains(t, err.Error(), "Ed25519")
+
+	_, err = BoxPublicKeyFromIdentity(nil)
+	require.ErrorIs(t, err, ErrInvalidKey)
+}
+
+func TestSealForRecipients(t *testing.T) {
+	alice, err := GenerateBoxKeyPair()
+	require.NoError(t, err)
+
+	bob, err := GenerateBoxKeyPair()
+	require.NoError(t, err)
+
+	eve, err := GenerateBoxKeyPair()
+	require.NoError(t, err)
+
+	bobFromPriv, err := NewBoxKeyPair(bob.private[:])
+	require.NoError(t, err)
+	require.Equal(t, bob.Public, bobFromPriv.Public)
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	sealed, err := SealForRecipients(key, []*BoxPublicKey{alice.Public, bob.Public})
+	require.NoError(t, err)
+	require.Len(t, sealed, 2)
+
+	for _, kp := range []*BoxKeyPair{alice, bob, bobFromPriv} {
+		opened, err := OpenAsRecipient(sealed, kp)
+		require.NoError(t, err)
+		require.Equal(t, key, opened)
+	}
+
+	_, err = OpenAsRecipient(sealed, eve)
+	require.ErrorIs(t, err, ErrNotRecipient)
+
+	_, err = SealForRecipients(key, []*BoxPublicKey{nil})
+	require.Error(t, err)
+}
@@ -8,7 +8,6 @@ import (
 	"sort"
 
 	"github.com/ipfs/go-cid"
-	coreiface "github.com/ipfs/kubo/core/coreiface"
 	"github.com/multiformats/go-multibase"
 
 	"berty.tech/go-ipfs-log/errmsg"
@@ -134,7 +133,7 @@ func (e *Entry) SetAdditionalDataValue(key string, value string) {
 	e.AdditionalData[key] = value
 }
 
-func CreateEntry(ctx context.Context, ipfsInstance coreiface.CoreAPI, identity *identityprovider.Identity, data *Entry, opts *iface.CreateEntryOptions) (iface.IPFSLogEntry, error) {
+func CreateEntry(ctx context.Context, ipfsInstance iface.Storage, identity *identityprovider.Identity, data *Entry, opts *iface.CreateEntryOptions) (iface.IPFSLogEntry, error) {
 	io, err := cbor.IO(&Entry{}, &LamportClock{})
 	if err != nil {
 		return nil, err
@@ -144,7 +143,7 @@ func CreateEntry(ctx context.Context, ipfsInstance coreiface.CoreAPI, identity *
 }
 
 // CreateEntryWithIO creates an Entry.
-func CreateEntryWithIO(ctx context.Context, ipfsInstance coreiface.CoreAPI, identity *identityprovider.Identity, data iface.IPFSLogEntry, opts *iface.CreateEntryOptions, io iface.IO) (iface.IPFSLogEntry, error) {
+func CreateEntryWithIO(ctx context.Context, ipfsInstance iface.Storage, identity *identityprovider.Identity, data iface.IPFSLogEntry, opts *iface.CreateEntryOptions, io iface.IO) (iface.IPFSLogEntry, error) {
 	if ipfsInstance == nil {
 		return nil, errmsg.ErrIPFSNotDefined
 	}
@@ -321,10 +320,25 @@ func ToHashable(e iface.IPFSLogEntry) (*iface.Hashable, error) {
 		refs[i] = c
 	}
 
+	payload := e.GetPayload()
+
+	// encrypted values are signed instead of the clear ones, which are
+	// contained in the additional data. The writers leave the clear links
+	// of such entries empty, the IOs reject them otherwise. Entries written
+	// before the links version was recorded sign their clear links.
+	add := e.GetAdditionalData()
+	if _, ok := add[iface.KeyEncryptedLinks]; ok && add[iface.KeyEncryptedLinksVersion] == iface.EncryptedLinksSigned {
+		nexts, refs = []string{}, []string{}
+	}
+
+	if _, ok := add[iface.KeyEncryptedPayload]; ok {
+		payload = nil
+	}
+
 	return &iface.Hashable{
 		Hash:           nil,
 		ID:             e.GetLogID(),
-		Payload:        e.GetPayload(),
+		Payload:        payload,
 		Next:           nexts,
 		Refs:           refs,
 		V:              e.GetV(),
@@ -356,7 +370,7 @@ func (e *Entry) Verify(identity identityprovider.Interface, io iface.IO) error {
 	}
 
 	// TODO: Check against trusted keys
-	var verifiedEntry iface.IPFSLogEntry
+	verifiedEntry := iface.IPFSLogEntry(e)
 	if io, ok := io.(iface.IOPreSign); ok {
 		var err error
 		verifiedEntry, err = io.PreSign(e)
@@ -376,6 +390,18 @@ func (e *Entry) Verify(identity identityprovider.Interface, io iface.IO) error {
 		return errmsg.ErrEntryNotHashable.Wrap(err)
 	}
 
+	// the key is serialized by the provider of the author, which can differ
+	// from the one of the verifier
+	if io, ok := io.(iface.IORegistry); ok && io.IdentityRegistry() != nil && e.Identity != nil {
+		if identity, err = io.IdentityRegistry().ProviderOf(e.Identity, identity); err != nil {
+			return errmsg.ErrInvalidPubKeyFormat.Wrap(err)
+		}
+	} else if e.Identity != nil && e.Identity.Type != "" && e.Identity.Type != identity.GetType() {
+		if identity, err = identityprovider.ProviderFor(e.Identity.Type); err != nil {
+			return errmsg.ErrInvalidPubKeyFormat.Wrap(err)
+		}
+	}
+
 	pubKey, err := identity.UnmarshalPublicKey(e.Key)
 	if err != nil {
 		return errmsg.ErrInvalidPubKeyFormat.Wrap(err)
@@ -390,11 +416,18 @@ func (e *Entry) Verify(identity identityprovider.Interface, io iface.IO) error {
 		return errmsg.ErrSigNotVerified
 	}
 
+	// the key must be valid for the identity at the time of the entry
+	if e.Identity != nil && e.Clock != nil {
+		if err := identityprovider.CheckKey(e.Identity, e.Key, e.Clock.GetTime()); err != nil {
+			return errmsg.ErrSigNotVerified.Wrap(err)
+		}
+	}
+
 	return nil
 }
 
 // ToMultihash gets the multihash of an Entry.
-func (e *Entry) ToMultihash(ctx context.Context, ipfsInstance coreiface.CoreAPI, opts *iface.CreateEntryOptions) (cid.Cid, error) {
+func (e *Entry) ToMultihash(ctx context.Context, ipfsInstance iface.Storage, opts *iface.CreateEntryOptions) (cid.Cid, error) {
 	io, err := cbor.IO(&Entry{}, &LamportClock{})
 	if err != nil {
 		return cid.Undef, err
@@ -404,7 +437,7 @@ func (e *Entry) ToMultihash(ctx context.Context, ipfsInstance coreiface.CoreAPI,
 }
 
 // ToMultihashWithIO gets the multihash of an Entry.
-func ToMultihashWithIO(ctx context.Context, e iface.IPFSLogEntry, ipfsInstance coreiface.CoreAPI, opts *iface.CreateEntryOptions, io iface.IO) (cid.Cid, error) {
+func ToMultihashWithIO(ctx context.Context, e iface.IPFSLogEntry, ipfsInstance iface.Storage, opts *iface.CreateEntryOptions, io iface.IO) (cid.Cid, error) {
 	if opts == nil {
 		opts = &iface.CreateEntryOptions{}
 	}
@@ -468,7 +501,7 @@ func Normalize(e iface.IPFSLogEntry, opts *normalizeEntryOpts) *Entry {
 }
 
 // FromMultihash creates an Entry from a hash.
-func FromMultihash(ctx context.Context, ipfs coreiface.CoreAPI, hash cid.Cid, provider identityprovider.Interface) (iface.IPFSLogEntry, error) {
+func FromMultihash(ctx context.Context, ipfs iface.Storage, hash cid.Cid, provider identityprovider.Interface) (iface.IPFSLogEntry, error) {
 	io, err := cbor.IO(&Entry{}, &LamportClock{})
 	if err != nil {
 		return nil, err
@@ -478,7 +511,7 @@ func FromMultihash(ctx context.Context, ipfs coreiface.CoreAPI, hash cid.Cid, pr
 }
 
 // FromMultihashWithIO creates an Entry from a hash.
-func FromMultihashWithIO(ctx context.Context, ipfs coreiface.CoreAPI, hash cid.Cid, provider identityprovider.Interface, io iface.IO) (iface.IPFSLogEntry, error) {
+func FromMultihashWithIO(ctx context.Context, ipfs iface.Storage, hash cid.Cid, provider identityprovider.Interface, io iface.IO) (iface.IPFSLogEntry, error) {
 	if ipfs == nil {
 		return nil, errmsg.ErrIPFSNotDefined
 	}
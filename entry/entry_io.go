@@ -4,7 +4,6 @@ import (
 	"context"
 
 	"github.com/ipfs/go-cid"
-	coreiface "github.com/ipfs/kubo/core/coreiface"
 
 	"berty.tech/go-ipfs-log/iface"
 )
@@ -13,13 +12,13 @@ type FetchOptions = iface.FetchOptions
 
 // FetchParallel has the same comportement than FetchAll, we keep it for retrop
 // compatibility purpose
-func FetchParallel(ctx context.Context, ipfs coreiface.CoreAPI, hashes []cid.Cid, options *FetchOptions) []iface.IPFSLogEntry {
+func FetchParallel(ctx context.Context, ipfs iface.Storage, hashes []cid.Cid, options *FetchOptions) []iface.IPFSLogEntry {
 	fetcher := NewFetcher(ipfs, options)
 	return fetcher.Fetch(ctx, hashes)
 }
 
 // FetchAll gets entries from their CIDs.
-func FetchAll(ctx context.Context, ipfs coreiface.CoreAPI, hashes []cid.Cid, options *FetchOptions) []iface.IPFSLogEntry {
+func FetchAll(ctx context.Context, ipfs iface.Storage, hashes []cid.Cid, options *FetchOptions) []iface.IPFSLogEntry {
 	fetcher := NewFetcher(ipfs, options)
 	return fetcher.Fetch(ctx, hashes)
 }
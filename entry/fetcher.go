@@ -9,7 +9,6 @@ import (
 	"berty.tech/go-ipfs-log/iface"
 	"berty.tech/go-ipfs-log/io/cbor"
 	"github.com/ipfs/go-cid"
-	coreiface "github.com/ipfs/kubo/core/coreiface"
 	"golang.org/x/sync/semaphore"
 )
 
@@ -39,11 +38,11 @@ type Fetcher struct {
 	condProcess   *sync.Cond
 	muProcess     *sync.RWMutex
 	sem           *semaphore.Weighted
-	ipfs          coreiface.CoreAPI
+	ipfs          iface.Storage
 	progressChan  chan iface.IPFSLogEntry
 }
 
-func NewFetcher(ipfs coreiface.CoreAPI, options *FetchOptions) *Fetcher {
+func NewFetcher(ipfs iface.Storage, options *FetchOptions) *Fetcher {
 	// set default
 	length := -1
 	if options.Length != nil {
@@ -20,6 +20,8 @@ const (
 	ErrEmptyLogSerialization        = Error("can't serialize an empty log")
 	ErrEntriesNotDefined            = Error("entries not defined")
 	ErrEntryDeserializationFailed   = Error("entry deserialization failed")
+	ErrEntryLinksNotSigned          = Error("entry has clear links not covered by its signature")
+	ErrEntryClockDecreasing         = Error("entry clock time is lower than the one of its next entries")
 	ErrEntryNotDefined              = Error("entry is not defined")
 	ErrEntryNotHashable             = Error("entry is hashable")
 	ErrFetchOptionsNotDefined       = Error("fetch options not defined")
@@ -41,11 +43,25 @@ const (
 	ErrKeyDeserialization           = Error("unable to deserialize key")
 	ErrKeyGenerationFailed          = Error("key generation failed")
 	ErrKeyNotDefined                = Error("key is not defined")
+	ErrKeyNotValid                  = Error("key is not valid for the identity at this time")
+	ErrKeyRevoked                   = Error("key is revoked")
+	ErrKeyStatementNotVerified      = Error("key statement could not be verified")
+	ErrCertificateNotVerified       = Error("device certificate could not be verified")
+	ErrPseudonymProofNotVerified    = Error("pseudonym proof could not be verified")
 	ErrKeyNotInKeystore             = Error("private signing key not found from Keystore")
 	ErrKeyStoreCreateEntry          = Error("unable to create key store entry")
 	ErrKeyStoreInitFailed           = Error("keystore initialization failed")
 	ErrKeyStorePutFailed            = Error("keystore put failed")
+	ErrKeyStoreQueryFailed          = Error("keystore query failed")
+	ErrKeyStoreDeleteFailed         = Error("keystore delete failed")
+	ErrKeyAlreadyInKeystore         = Error("a key with this ID already exists in Keystore")
+	ErrKeyExportFailed              = Error("key export failed")
+	ErrKeyImportFailed              = Error("key import failed")
+	ErrPassphraseRequired           = Error("a passphrase is required")
+	ErrInvalidMnemonic              = Error("invalid mnemonic")
 	ErrKeystoreNotDefined           = Error("keystore not defined")
+	ErrKeystoreLocked               = Error("keystore is locked")
+	ErrKeystoreUnlockFailed         = Error("unable to unlock keystore")
 	ErrLogAppendDenied              = Error("log append denied")
 	ErrLogAppendFailed              = Error("log append failed")
 	ErrLogFromEntry                 = Error("new from entry failed")
@@ -55,6 +71,7 @@ const (
 	ErrLogIDNotDefined              = Error("log ID not defined")
 	ErrLogJoinFailed                = Error("log join failed")
 	ErrLogJoinNotDefined            = Error("log to join not defined")
+	ErrLogMigrationFailed           = Error("log migration failed")
 	ErrLogOptionsNotDefined         = Error("log options not defined")
 	ErrLogTraverseFailed            = Error("log traverse failed")
 	ErrMultibaseOperationFailed     = Error("Multibase operation failed")
@@ -67,6 +84,7 @@ const (
 	ErrSigNotDefined                = Error("signature is not defined")
 	ErrSigNotVerified               = Error("signature could not verified")
 	ErrSigSign                      = Error("unable to sign value")
+	ErrSignerUnavailable            = Error("signer is unavailable")
 	ErrTiebreakerBogus              = Error("log's tiebreaker function has returned zero and therefore cannot be")
 	ErrTiebreakerFailed             = Error("tiebreaker failed")
 	ErrIPFSWriteFailed              = Error("ipfs write failed")
@@ -75,4 +93,12 @@ const (
 	ErrPBReadUnmarshalFailed        = Error("protobuf unmarshal failed")
 	ErrEncrypt                      = Error("encryption error")
 	ErrDecrypt                      = Error("decryption error")
+	ErrCodecNotSupported            = Error("codec is not supported")
+	ErrIPLDOperationFailed          = Error("IPLD operation failed")
+	ErrEntryVersionNotSupported     = Error("entry version is not supported")
+	ErrGroupKeyFailed               = Error("group key operation failed")
+	ErrGroupNoEpoch                 = Error("group has no epoch yet")
+	ErrHeadsMismatch                = Error("heads don't belong to the log")
+	ErrUnsupportedKeyType           = Error("key type is not supported")
+	ErrUnsupportedFormat            = Error("format is not supported")
 )
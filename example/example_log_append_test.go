@@ -7,6 +7,7 @@ import (
 
 	idp "berty.tech/go-ipfs-log/identityprovider"
 	"berty.tech/go-ipfs-log/keystore"
+	"berty.tech/go-ipfs-log/storage"
 	"github.com/ipfs/go-datastore"
 	dssync "github.com/ipfs/go-datastore/sync"
 	config "github.com/ipfs/kubo/config"
@@ -129,7 +130,7 @@ func Example_logAppend() {
 	}
 
 	// creating log
-	logA, err := log.NewLog(serviceA, identityA, &log.LogOptions{ID: "A"})
+	logA, err := log.NewLog(storage.NewCoreAPI(serviceA), identityA, &log.LogOptions{ID: "A"})
 	if err != nil {
 		panic(err)
 	}
@@ -145,7 +146,7 @@ func Example_logAppend() {
 		panic(fmt.Errorf("ToMultihash error: %s", err))
 	}
 
-	res, err := log.NewFromMultihash(ctx, serviceB, identityB, h, &log.LogOptions{}, &log.FetchOptions{})
+	res, err := log.NewFromMultihash(ctx, storage.NewCoreAPI(serviceB), identityB, h, &log.LogOptions{}, &log.FetchOptions{})
 	if err != nil {
 		panic(fmt.Errorf("NewFromMultihash error: %s", err))
 	}
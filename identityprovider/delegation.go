@@ -0,0 +1,304 @@
+package identityprovider // import "berty.tech/go-ipfs-log/identityprovider"
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+
+	"berty.tech/go-ipfs-log/errmsg"
+	"berty.tech/go-ipfs-log/keystore"
+)
+
+// CapabilityDelegate allows a device to delegate its key to other devices.
+const CapabilityDelegate = "delegate"
+
+// DeviceCertificate is a statement of an identity delegating its entries to
+// the key of a device, which signs them on its behalf. A device can delegate
+// its key in turn when it has the CapabilityDelegate capability, the
+// certificates of a device form a chain starting at a key of the identity.
+type DeviceCertificate struct {
+	PublicKey []byte `json:"publicKey,omitempty"`
+	IssuedBy  []byte `json:"issuedBy,omitempty"`
+
+	// Expiry is the clock time after which the entries signed using the
+	// device key are rejected, 0 if it doesn't expire. The clock time of an
+	// entry is chosen by its signer and joined entries can't have a lower
+	// time than their next entries, so an expired device can still sign
+	// entries with an earlier time as long as they don't follow later ones.
+	Expiry int `json:"expiry,omitempty"`
+
+	// Capabilities restricts what the device can do, all capabilities are
+	// granted if empty. They are checked by the access controllers.
+	Capabilities []string `json:"capabilities,omitempty"`
+
+	Signature []byte `json:"signature,omitempty"`
+}
+
+type DelegateOptions struct {
+	Keystore keystore.Interface
+	Signer   Signer
+
+	// PublicKey is the key of the device.
+	PublicKey ic.PubKey
+
+	Expiry       int
+	Capabilities []string
+}
+
+func (c *DeviceCertificate) signedBytes(id string) ([]byte, error) {
+	return json.Marshal(struct {
+		Statement    string   `json:"statement"`
+		ID           string   `json:"id"`
+		PublicKey    []byte   `json:"publicKey"`
+		IssuedBy     []byte   `json:"issuedBy"`
+		Expiry       int      `json:"expiry"`
+		Capabilities []string `json:"capabilities"`
+	}{"device-certificate", id, c.PublicKey, c.IssuedBy, c.Expiry, c.Capabilities})
+}
+
+func (c *DeviceCertificate) allows(capability string) bool {
+	if len(c.Capabilities) == 0 {
+		return true
+	}
+
+	for _, granted := range c.Capabilities {
+		if granted == capability {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Delegate signs a certificate for the key of a device using the current key
+// of an identity, the device gets its identity using NewDeviceIdentity.
+func Delegate(ctx context.Context, identity *Identity, options *DelegateOptions) (*DeviceCertificate, error) {
+	if identity == nil {
+		return nil, errmsg.ErrIdentityNotDefined
+	}
+
+	if options == nil || options.PublicKey == nil {
+		return nil, errmsg.ErrKeyNotDefined
+	}
+
+	if !identity.HasCapability(CapabilityDelegate) {
+		return nil, errmsg.ErrCertificateNotVerified
+	}
+
+	signer := signerOf(&CreateIdentityOptions{Keystore: options.Keystore, Signer: options.Signer})
+	if signer == nil {
+		return nil, errmsg.ErrKeystoreNotDefined
+	}
+
+	publicKeyBytes, err := identity.marshalPublicKey(options.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	certificate := &DeviceCertificate{
+		PublicKey:    publicKeyBytes,
+		IssuedBy:     identity.PublicKey,
+		Expiry:       options.Expiry,
+		Capabilities: options.Capabilities,
+	}
+
+	data, err := certificate.signedBytes(identity.ID)
+	if err != nil {
+		return nil, errmsg.ErrJSONSerializationFailed.Wrap(err)
+	}
+
+	if certificate.Signature, err = signer.Sign(ctx, identity.keyID(), data); err != nil {
+		return nil, errmsg.ErrSigSign.Wrap(err)
+	}
+
+	return certificate, nil
+}
+
+// NewDeviceIdentity creates the identity of a device from the identity which
+// delegated its key. The key is named options.ID in the keystore of the
+// device, entries signed using it are attributed to the identity.
+func NewDeviceIdentity(ctx context.Context, identity *Identity, certificate *DeviceCertificate, options *CreateIdentityOptions) (*Identity, error) {
+	if identity == nil {
+		return nil, errmsg.ErrIdentityNotDefined
+	}
+
+	if certificate == nil {
+		return nil, errmsg.ErrCertificateNotVerified
+	}
+
+	signer := signerOf(options)
+	if signer == nil {
+		return nil, errmsg.ErrKeystoreNotDefined
+	}
+
+	registry := options.Registry
+	if registry == nil {
+		registry = defaultRegistry
+	}
+
+	newIdentityProvider, err := registry.handlerFor(identity.Type)
+	if err != nil {
+		return nil, errmsg.ErrIdentityProviderNotSupported.Wrap(err)
+	}
+
+	device := identity.copy()
+	device.Provider = newIdentityProvider(options)
+
+	publicKey, err := signer.PublicKey(ctx, options.ID, options.KeyType)
+	if err != nil {
+		return nil, errmsg.ErrKeyStoreCreateEntry.Wrap(err)
+	}
+
+	publicKeyBytes, err := device.marshalPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(publicKeyBytes, certificate.PublicKey) {
+		return nil, errmsg.ErrKeyNotValid
+	}
+
+	device.PublicKey = publicKeyBytes
+	device.KeyID = options.ID
+	device.Certificates = append(device.Certificates, certificate)
+
+	if _, err := device.checkCertificates(); err != nil {
+		return nil, err
+	}
+
+	if err := (*KeyHistory)(nil).verifyCertificates(device); err != nil {
+		return nil, err
+	}
+
+	return device, nil
+}
+
+// IsDevice checks whether the key of an identity is delegated to a device.
+func (i *Identity) IsDevice() bool {
+	return len(i.Certificates) > 0
+}
+
+// HasCapability checks whether the certificates of a device grant a
+// capability, identities which aren't devices have all the capabilities.
+func (i *Identity) HasCapability(capability string) bool {
+	for _, c := range i.Certificates {
+		if !c.allows(capability) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// identityKey returns the current key of the identity, which delegated its
+// key to the device for device identities.
+func (i *Identity) identityKey() []byte {
+	if len(i.Certificates) > 0 {
+		return i.Certificates[0].IssuedBy
+	}
+
+	return i.PublicKey
+}
+
+// checkCertificates checks the chain of the certificates of a device, it
+// returns the clock time after which the device key expires, 0 if it
+// doesn't.
+func (i *Identity) checkCertificates() (int, error) {
+	if len(i.Certificates) == 0 {
+		return 0, nil
+	}
+
+	expiry := 0
+
+	for idx, c := range i.Certificates {
+		if c == nil {
+			return 0, errmsg.ErrCertificateNotVerified
+		}
+
+		if idx > 0 {
+			issuer := i.Certificates[idx-1]
+			if !bytes.Equal(c.IssuedBy, issuer.PublicKey) || !issuer.allows(CapabilityDelegate) {
+				return 0, errmsg.ErrCertificateNotVerified
+			}
+		}
+
+		if c.Expiry > 0 && (expiry == 0 || c.Expiry < expiry) {
+			expiry = c.Expiry
+		}
+	}
+
+	if !bytes.Equal(i.Certificates[len(i.Certificates)-1].PublicKey, i.PublicKey) {
+		return 0, errmsg.ErrCertificateNotVerified
+	}
+
+	return expiry, nil
+}
+
+// verifyCertificates verifies the signatures of the certificates of a
+// device which aren't already known.
+func (h *KeyHistory) verifyCertificates(identity *Identity) error {
+	if h != nil {
+		h.lock.RLock()
+		defer h.lock.RUnlock()
+	}
+
+	for _, c := range identity.Certificates {
+		data, err := c.signedBytes(identity.ID)
+		if err != nil {
+			return errmsg.ErrJSONSerializationFailed.Wrap(err)
+		}
+
+		if h.isKnown(data, c.Signature) {
+			continue
+		}
+
+		if err := identity.verifyWithKey(c.IssuedBy, data, c.Signature); err != nil {
+			return errmsg.ErrCertificateNotVerified.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// checkDevice checks that the key of a device is valid at a clock time and
+// not revoked by the identity or by the devices which delegated it.
+func checkDevice(identity *Identity, periods []keyPeriod, revocations []*KeyRevocation, time int) error {
+	expiry, err := identity.checkCertificates()
+	if err != nil {
+		return err
+	}
+
+	if expiry > 0 && time > expiry {
+		return errmsg.ErrKeyNotValid
+	}
+
+	for idx, c := range identity.Certificates {
+		for _, r := range revocations {
+			if !bytes.Equal(r.PublicKey, c.PublicKey) || time <= r.After {
+				continue
+			}
+
+			if indexOfKey(periods, r.SignedBy) >= 0 {
+				return errmsg.ErrKeyRevoked
+			}
+
+			for _, issuer := range identity.Certificates[:idx+1] {
+				if bytes.Equal(r.SignedBy, issuer.PublicKey) {
+					return errmsg.ErrKeyRevoked
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (i *Identity) marshalPublicKey(publicKey ic.PubKey) ([]byte, error) {
+	if marshaler, ok := i.Provider.(PublicKeyMarshaler); ok {
+		return marshaler.MarshalPublicKey(publicKey)
+	}
+
+	return MarshalPublicKey(publicKey)
+}
@@ -0,0 +1,228 @@
+package identityprovider // import "berty.tech/go-ipfs-log/identityprovider"
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/multiformats/go-multibase"
+	"github.com/multiformats/go-multicodec"
+
+	"berty.tech/go-ipfs-log/errmsg"
+)
+
+// DIDKeyType is the type of the identities created by DIDKeyIdentityProvider.
+const DIDKeyType = "did:key"
+
+const didKeyPrefix = "did:key:"
+
+// DIDKeyIdentityProvider creates identities whose ID is the did:key of the
+// key of CreateIdentityOptions.ID.
+//
+// The public key of the identities is multicodec encoded, as in a did:key,
+// and is signed by the key of the DID, so the authorship of the entries can
+// be verified from the DID alone.
+type DIDKeyIdentityProvider struct {
+	signer Signer
+}
+
+// NewDIDKeyIdentityProvider creates a new did:key identity provider.
+func NewDIDKeyIdentityProvider(options *CreateIdentityOptions) Interface {
+	return &DIDKeyIdentityProvider{
+		signer: signerOf(options),
+	}
+}
+
+// GetID returns the did:key of the key of options.ID.
+func (p *DIDKeyIdentityProvider) GetID(ctx context.Context, options *CreateIdentityOptions) (string, error) {
+	public, err := p.signer.PublicKey(ctx, options.ID, options.KeyType)
+	if err != nil {
+		return "", errmsg.ErrKeyStoreCreateEntry.Wrap(err)
+	}
+
+	return DIDKeyFromPublicKey(public)
+}
+
+// SignIdentity signs the public key of an identity and its ID signature
+// using the key of the DID.
+func (p *DIDKeyIdentityProvider) SignIdentity(ctx context.Context, data []byte, id string) ([]byte, error) {
+	signature, err := p.signer.Sign(ctx, id, data)
+	if err != nil {
+		return nil, errmsg.ErrSigSign.Wrap(err)
+	}
+
+	return signature, nil
+}
+
+// VerifyIdentity checks that the public key of an identity is signed by the
+// key of its DID.
+func (p *DIDKeyIdentityProvider) VerifyIdentity(identity *Identity) error {
+	if identity.Signatures == nil {
+		return errmsg.ErrSigNotDefined
+	}
+
+	didKey, err := PublicKeyFromDIDKey(identity.ID)
+	if err != nil {
+		return errmsg.ErrIdentityDeserialization.Wrap(err)
+	}
+
+	data := append(append([]byte{}, identity.PublicKey...), identity.Signatures.ID...)
+
+	ok, err := didKey.Verify(data, identity.Signatures.PublicKey)
+	if err != nil {
+		return errmsg.ErrSigNotVerified.Wrap(err)
+	}
+
+	if !ok {
+		return errmsg.ErrSigNotVerified
+	}
+
+	return nil
+}
+
+// Sign signs a value using the key of the identity.
+func (p *DIDKeyIdentityProvider) Sign(ctx context.Context, identity *Identity, data []byte) ([]byte, error) {
+	sig, err := p.signer.Sign(ctx, identity.keyID(), data)
+	if err != nil {
+		return nil, errmsg.ErrSigSign.Wrap(err)
+	}
+
+	return sig, nil
+}
+
+// MarshalPublicKey serializes a public key using its multicodec.
+func (p *DIDKeyIdentityProvider) MarshalPublicKey(publicKey crypto.PubKey) ([]byte, error) {
+	return marshalMulticodecPublicKey(publicKey)
+}
+
+// UnmarshalPublicKey reads a multicodec encoded public key.
+func (p *DIDKeyIdentityProvider) UnmarshalPublicKey(data []byte) (crypto.PubKey, error) {
+	return unmarshalMulticodecPublicKey(data)
+}
+
+// GetType returns the current identity type.
+func (*DIDKeyIdentityProvider) GetType() string {
+	return DIDKeyType
+}
+
+// DIDKeyFromPublicKey returns the did:key of a public key, Ed25519,
+// secp256k1 and P-256 keys are supported.
+func DIDKeyFromPublicKey(publicKey crypto.PubKey) (string, error) {
+	data, err := marshalMulticodecPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	encoded, err := multibase.Encode(multibase.Base58BTC, data)
+	if err != nil {
+		return "", errmsg.ErrPubKeySerialization.Wrap(err)
+	}
+
+	return didKeyPrefix + encoded, nil
+}
+
+// PublicKeyFromDIDKey returns the public key of a did:key.
+func PublicKeyFromDIDKey(did string) (crypto.PubKey, error) {
+	if !strings.HasPrefix(did, didKeyPrefix) {
+		return nil, errmsg.ErrInvalidPubKeyFormat.Wrap(fmt.Errorf("not a did:key"))
+	}
+
+	encoding, data, err := multibase.Decode(strings.TrimPrefix(did, didKeyPrefix))
+	if err != nil {
+		return nil, errmsg.ErrInvalidPubKeyFormat.Wrap(err)
+	}
+
+	if encoding != multibase.Base58BTC {
+		return nil, errmsg.ErrInvalidPubKeyFormat.Wrap(fmt.Errorf("did:key must be base58btc encoded"))
+	}
+
+	return unmarshalMulticodecPublicKey(data)
+}
+
+func marshalMulticodecPublicKey(publicKey crypto.PubKey) ([]byte, error) {
+	var (
+		codec multicodec.Code
+		raw   []byte
+		err   error
+	)
+
+	switch publicKey.Type() {
+	case crypto.Ed25519:
+		codec = multicodec.Ed25519Pub
+		raw, err = publicKey.Raw()
+
+	case crypto.Secp256k1:
+		// libp2p serializes secp256k1 keys compressed as expected
+		codec = multicodec.Secp256k1Pub
+		raw, err = publicKey.Raw()
+
+	case crypto.ECDSA:
+		codec = multicodec.P256Pub
+
+		std, stdErr := crypto.PubKeyToStdKey(publicKey)
+		if stdErr != nil {
+			return nil, errmsg.ErrPubKeySerialization.Wrap(stdErr)
+		}
+
+		key, ok := std.(*ecdsa.PublicKey)
+		if !ok || key.Curve != elliptic.P256() {
+			return nil, errmsg.ErrUnsupportedKeyType
+		}
+
+		raw = elliptic.MarshalCompressed(key.Curve, key.X, key.Y)
+
+	default:
+		return nil, errmsg.ErrUnsupportedKeyType
+	}
+
+	if err != nil {
+		return nil, errmsg.ErrPubKeySerialization.Wrap(err)
+	}
+
+	return append(binary.AppendUvarint(nil, uint64(codec)), raw...), nil
+}
+
+func unmarshalMulticodecPublicKey(data []byte) (crypto.PubKey, error) {
+	code, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, errmsg.ErrInvalidPubKeyFormat
+	}
+
+	raw := data[n:]
+
+	var (
+		pubKey crypto.PubKey
+		err    error
+	)
+
+	switch multicodec.Code(code) {
+	case multicodec.Ed25519Pub:
+		pubKey, err = crypto.UnmarshalEd25519PublicKey(raw)
+
+	case multicodec.Secp256k1Pub:
+		pubKey, err = crypto.UnmarshalSecp256k1PublicKey(raw)
+
+	case multicodec.P256Pub:
+		x, y := elliptic.UnmarshalCompressed(elliptic.P256(), raw)
+		if x == nil {
+			return nil, errmsg.ErrInvalidPubKeyFormat
+		}
+
+		pubKey, err = crypto.ECDSAPublicKeyFromPubKey(ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y})
+
+	default:
+		return nil, errmsg.ErrUnsupportedKeyType
+	}
+
+	if err != nil {
+		return nil, errmsg.ErrInvalidPubKeyFormat.Wrap(err)
+	}
+
+	return pubKey, nil
+}
+
+var _ Interface = &DIDKeyIdentityProvider{}
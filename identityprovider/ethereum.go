@@ -0,0 +1,232 @@
+package identityprovider // import "berty.tech/go-ipfs-log/identityprovider"
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+
+	btcec "github.com/btcsuite/btcd/btcec/v2"
+	btcecdsa "github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"golang.org/x/crypto/sha3"
+
+	"berty.tech/go-ipfs-log/errmsg"
+	"berty.tech/go-ipfs-log/keystore"
+)
+
+// EthereumType is the type of the identities created by
+// EthereumIdentityProvider, as in the JS implementation.
+const EthereumType = "ethereum"
+
+// ethereumSignatureSize is the size of r || s || v signatures.
+const ethereumSignatureSize = 65
+
+// EthereumSigner is implemented by the signers able to produce EIP-191
+// personal signatures, such as wallets.
+type EthereumSigner interface {
+	Signer
+
+	// SignPersonalMessage signs a message using the secp256k1 key of a
+	// given key ID, the signature is r || s || v as in Ethereum.
+	SignPersonalMessage(ctx context.Context, id string, message []byte) ([]byte, error)
+}
+
+// EthereumIdentityProvider creates identities whose ID is the Ethereum
+// address of the secp256k1 key of CreateIdentityOptions.ID, which signs the
+// identity using EIP-191 personal signatures.
+//
+// It is compatible with the identities created by the Ethereum provider of
+// the JS implementation.
+type EthereumIdentityProvider struct {
+	signer Signer
+}
+
+// NewEthereumIdentityProvider creates a new Ethereum identity provider.
+func NewEthereumIdentityProvider(options *CreateIdentityOptions) Interface {
+	return &EthereumIdentityProvider{
+		signer: signerOf(options),
+	}
+}
+
+// GetID returns the Ethereum address of the key of options.ID.
+func (p *EthereumIdentityProvider) GetID(ctx context.Context, options *CreateIdentityOptions) (string, error) {
+	if options.KeyType != "" && options.KeyType != keystore.KeyTypeSecp256k1 {
+		return "", errmsg.ErrUnsupportedKeyType
+	}
+
+	public, err := p.signer.PublicKey(ctx, options.ID, keystore.KeyTypeSecp256k1)
+	if err != nil {
+		return "", errmsg.ErrKeyStoreCreateEntry.Wrap(err)
+	}
+
+	return EthereumAddress(public)
+}
+
+// SignIdentity signs the hex encoded public key and ID signature of an
+// identity, as a string like in the JS implementation.
+func (p *EthereumIdentityProvider) SignIdentity(ctx context.Context, data []byte, id string) ([]byte, error) {
+	signer, ok := p.signer.(EthereumSigner)
+	if !ok {
+		return nil, errmsg.ErrSigSign.Wrap(fmt.Errorf("signer can't sign Ethereum messages"))
+	}
+
+	signature, err := signer.SignPersonalMessage(ctx, id, []byte(hex.EncodeToString(data)))
+	if err != nil {
+		return nil, errmsg.ErrSigSign.Wrap(err)
+	}
+
+	return signature, nil
+}
+
+// VerifyIdentity checks that the identity is signed by the key of its
+// Ethereum address.
+func (p *EthereumIdentityProvider) VerifyIdentity(identity *Identity) error {
+	if identity.Signatures == nil {
+		return errmsg.ErrSigNotDefined
+	}
+
+	message := hex.EncodeToString(append(append([]byte{}, identity.PublicKey...), identity.Signatures.ID...))
+
+	signer, err := recoverPersonalMessageSigner([]byte(message), identity.Signatures.PublicKey)
+	if err != nil {
+		return errmsg.ErrSigNotVerified.Wrap(err)
+	}
+
+	address, err := EthereumAddress(signer)
+	if err != nil {
+		return errmsg.ErrSigNotVerified.Wrap(err)
+	}
+
+	if address != identity.ID {
+		return errmsg.ErrSigNotVerified
+	}
+
+	return nil
+}
+
+// Sign signs a value using the key of the identity.
+func (p *EthereumIdentityProvider) Sign(ctx context.Context, identity *Identity, data []byte) ([]byte, error) {
+	sig, err := p.signer.Sign(ctx, identity.keyID(), data)
+	if err != nil {
+		return nil, errmsg.ErrSigSign.Wrap(err)
+	}
+
+	return sig, nil
+}
+
+// UnmarshalPublicKey reads a public key serialized by MarshalPublicKey.
+func (p *EthereumIdentityProvider) UnmarshalPublicKey(data []byte) (crypto.PubKey, error) {
+	return UnmarshalPublicKey(data)
+}
+
+// GetType returns the current identity type.
+func (*EthereumIdentityProvider) GetType() string {
+	return EthereumType
+}
+
+// EthereumAddress returns the EIP-55 checksummed address of a secp256k1
+// public key.
+func EthereumAddress(publicKey crypto.PubKey) (string, error) {
+	if publicKey.Type() != crypto.Secp256k1 {
+		return "", errmsg.ErrUnsupportedKeyType
+	}
+
+	raw, err := publicKey.Raw()
+	if err != nil {
+		return "", errmsg.ErrPubKeySerialization.Wrap(err)
+	}
+
+	key, err := btcec.ParsePubKey(raw)
+	if err != nil {
+		return "", errmsg.ErrNotSecp256k1PubKey.Wrap(err)
+	}
+
+	address := hex.EncodeToString(keccak256(key.SerializeUncompressed()[1:])[12:])
+
+	// EIP-55 upper cases the letters whose nibble in the hash of the
+	// address is 8 or more
+	hash := hex.EncodeToString(keccak256([]byte(address)))
+	checksummed := []byte(address)
+
+	for i, c := range checksummed {
+		if c >= 'a' && hash[i] >= '8' {
+			checksummed[i] = c - 'a' + 'A'
+		}
+	}
+
+	return "0x" + string(checksummed), nil
+}
+
+// personalMessageHash is the EIP-191 hash of a message signed by
+// personal_sign.
+func personalMessageHash(message []byte) []byte {
+	return keccak256([]byte(fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", len(message), message)))
+}
+
+func signPersonalMessage(priv crypto.PrivKey, message []byte) ([]byte, error) {
+	if priv.Type() != crypto.Secp256k1 {
+		return nil, errmsg.ErrUnsupportedKeyType
+	}
+
+	raw, err := priv.Raw()
+	if err != nil {
+		return nil, errmsg.ErrInvalidPrivKeyFormat.Wrap(err)
+	}
+
+	key, _ := btcec.PrivKeyFromBytes(raw)
+	compact := btcecdsa.SignCompact(key, personalMessageHash(message), false)
+
+	// compact signatures are v || r || s, Ethereum uses r || s || v
+	return append(compact[1:], compact[0]), nil
+}
+
+func recoverPersonalMessageSigner(message []byte, signature []byte) (crypto.PubKey, error) {
+	if len(signature) != ethereumSignatureSize {
+		return nil, errmsg.ErrSigDeserialization
+	}
+
+	v := signature[ethereumSignatureSize-1]
+	if v < 27 {
+		// some wallets use the recovery ID without offset
+		v += 27
+	}
+
+	compact := append([]byte{v}, signature[:ethereumSignatureSize-1]...)
+
+	key, _, err := btcecdsa.RecoverCompact(compact, personalMessageHash(message))
+	if err != nil {
+		return nil, err
+	}
+
+	return crypto.UnmarshalSecp256k1PublicKey(key.SerializeCompressed())
+}
+
+func keccak256(data []byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	h.Write(data)
+
+	return h.Sum(nil)
+}
+
+// SignPersonalMessage signs a message using EIP-191 with a secp256k1 key of
+// the keystore.
+func (s *keystoreSigner) SignPersonalMessage(ctx context.Context, id string, message []byte) ([]byte, error) {
+	private, err := s.keystore.GetKey(ctx, id)
+	if err != nil {
+		return nil, errmsg.ErrKeyNotInKeystore.Wrap(err)
+	}
+
+	return signPersonalMessage(private, message)
+}
+
+// SignPersonalMessage signs a message using EIP-191, the key must be a
+// secp256k1 key.
+func (s *privKeySigner) SignPersonalMessage(_ context.Context, _ string, message []byte) ([]byte, error) {
+	return signPersonalMessage(s.priv, message)
+}
+
+var (
+	_ Interface      = &EthereumIdentityProvider{}
+	_ EthereumSigner = (*keystoreSigner)(nil)
+	_ EthereumSigner = (*privKeySigner)(nil)
+)
@@ -2,7 +2,6 @@ package identityprovider // import "berty.tech/go-ipfs-log/identityprovider"
 
 import (
 	"context"
-	"encoding/hex"
 
 	btcec "github.com/btcsuite/btcd/btcec/v2"
 	"github.com/libp2p/go-libp2p/core/crypto"
@@ -11,40 +10,20 @@ import (
 	"berty.tech/go-ipfs-log/keystore"
 )
 
-var supportedTypes = map[string]func(*CreateIdentityOptions) Interface{
-	"orbitdb": NewOrbitDBIdentityProvider,
-}
-
 type Identities struct {
-	keyStore keystore.Interface
+	signer   Signer
+	registry *Registry
 }
 
-func getHandlerFor(typeName string) (func(*CreateIdentityOptions) Interface, error) {
-	if !IsSupported(typeName) {
-		return nil, errmsg.ErrIdentityProviderNotSupported
-	}
-
-	return supportedTypes[typeName], nil
-}
-
-func newIdentities(keyStore keystore.Interface) *Identities {
+func newIdentities(signer Signer, registry *Registry) *Identities {
 	return &Identities{
-		keyStore: keyStore,
+		signer:   signer,
+		registry: registry,
 	}
 }
 
 func (i *Identities) Sign(ctx context.Context, identity *Identity, data []byte) ([]byte, error) {
-	privKey, err := i.keyStore.GetKey(ctx, identity.ID)
-	if err != nil {
-		return nil, errmsg.ErrKeyNotInKeystore.Wrap(err)
-	}
-
-	sig, err := i.keyStore.Sign(privKey, data)
-	if err != nil {
-		return nil, errmsg.ErrSigSign.Wrap(err)
-	}
-
-	return sig, nil
+	return i.signer.Sign(ctx, identity.keyID(), data)
 }
 
 // Verify checks a signature.
@@ -73,7 +52,7 @@ func compressedToUncompressedS256Key(pubKeyBytes []byte) ([]byte, error) {
 
 // CreateIdentity creates a new Identity.
 func (i *Identities) CreateIdentity(ctx context.Context, options *CreateIdentityOptions) (*Identity, error) {
-	NewIdentityProvider, err := getHandlerFor(options.Type)
+	NewIdentityProvider, err := i.registry.handlerFor(options.Type)
 	if err != nil {
 		return nil, errmsg.ErrIdentityProviderNotSupported.Wrap(err)
 	}
@@ -91,22 +70,19 @@ func (i *Identities) CreateIdentity(ctx context.Context, options *CreateIdentity
 	//	}
 	//}
 
-	publicKey, idSignature, err := i.signID(ctx, id)
+	publicKey, idSignature, err := i.signID(ctx, id, options.KeyType)
 	if err != nil {
 		return nil, errmsg.ErrSigSign.Wrap(err)
 	}
 
-	publicKeyBytes, err := publicKey.Raw()
-	if err != nil {
-		return nil, errmsg.ErrNotSecp256k1PubKey.Wrap(err)
+	marshalPublicKey := MarshalPublicKey
+	if marshaler, ok := identityProvider.(PublicKeyMarshaler); ok {
+		marshalPublicKey = marshaler.MarshalPublicKey
 	}
 
-	// JS version of IPFS Log expects an uncompressed Secp256k1 key
-	if publicKey.Type().String() == "Secp256k1" {
-		publicKeyBytes, err = compressedToUncompressedS256Key(publicKeyBytes)
-		if err != nil {
-			return nil, errmsg.ErrNotSecp256k1PubKey.Wrap(err)
-		}
+	publicKeyBytes, err := marshalPublicKey(publicKey)
+	if err != nil {
+		return nil, err
 	}
 
 	pubKeyIDSignature, err := identityProvider.SignIdentity(ctx, append(publicKeyBytes, idSignature...), options.ID)
@@ -126,84 +102,89 @@ func (i *Identities) CreateIdentity(ctx context.Context, options *CreateIdentity
 	}, nil
 }
 
-func (i *Identities) signID(ctx context.Context, id string) (crypto.PubKey, []byte, error) {
-	privKey, err := i.keyStore.GetKey(ctx, id)
+func (i *Identities) signID(ctx context.Context, id string, keyType keystore.KeyType) (crypto.PubKey, []byte, error) {
+	publicKey, err := i.signer.PublicKey(ctx, id, keyType)
 	if err != nil {
-		privKey, err = i.keyStore.CreateKey(ctx, id)
-
-		if err != nil {
-			return nil, nil, errmsg.ErrSigSign.Wrap(err)
-		}
+		return nil, nil, errmsg.ErrSigSign.Wrap(err)
 	}
 
-	idSignature, err := i.keyStore.Sign(privKey, []byte(id))
+	idSignature, err := i.signer.Sign(ctx, id, []byte(id))
 	if err != nil {
 		return nil, nil, errmsg.ErrSigSign.Wrap(err)
 	}
 
-	return privKey.GetPublic(), idSignature, nil
+	return publicKey, idSignature, nil
 }
 
 // VerifyIdentity checks an identity.
 func (i *Identities) VerifyIdentity(identity *Identity) error {
-	pubKey, err := identity.GetPublicKey()
+	provider, err := i.registry.ProviderFor(identity.Type)
 	if err != nil {
-		return errmsg.ErrPubKeyDeserialization.Wrap(err)
+		return errmsg.ErrSigNotVerified.Wrap(err)
 	}
 
-	idBytes, err := hex.DecodeString(identity.ID)
-	if err != nil {
-		return errmsg.ErrIdentityDeserialization.Wrap(err)
+	// the signatures are the ones of the key the identity was created with
+	if err := identity.checkStatements(); err != nil {
+		return errmsg.ErrSigNotVerified.Wrap(err)
 	}
 
-	err = i.keyStore.Verify(
-		identity.Signatures.ID,
-		pubKey,
-		idBytes,
-	)
+	identity = identity.root()
+
+	pubKey, err := provider.UnmarshalPublicKey(identity.PublicKey)
 	if err != nil {
-		return errmsg.ErrSigNotVerified.Wrap(err)
+		return errmsg.ErrPubKeyDeserialization.Wrap(err)
+	}
+
+	if identity.Signatures == nil {
+		return errmsg.ErrSigNotDefined
 	}
 
-	identityProvider, err := getHandlerFor(identity.Type)
+	ok, err := pubKey.Verify([]byte(identity.ID), identity.Signatures.ID)
 	if err != nil {
 		return errmsg.ErrSigNotVerified.Wrap(err)
 	}
 
-	return identityProvider(nil).VerifyIdentity(identity)
+	if !ok {
+		return errmsg.ErrSigNotVerified
+	}
+
+	return provider.VerifyIdentity(identity)
 }
 
-// CreateIdentity creates a new identity.
+// VerifyIdentity checks an identity using the default registry.
+func VerifyIdentity(identity *Identity) error {
+	return defaultRegistry.VerifyIdentity(identity)
+}
+
+// CreateIdentity creates a new identity using the registry of the options,
+// defaults to the default registry.
 func CreateIdentity(ctx context.Context, options *CreateIdentityOptions) (*Identity, error) {
-	ks := options.Keystore
-	if ks == nil {
-		return nil, errmsg.ErrKeystoreNotDefined
+	if options != nil && options.Registry != nil {
+		return options.Registry.CreateIdentity(ctx, options)
 	}
 
-	identities := newIdentities(ks)
+	return defaultRegistry.CreateIdentity(ctx, options)
+}
 
-	return identities.CreateIdentity(ctx, options)
+// ProviderFor returns a provider of a type of the default registry.
+func ProviderFor(typeName string) (Interface, error) {
+	return defaultRegistry.ProviderFor(typeName)
 }
 
-// IsSupported checks if an identity type is supported.
+// IsSupported checks if an identity type is supported by the default
+// registry.
 func IsSupported(typeName string) bool {
-	_, ok := supportedTypes[typeName]
-
-	return ok
+	return defaultRegistry.IsSupported(typeName)
 }
 
-// AddIdentityProvider registers an new identity provider.
+// AddIdentityProvider registers an new identity provider in the default
+// registry.
 func AddIdentityProvider(identityProvider func(*CreateIdentityOptions) Interface) error {
-	if identityProvider == nil {
-		return errmsg.ErrIdentityProviderNotDefined
-	}
-
-	supportedTypes[identityProvider(nil).GetType()] = identityProvider
-
-	return nil
+	return defaultRegistry.Add(identityProvider)
 }
 
-// RemoveIdentityProvider unregisters an identity provider.
+// RemoveIdentityProvider unregisters an identity provider from the default
+// registry.
 func RemoveIdentityProvider(name string) {
-	delete(supportedTypes, name)
+	defaultRegistry.Remove(name)
 }
@@ -2,7 +2,10 @@
 package identityprovider // import "berty.tech/go-ipfs-log/identityprovider"
 
 import (
+	btcec "github.com/btcsuite/btcd/btcec/v2"
 	ic "github.com/libp2p/go-libp2p/core/crypto"
+
+	"berty.tech/go-ipfs-log/errmsg"
 )
 
 type IdentitySignature struct {
@@ -15,20 +18,118 @@ type Identity struct {
 	PublicKey  []byte             `json:"publicKey,omitempty"`
 	Signatures *IdentitySignature `json:"signatures,omitempty"`
 	Type       string             `json:"type,omitempty"`
-	Provider   Interface
+
+	// Rotations and Revocations are the statements on the keys of the
+	// identity, PublicKey is the last key of the rotations.
+	Rotations   []*KeyRotation   `json:"rotations,omitempty"`
+	Revocations []*KeyRevocation `json:"revocations,omitempty"`
+
+	// Certificates delegate the key of the identity to PublicKey, the key
+	// of a device.
+	Certificates []*DeviceCertificate `json:"certificates,omitempty"`
+
+	// KeyID is the name of the key of PublicKey in the keystore, defaults
+	// to ID.
+	KeyID string `json:"-"`
+
+	Provider Interface
 }
 
 // Filtered gets fields that should be present in the CBOR representation of identity.
 func (i *Identity) Filtered() *Identity {
 	return &Identity{
-		ID:         i.ID,
-		PublicKey:  i.PublicKey,
-		Signatures: i.Signatures,
-		Type:       i.Type,
+		ID:           i.ID,
+		PublicKey:    i.PublicKey,
+		Signatures:   i.Signatures,
+		Type:         i.Type,
+		Rotations:    i.Rotations,
+		Revocations:  i.Revocations,
+		Certificates: i.Certificates,
+	}
+}
+
+func (i *Identity) copy() *Identity {
+	out := *i
+	out.Rotations = append([]*KeyRotation(nil), i.Rotations...)
+	out.Revocations = append([]*KeyRevocation(nil), i.Revocations...)
+	out.Certificates = append([]*DeviceCertificate(nil), i.Certificates...)
+
+	return &out
+}
+
+func (i *Identity) keyID() string {
+	if i.KeyID != "" {
+		return i.KeyID
 	}
+
+	return i.ID
 }
 
 // GetPublicKey returns the public key of an identity.
 func (i *Identity) GetPublicKey() (ic.PubKey, error) {
-	return ic.UnmarshalPublicKey(i.PublicKey)
+	if i.Provider != nil {
+		return i.Provider.UnmarshalPublicKey(i.PublicKey)
+	}
+
+	return UnmarshalPublicKey(i.PublicKey)
+}
+
+// MarshalPublicKey serializes the public key of an identity. Secp256k1 keys
+// are serialized uncompressed as expected by the JS version of IPFS Log, the
+// other keys use the libp2p encoding which records their type.
+func MarshalPublicKey(publicKey ic.PubKey) ([]byte, error) {
+	if publicKey.Type() != ic.Secp256k1 {
+		publicKeyBytes, err := ic.MarshalPublicKey(publicKey)
+		if err != nil {
+			return nil, errmsg.ErrPubKeySerialization.Wrap(err)
+		}
+
+		return publicKeyBytes, nil
+	}
+
+	publicKeyBytes, err := publicKey.Raw()
+	if err != nil {
+		return nil, errmsg.ErrPubKeySerialization.Wrap(err)
+	}
+
+	return compressedToUncompressedS256Key(publicKeyBytes)
+}
+
+// UnmarshalPublicKey reads a public key serialized by MarshalPublicKey.
+func UnmarshalPublicKey(data []byte) (ic.PubKey, error) {
+	if isSecp256k1PubKey(data) {
+		pubKey, err := ic.UnmarshalSecp256k1PublicKey(data)
+		if err != nil {
+			return nil, errmsg.ErrInvalidPubKeyFormat.Wrap(err)
+		}
+
+		return pubKey, nil
+	}
+
+	pubKey, err := ic.UnmarshalPublicKey(data)
+	if err != nil {
+		return nil, errmsg.ErrInvalidPubKeyFormat.Wrap(err)
+	}
+
+	switch pubKey.Type() {
+	case ic.Secp256k1, ic.Ed25519, ic.ECDSA:
+		return pubKey, nil
+	}
+
+	return nil, errmsg.ErrUnsupportedKeyType
+}
+
+const pubKeyBytesLenUncompressed = 65
+
+// isSecp256k1PubKey checks whether a key is a raw secp256k1 key, libp2p
+// encoded keys start with a protobuf tag instead.
+func isSecp256k1PubKey(data []byte) bool {
+	switch len(data) {
+	case btcec.PubKeyBytesLenCompressed:
+		return data[0] == 0x02 || data[0] == 0x03
+	case pubKeyBytesLenUncompressed:
+		return data[0] == 0x04
+	}
+
+	return false
 }
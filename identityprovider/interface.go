@@ -13,6 +13,17 @@ type CreateIdentityOptions struct {
 	Keystore         keystore.Interface
 	//Migrate          func(*MigrateOptions) error
 	ID string
+
+	// KeyType is the type of the keys created for the identity, defaults to
+	// secp256k1. Other types require a keystore.TypedInterface keystore.
+	KeyType keystore.KeyType
+
+	// Signer holds the keys of the identity instead of Keystore.
+	Signer Signer
+
+	// Registry is the registry of the provider of Type, defaults to the
+	// default registry.
+	Registry *Registry
 }
 
 type Interface interface {
@@ -34,3 +45,10 @@ type Interface interface {
 	// UnmarshalPublicKey will provide a crypto.PubKey from a key bytes.
 	UnmarshalPublicKey(data []byte) (crypto.PubKey, error)
 }
+
+// PublicKeyMarshaler is implemented by the identity providers serializing the
+// public key of their identities in another format than MarshalPublicKey, it
+// must be read by their UnmarshalPublicKey.
+type PublicKeyMarshaler interface {
+	MarshalPublicKey(publicKey crypto.PubKey) ([]byte, error)
+}
@@ -7,11 +7,10 @@ import (
 	"github.com/libp2p/go-libp2p/core/crypto"
 
 	"berty.tech/go-ipfs-log/errmsg"
-	"berty.tech/go-ipfs-log/keystore"
 )
 
 type OrbitDBIdentityProvider struct {
-	keystore keystore.Interface
+	signer Signer
 }
 
 // VerifyIdentity checks an OrbitDB identity.
@@ -22,21 +21,18 @@ func (p *OrbitDBIdentityProvider) VerifyIdentity(_ *Identity) error {
 // NewOrbitDBIdentityProvider creates a new identity for use with OrbitDB.
 func NewOrbitDBIdentityProvider(options *CreateIdentityOptions) Interface {
 	return &OrbitDBIdentityProvider{
-		keystore: options.Keystore,
+		signer: signerOf(options),
 	}
 }
 
 // GetID returns the identity's ID.
 func (p *OrbitDBIdentityProvider) GetID(ctx context.Context, options *CreateIdentityOptions) (string, error) {
-	private, err := p.keystore.GetKey(ctx, options.ID)
-	if err != nil || private == nil {
-		private, err = p.keystore.CreateKey(ctx, options.ID)
-		if err != nil {
-			return "", errmsg.ErrKeyStoreCreateEntry.Wrap(err)
-		}
+	public, err := p.signer.PublicKey(ctx, options.ID, options.KeyType)
+	if err != nil {
+		return "", errmsg.ErrKeyStoreCreateEntry.Wrap(err)
 	}
 
-	pubBytes, err := private.GetPublic().Raw()
+	pubBytes, err := public.Raw()
 	if err != nil {
 		return "", errmsg.ErrPubKeySerialization.Wrap(err)
 	}
@@ -46,17 +42,12 @@ func (p *OrbitDBIdentityProvider) GetID(ctx context.Context, options *CreateIden
 
 // SignIdentity signs an OrbitDB identity.
 func (p *OrbitDBIdentityProvider) SignIdentity(ctx context.Context, data []byte, id string) ([]byte, error) {
-	key, err := p.keystore.GetKey(ctx, id)
-	if err != nil {
-		return nil, errmsg.ErrKeyNotInKeystore
-	}
-
 	//data, _ = hex.DecodeString(hex.EncodeToString(data))
 
 	// FIXME? Data is a unicode encoded hex as a byte (source lib uses Buffer.from(hexStr) instead of Buffer.from(hexStr, "hex"))
 	data = []byte(hex.EncodeToString(data))
 
-	signature, err := key.Sign(data)
+	signature, err := p.signer.Sign(ctx, id, data)
 	if err != nil {
 		return nil, errmsg.ErrSigSign.Wrap(err)
 	}
@@ -66,12 +57,7 @@ func (p *OrbitDBIdentityProvider) SignIdentity(ctx context.Context, data []byte,
 
 // Sign signs a value using the current.
 func (p *OrbitDBIdentityProvider) Sign(ctx context.Context, identity *Identity, data []byte) ([]byte, error) {
-	key, err := p.keystore.GetKey(ctx, identity.ID)
-	if err != nil {
-		return nil, errmsg.ErrKeyNotInKeystore.Wrap(err)
-	}
-
-	sig, err := key.Sign(data)
+	sig, err := p.signer.Sign(ctx, identity.keyID(), data)
 	if err != nil {
 		return nil, errmsg.ErrSigSign.Wrap(err)
 	}
@@ -79,13 +65,9 @@ func (p *OrbitDBIdentityProvider) Sign(ctx context.Context, identity *Identity,
 	return sig, nil
 }
 
+// UnmarshalPublicKey reads a public key serialized by MarshalPublicKey.
 func (p *OrbitDBIdentityProvider) UnmarshalPublicKey(data []byte) (crypto.PubKey, error) {
-	pubKey, err := crypto.UnmarshalSecp256k1PublicKey(data)
-	if err != nil {
-		return nil, errmsg.ErrInvalidPubKeyFormat
-	}
-
-	return pubKey, nil
+	return UnmarshalPublicKey(data)
 }
 
 // GetType returns the current identity type.
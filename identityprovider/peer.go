@@ -0,0 +1,189 @@
+package identityprovider // import "berty.tech/go-ipfs-log/identityprovider"
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"berty.tech/go-ipfs-log/errmsg"
+	"berty.tech/go-ipfs-log/keystore"
+)
+
+// PeerIdentityType is the type of the identities created by
+// PeerIdentityProvider.
+const PeerIdentityType = "libp2p-peer"
+
+// PeerIdentityProvider creates identities whose ID is the libp2p peer ID of
+// their key, such as the key of a libp2p host.
+//
+// The identity key is the key of the peer, so the signer must return the
+// same key for the ID option and the peer ID, either using NewPrivKeySigner
+// or by storing the key in the keystore under the peer ID and using it as
+// the ID option.
+type PeerIdentityProvider struct {
+	signer Signer
+}
+
+// NewPeerIdentityProvider creates a new libp2p peer identity provider.
+func NewPeerIdentityProvider(options *CreateIdentityOptions) Interface {
+	return &PeerIdentityProvider{
+		signer: signerOf(options),
+	}
+}
+
+// GetID returns the peer ID of the key of options.ID.
+func (p *PeerIdentityProvider) GetID(ctx context.Context, options *CreateIdentityOptions) (string, error) {
+	public, err := p.signer.PublicKey(ctx, options.ID, options.KeyType)
+	if err != nil {
+		return "", errmsg.ErrKeyStoreCreateEntry.Wrap(err)
+	}
+
+	id, err := peer.IDFromPublicKey(public)
+	if err != nil {
+		return "", errmsg.ErrPubKeySerialization.Wrap(err)
+	}
+
+	return id.String(), nil
+}
+
+// SignIdentity signs the public key of an identity and its ID signature
+// using the key of the peer.
+func (p *PeerIdentityProvider) SignIdentity(ctx context.Context, data []byte, id string) ([]byte, error) {
+	signature, err := p.signer.Sign(ctx, id, data)
+	if err != nil {
+		return nil, errmsg.ErrSigSign.Wrap(err)
+	}
+
+	return signature, nil
+}
+
+// VerifyIdentity checks that the public key of an identity hashes to its
+// peer ID and signs it.
+func (p *PeerIdentityProvider) VerifyIdentity(identity *Identity) error {
+	if identity.Signatures == nil {
+		return errmsg.ErrSigNotDefined
+	}
+
+	if _, err := PeerIDFromIdentity(identity); err != nil {
+		return err
+	}
+
+	// the key is embedded in the identity as peer IDs only embed small keys
+	pubKey, err := p.UnmarshalPublicKey(identity.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	data := append(append([]byte{}, identity.PublicKey...), identity.Signatures.ID...)
+
+	ok, err := pubKey.Verify(data, identity.Signatures.PublicKey)
+	if err != nil {
+		return errmsg.ErrSigNotVerified.Wrap(err)
+	}
+
+	if !ok {
+		return errmsg.ErrSigNotVerified
+	}
+
+	return nil
+}
+
+// Sign signs a value using the key of the peer.
+func (p *PeerIdentityProvider) Sign(ctx context.Context, identity *Identity, data []byte) ([]byte, error) {
+	sig, err := p.signer.Sign(ctx, identity.keyID(), data)
+	if err != nil {
+		return nil, errmsg.ErrSigSign.Wrap(err)
+	}
+
+	return sig, nil
+}
+
+// MarshalPublicKey serializes a public key using the libp2p encoding, which
+// is the one hashed by peer IDs.
+func (p *PeerIdentityProvider) MarshalPublicKey(publicKey crypto.PubKey) ([]byte, error) {
+	data, err := crypto.MarshalPublicKey(publicKey)
+	if err != nil {
+		return nil, errmsg.ErrPubKeySerialization.Wrap(err)
+	}
+
+	return data, nil
+}
+
+// UnmarshalPublicKey reads a libp2p encoded public key.
+func (p *PeerIdentityProvider) UnmarshalPublicKey(data []byte) (crypto.PubKey, error) {
+	pubKey, err := crypto.UnmarshalPublicKey(data)
+	if err != nil {
+		return nil, errmsg.ErrInvalidPubKeyFormat.Wrap(err)
+	}
+
+	return pubKey, nil
+}
+
+// GetType returns the current identity type.
+func (*PeerIdentityProvider) GetType() string {
+	return PeerIdentityType
+}
+
+// PeerIDFromIdentity returns the peer ID of a libp2p peer identity, after
+// checking that its public key hashes to it.
+func PeerIDFromIdentity(identity *Identity) (peer.ID, error) {
+	if identity == nil {
+		return "", errmsg.ErrIdentityNotDefined
+	}
+
+	if identity.Type != PeerIdentityType {
+		return "", errmsg.ErrIdentityUnknown.Wrap(fmt.Errorf("not a %s identity", PeerIdentityType))
+	}
+
+	id, err := peer.Decode(identity.ID)
+	if err != nil {
+		return "", errmsg.ErrIdentityDeserialization.Wrap(err)
+	}
+
+	pubKey, err := crypto.UnmarshalPublicKey(identity.PublicKey)
+	if err != nil {
+		return "", errmsg.ErrInvalidPubKeyFormat.Wrap(err)
+	}
+
+	if !id.MatchesPublicKey(pubKey) {
+		return "", errmsg.ErrSigNotVerified.Wrap(fmt.Errorf("public key doesn't match the peer ID"))
+	}
+
+	return id, nil
+}
+
+type privKeySigner struct {
+	priv crypto.PrivKey
+}
+
+// NewPrivKeySigner creates a signer using a single key for every key ID, such
+// as the key of a libp2p host.
+func NewPrivKeySigner(priv crypto.PrivKey) Signer {
+	return &privKeySigner{priv: priv}
+}
+
+func (s *privKeySigner) PublicKey(_ context.Context, _ string, keyType keystore.KeyType) (crypto.PubKey, error) {
+	if keyType != "" {
+		if actual, err := keystore.KeyTypeOf(s.priv); err != nil || actual != keyType {
+			return nil, errmsg.ErrUnsupportedKeyType
+		}
+	}
+
+	return s.priv.GetPublic(), nil
+}
+
+func (s *privKeySigner) Sign(_ context.Context, _ string, data []byte) ([]byte, error) {
+	sig, err := s.priv.Sign(data)
+	if err != nil {
+		return nil, errmsg.ErrSigSign.Wrap(err)
+	}
+
+	return sig, nil
+}
+
+var (
+	_ Interface = &PeerIdentityProvider{}
+	_ Signer    = (*privKeySigner)(nil)
+)
@@ -0,0 +1,216 @@
+package identityprovider // import "berty.tech/go-ipfs-log/identityprovider"
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+
+	"berty.tech/go-ipfs-log/errmsg"
+	"berty.tech/go-ipfs-log/keystore"
+)
+
+// PseudonymProof is a statement linking the pseudonym of a log to the
+// identity it is derived from, signed by both. It isn't stored in the
+// entries, it should only be shared with authorized verifiers, sealed using
+// enc.SealPseudonymProof for instance.
+type PseudonymProof struct {
+	Root      *Identity `json:"root,omitempty"`
+	Pseudonym *Identity `json:"pseudonym,omitempty"`
+	LogID     string    `json:"logID,omitempty"`
+
+	// Signature is signed by the root identity, PseudonymSignature by the
+	// pseudonym.
+	Signature          []byte `json:"signature,omitempty"`
+	PseudonymSignature []byte `json:"pseudonymSignature,omitempty"`
+}
+
+type pseudonymSigner struct {
+	keystore  keystore.Interface
+	rootKeyID string
+	logID     string
+	keyType   keystore.KeyType
+}
+
+// NewPseudonymSigner creates a signer whose keys are derived from the key
+// named rootKeyID in a keystore and from a log ID, the derived keys are
+// never stored. Every key uses keyType.
+func NewPseudonymSigner(ks keystore.Interface, rootKeyID string, logID string, keyType keystore.KeyType) Signer {
+	return &pseudonymSigner{
+		keystore:  ks,
+		rootKeyID: rootKeyID,
+		logID:     logID,
+		keyType:   keyType,
+	}
+}
+
+func (s *pseudonymSigner) PublicKey(ctx context.Context, id string, _ keystore.KeyType) (crypto.PubKey, error) {
+	private, err := s.derive(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return private.GetPublic(), nil
+}
+
+func (s *pseudonymSigner) Sign(ctx context.Context, id string, data []byte) ([]byte, error) {
+	private, err := s.derive(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := s.keystore.Sign(private, data)
+	if err != nil {
+		return nil, errmsg.ErrSigSign.Wrap(err)
+	}
+
+	return sig, nil
+}
+
+// derive derives a key from the root key using HKDF, the log ID is length
+// prefixed so that distinct log and key IDs can't derive the same key.
+func (s *pseudonymSigner) derive(ctx context.Context, id string) (crypto.PrivKey, error) {
+	root, err := s.keystore.GetKey(ctx, s.rootKeyID)
+	if err != nil || root == nil {
+		if root, err = createKey(ctx, s.keystore, s.rootKeyID, s.keyType); err != nil {
+			return nil, errmsg.ErrKeyStoreCreateEntry.Wrap(err)
+		}
+	}
+
+	material, err := root.Raw()
+	if err != nil {
+		return nil, errmsg.ErrKeyDeserialization.Wrap(err)
+	}
+
+	defer func() {
+		for i := range material {
+			material[i] = 0
+		}
+	}()
+
+	return keystore.DeriveKey(material, fmt.Sprintf("pseudonym/%d/%s/%s", len(s.logID), s.logID, id), s.keyType)
+}
+
+// CreatePseudonym creates an identity for a single log, its keys are derived
+// from the key named options.ID in options.Keystore and from the log ID. The
+// pseudonyms of an identity can't be linked to it nor to each other, the
+// same pseudonym is created again for a given log.
+func CreatePseudonym(ctx context.Context, logID string, options *CreateIdentityOptions) (*Identity, error) {
+	if options == nil || options.Keystore == nil {
+		return nil, errmsg.ErrKeystoreNotDefined
+	}
+
+	registry := options.Registry
+	if registry == nil {
+		registry = defaultRegistry
+	}
+
+	pseudonymOptions := *options
+	pseudonymOptions.Signer = NewPseudonymSigner(options.Keystore, options.ID, logID, options.KeyType)
+
+	return registry.CreateIdentity(ctx, &pseudonymOptions)
+}
+
+func (p *PseudonymProof) signedBytes() ([]byte, error) {
+	return json.Marshal(struct {
+		Statement    string `json:"statement"`
+		ID           string `json:"id"`
+		PublicKey    []byte `json:"publicKey"`
+		Pseudonym    string `json:"pseudonym"`
+		PseudonymKey []byte `json:"pseudonymKey"`
+		LogID        string `json:"logID"`
+	}{"pseudonym", p.Root.ID, p.Root.PublicKey, p.Pseudonym.ID, p.Pseudonym.PublicKey, p.LogID})
+}
+
+// ProvePseudonym creates the proof that a pseudonym created by
+// CreatePseudonym for a log belongs to an identity.
+func ProvePseudonym(ctx context.Context, identity *Identity, pseudonym *Identity, logID string) (*PseudonymProof, error) {
+	if identity == nil || pseudonym == nil {
+		return nil, errmsg.ErrIdentityNotDefined
+	}
+
+	if identity.Provider == nil || pseudonym.Provider == nil {
+		return nil, errmsg.ErrIdentityProviderNotSupported
+	}
+
+	proof := &PseudonymProof{
+		Root:      identity.Filtered(),
+		Pseudonym: pseudonym.Filtered(),
+		LogID:     logID,
+	}
+
+	data, err := proof.signedBytes()
+	if err != nil {
+		return nil, errmsg.ErrJSONSerializationFailed.Wrap(err)
+	}
+
+	if proof.Signature, err = identity.Provider.Sign(ctx, identity, data); err != nil {
+		return nil, errmsg.ErrSigSign.Wrap(err)
+	}
+
+	if proof.PseudonymSignature, err = pseudonym.Provider.Sign(ctx, pseudonym, data); err != nil {
+		return nil, errmsg.ErrSigSign.Wrap(err)
+	}
+
+	return proof, nil
+}
+
+// Marshal serializes a proof, to be sealed for its verifiers.
+func (p *PseudonymProof) Marshal() ([]byte, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, errmsg.ErrJSONSerializationFailed.Wrap(err)
+	}
+
+	return data, nil
+}
+
+// UnmarshalPseudonymProof reads a proof serialized by Marshal.
+func UnmarshalPseudonymProof(data []byte) (*PseudonymProof, error) {
+	proof := &PseudonymProof{}
+	if err := json.Unmarshal(data, proof); err != nil {
+		return nil, errmsg.ErrJSONSerializationFailed.Wrap(err)
+	}
+
+	return proof, nil
+}
+
+// VerifyPseudonymProof checks that a proof links the pseudonym used in a log
+// to the root identity of the proof.
+func (r *Registry) VerifyPseudonymProof(proof *PseudonymProof, pseudonym *Identity, logID string) error {
+	if proof == nil || proof.Root == nil || proof.Pseudonym == nil || pseudonym == nil {
+		return errmsg.ErrPseudonymProofNotVerified
+	}
+
+	if proof.LogID != logID || proof.Pseudonym.ID != pseudonym.ID || !bytes.Equal(proof.Pseudonym.PublicKey, pseudonym.PublicKey) {
+		return errmsg.ErrPseudonymProofNotVerified
+	}
+
+	for _, identity := range []*Identity{proof.Root, proof.Pseudonym} {
+		if err := r.VerifyIdentity(identity); err != nil {
+			return errmsg.ErrPseudonymProofNotVerified.Wrap(err)
+		}
+	}
+
+	data, err := proof.signedBytes()
+	if err != nil {
+		return errmsg.ErrJSONSerializationFailed.Wrap(err)
+	}
+
+	if err := proof.Root.verifyWithKey(proof.Root.PublicKey, data, proof.Signature); err != nil {
+		return errmsg.ErrPseudonymProofNotVerified.Wrap(err)
+	}
+
+	if err := proof.Pseudonym.verifyWithKey(proof.Pseudonym.PublicKey, data, proof.PseudonymSignature); err != nil {
+		return errmsg.ErrPseudonymProofNotVerified.Wrap(err)
+	}
+
+	return nil
+}
+
+// VerifyPseudonymProof checks a proof using the default registry.
+func VerifyPseudonymProof(proof *PseudonymProof, pseudonym *Identity, logID string) error {
+	return defaultRegistry.VerifyPseudonymProof(proof, pseudonym, logID)
+}
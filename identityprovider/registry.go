@@ -0,0 +1,146 @@
+package identityprovider // import "berty.tech/go-ipfs-log/identityprovider"
+
+import (
+	"context"
+	"sync"
+
+	"berty.tech/go-ipfs-log/errmsg"
+)
+
+// Registry is a set of identity providers, identities can only be created
+// and verified using the providers of a registry.
+//
+// The package level functions use a default registry including the built-in
+// providers, a registry can be given to the identities and IOs of a log to
+// accept other providers.
+type Registry struct {
+	lock      sync.RWMutex
+	providers map[string]func(*CreateIdentityOptions) Interface
+}
+
+var defaultRegistry = NewDefaultRegistry()
+
+// NewRegistry creates a registry of the given providers.
+func NewRegistry(providers ...func(*CreateIdentityOptions) Interface) (*Registry, error) {
+	r := &Registry{
+		providers: map[string]func(*CreateIdentityOptions) Interface{},
+	}
+
+	for _, p := range providers {
+		if err := r.Add(p); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// NewDefaultRegistry creates a registry of the built-in providers.
+func NewDefaultRegistry() *Registry {
+	r, _ := NewRegistry(
+		NewOrbitDBIdentityProvider,
+		NewDIDKeyIdentityProvider,
+		NewPeerIdentityProvider,
+		NewEthereumIdentityProvider,
+	)
+
+	return r
+}
+
+// DefaultRegistry returns the registry used by the package level functions.
+func DefaultRegistry() *Registry {
+	return defaultRegistry
+}
+
+// Add registers an identity provider, replacing the one of the same type.
+func (r *Registry) Add(identityProvider func(*CreateIdentityOptions) Interface) error {
+	if identityProvider == nil {
+		return errmsg.ErrIdentityProviderNotDefined
+	}
+
+	typeName := identityProvider(nil).GetType()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.providers[typeName] = identityProvider
+
+	return nil
+}
+
+// Remove unregisters an identity provider.
+func (r *Registry) Remove(typeName string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.providers, typeName)
+}
+
+// IsSupported checks if an identity type is supported.
+func (r *Registry) IsSupported(typeName string) bool {
+	_, err := r.handlerFor(typeName)
+
+	return err == nil
+}
+
+// ProviderFor returns a provider of a registered identity type, it can
+// verify identities and read their keys but not create them.
+func (r *Registry) ProviderFor(typeName string) (Interface, error) {
+	identityProvider, err := r.handlerFor(typeName)
+	if err != nil {
+		return nil, err
+	}
+
+	return identityProvider(nil), nil
+}
+
+// ProviderOf returns the provider of an identity, which must be registered.
+// The given provider is returned when it is of the type of the identity, so
+// providers holding a state aren't replaced by a stateless one.
+func (r *Registry) ProviderOf(identity *Identity, provider Interface) (Interface, error) {
+	if identity == nil {
+		return nil, errmsg.ErrIdentityNotDefined
+	}
+
+	if !r.IsSupported(identity.Type) {
+		return nil, errmsg.ErrIdentityProviderNotSupported
+	}
+
+	if provider != nil && provider.GetType() == identity.Type {
+		return provider, nil
+	}
+
+	return r.ProviderFor(identity.Type)
+}
+
+// CreateIdentity creates a new identity using a provider of the registry.
+func (r *Registry) CreateIdentity(ctx context.Context, options *CreateIdentityOptions) (*Identity, error) {
+	signer := signerOf(options)
+	if signer == nil {
+		return nil, errmsg.ErrKeystoreNotDefined
+	}
+
+	return newIdentities(signer, r).CreateIdentity(ctx, options)
+}
+
+// VerifyIdentity checks that an identity is signed by its own key and checks
+// it using its provider, which must be registered.
+func (r *Registry) VerifyIdentity(identity *Identity) error {
+	if identity == nil {
+		return errmsg.ErrIdentityNotDefined
+	}
+
+	return newIdentities(nil, r).VerifyIdentity(identity)
+}
+
+func (r *Registry) handlerFor(typeName string) (func(*CreateIdentityOptions) Interface, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	identityProvider, ok := r.providers[typeName]
+	if !ok {
+		return nil, errmsg.ErrIdentityProviderNotSupported
+	}
+
+	return identityProvider, nil
+}
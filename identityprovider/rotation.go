@@ -0,0 +1,590 @@
+package identityprovider // import "berty.tech/go-ipfs-log/identityprovider"
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+
+	"berty.tech/go-ipfs-log/errmsg"
+	"berty.tech/go-ipfs-log/keystore"
+)
+
+// KeyRotation is a statement of a key of an identity endorsing its next key.
+// Entries are signed using the next key from the Start clock time, the
+// previous key is retired at this time.
+//
+// When a key endorses several keys, the rotation seen first is kept and the
+// others are ignored, so the holder of a compromised key can't take over nor
+// retire the keys which succeeded it.
+type KeyRotation struct {
+	PublicKey    []byte `json:"publicKey,omitempty"`
+	NewPublicKey []byte `json:"newPublicKey,omitempty"`
+	Start        int    `json:"start"`
+	Signature    []byte `json:"signature,omitempty"`
+}
+
+// KeyRevocation is a statement invalidating the entries signed by a key of an
+// identity after a clock time. It is signed by the revoked key or one of its
+// successors.
+//
+// Like the expiry of device certificates, it relies on the clock time of the
+// entries which is chosen by their signer. Joined entries can't have a lower
+// time than their next entries, so a revoked key can't follow entries past
+// After, but it can still sign entries with an earlier time which don't.
+type KeyRevocation struct {
+	PublicKey []byte `json:"publicKey,omitempty"`
+	After     int    `json:"after"`
+	SignedBy  []byte `json:"signedBy,omitempty"`
+	Signature []byte `json:"signature,omitempty"`
+}
+
+type RotateKeyOptions struct {
+	Keystore keystore.Interface
+	Signer   Signer
+
+	// KeyID names the new key in the keystore, defaults to the identity ID
+	// followed by the number of the rotation.
+	KeyID string
+
+	// KeyType is the type of the new key, defaults to secp256k1.
+	KeyType keystore.KeyType
+
+	// Start is the clock time of the first entry signed using the new key.
+	Start int
+}
+
+type RevokeKeyOptions struct {
+	Keystore keystore.Interface
+	Signer   Signer
+
+	// PublicKey is the revoked key, defaults to the public key of the
+	// identity.
+	PublicKey []byte
+
+	// Certificate revokes the key of a device the identity delegated its
+	// key to, it takes precedence over PublicKey.
+	Certificate *DeviceCertificate
+
+	// After is the clock time after which the entries signed using the
+	// revoked key are rejected.
+	After int
+}
+
+func (r *KeyRotation) signedBytes(id string) ([]byte, error) {
+	return json.Marshal(struct {
+		Statement    string `json:"statement"`
+		ID           string `json:"id"`
+		PublicKey    []byte `json:"publicKey"`
+		NewPublicKey []byte `json:"newPublicKey"`
+		Start        int    `json:"start"`
+	}{"key-rotation", id, r.PublicKey, r.NewPublicKey, r.Start})
+}
+
+func (r *KeyRevocation) signedBytes(id string) ([]byte, error) {
+	return json.Marshal(struct {
+		Statement string `json:"statement"`
+		ID        string `json:"id"`
+		PublicKey []byte `json:"publicKey"`
+		After     int    `json:"after"`
+		SignedBy  []byte `json:"signedBy"`
+	}{"key-revocation", id, r.PublicKey, r.After, r.SignedBy})
+}
+
+// RotateKey creates the next key of an identity, it returns a copy of the
+// identity using the new key which is endorsed by the current one.
+func RotateKey(ctx context.Context, identity *Identity, options *RotateKeyOptions) (*Identity, error) {
+	if identity == nil {
+		return nil, errmsg.ErrIdentityNotDefined
+	}
+
+	if options == nil {
+		options = &RotateKeyOptions{}
+	}
+
+	// devices can't endorse keys of the identity
+	if identity.IsDevice() {
+		return nil, errmsg.ErrKeyNotValid
+	}
+
+	signer := signerOf(&CreateIdentityOptions{Keystore: options.Keystore, Signer: options.Signer})
+	if signer == nil {
+		return nil, errmsg.ErrKeystoreNotDefined
+	}
+
+	keyID := options.KeyID
+	if keyID == "" {
+		keyID = fmt.Sprintf("%s/%d", identity.ID, len(identity.Rotations)+1)
+	}
+
+	publicKey, err := signer.PublicKey(ctx, keyID, options.KeyType)
+	if err != nil {
+		return nil, errmsg.ErrKeyStoreCreateEntry.Wrap(err)
+	}
+
+	publicKeyBytes, err := identity.marshalPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	rotation := &KeyRotation{
+		PublicKey:    identity.PublicKey,
+		NewPublicKey: publicKeyBytes,
+		Start:        options.Start,
+	}
+
+	data, err := rotation.signedBytes(identity.ID)
+	if err != nil {
+		return nil, errmsg.ErrJSONSerializationFailed.Wrap(err)
+	}
+
+	if rotation.Signature, err = signer.Sign(ctx, identity.keyID(), data); err != nil {
+		return nil, errmsg.ErrSigSign.Wrap(err)
+	}
+
+	rotated := identity.copy()
+	rotated.PublicKey = publicKeyBytes
+	rotated.KeyID = keyID
+	rotated.Rotations = append(rotated.Rotations, rotation)
+
+	return rotated, nil
+}
+
+// RevokeKey revokes a key of an identity using its current key, it returns a
+// copy of the identity including the revocation.
+func RevokeKey(ctx context.Context, identity *Identity, options *RevokeKeyOptions) (*Identity, error) {
+	if identity == nil {
+		return nil, errmsg.ErrIdentityNotDefined
+	}
+
+	if options == nil {
+		options = &RevokeKeyOptions{}
+	}
+
+	signer := signerOf(&CreateIdentityOptions{Keystore: options.Keystore, Signer: options.Signer})
+	if signer == nil {
+		return nil, errmsg.ErrKeystoreNotDefined
+	}
+
+	revoked := options.PublicKey
+	if revoked == nil {
+		revoked = identity.PublicKey
+	}
+
+	periods := keyPeriods(identity.rootPublicKey(), identity.Rotations)
+
+	switch {
+	case options.Certificate != nil:
+		revoked = options.Certificate.PublicKey
+		if indexOfKey(periods, options.Certificate.IssuedBy) < 0 && !bytes.Equal(options.Certificate.IssuedBy, identity.PublicKey) {
+			return nil, errmsg.ErrKeyNotValid
+		}
+
+	case identity.IsDevice():
+		// devices can only revoke their own key
+		if !bytes.Equal(revoked, identity.PublicKey) {
+			return nil, errmsg.ErrKeyNotValid
+		}
+
+	case indexOfKey(periods, revoked) < 0:
+		return nil, errmsg.ErrKeyNotValid
+	}
+
+	revocation := &KeyRevocation{
+		PublicKey: revoked,
+		After:     options.After,
+		SignedBy:  identity.PublicKey,
+	}
+
+	data, err := revocation.signedBytes(identity.ID)
+	if err != nil {
+		return nil, errmsg.ErrJSONSerializationFailed.Wrap(err)
+	}
+
+	if revocation.Signature, err = signer.Sign(ctx, identity.keyID(), data); err != nil {
+		return nil, errmsg.ErrSigSign.Wrap(err)
+	}
+
+	out := identity.copy()
+	out.Revocations = append(out.Revocations, revocation)
+
+	return out, nil
+}
+
+// KeyHistory holds the key statements of the identities seen in a log, so
+// they apply to entries carrying an older copy of an identity. Revocations
+// only apply to the entries checked after they are known.
+type KeyHistory struct {
+	lock        sync.RWMutex
+	rotations   map[string][]*KeyRotation
+	revocations map[string][]*KeyRevocation
+	known       map[string]struct{}
+}
+
+// NewKeyHistory creates an empty key history.
+func NewKeyHistory() *KeyHistory {
+	return &KeyHistory{
+		rotations:   map[string][]*KeyRotation{},
+		revocations: map[string][]*KeyRevocation{},
+		known:       map[string]struct{}{},
+	}
+}
+
+// Copy returns a history holding the statements of h, statements added to
+// the copy aren't added to h.
+func (h *KeyHistory) Copy() *KeyHistory {
+	out := NewKeyHistory()
+
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	for id, rotations := range h.rotations {
+		out.rotations[id] = append([]*KeyRotation{}, rotations...)
+	}
+
+	for id, revocations := range h.revocations {
+		out.revocations[id] = append([]*KeyRevocation{}, revocations...)
+	}
+
+	for k := range h.known {
+		out.known[k] = struct{}{}
+	}
+
+	return out
+}
+
+// Add verifies and records the key statements of an identity, the
+// certificates of devices are only remembered as verified.
+func (h *KeyHistory) Add(identity *Identity) error {
+	if identity == nil || (len(identity.Rotations) == 0 && len(identity.Revocations) == 0 && len(identity.Certificates) == 0) {
+		return nil
+	}
+
+	rotations, revocations, err := h.verifyStatements(identity)
+	if err != nil {
+		return err
+	}
+
+	if err := h.verifyCertificates(identity); err != nil {
+		return err
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for _, c := range identity.Certificates {
+		data, err := c.signedBytes(identity.ID)
+		if err != nil {
+			return errmsg.ErrJSONSerializationFailed.Wrap(err)
+		}
+
+		h.known[statementKey(data, c.Signature)] = struct{}{}
+	}
+
+	for _, r := range rotations {
+		data, err := r.signedBytes(identity.ID)
+		if err != nil {
+			return errmsg.ErrJSONSerializationFailed.Wrap(err)
+		}
+
+		if h.isKnown(data, r.Signature) {
+			continue
+		}
+
+		h.known[statementKey(data, r.Signature)] = struct{}{}
+		h.rotations[identity.ID] = append(h.rotations[identity.ID], r)
+	}
+
+	for _, r := range revocations {
+		data, err := r.signedBytes(identity.ID)
+		if err != nil {
+			return errmsg.ErrJSONSerializationFailed.Wrap(err)
+		}
+
+		if h.isKnown(data, r.Signature) {
+			continue
+		}
+
+		h.known[statementKey(data, r.Signature)] = struct{}{}
+		h.revocations[identity.ID] = append(h.revocations[identity.ID], r)
+	}
+
+	return nil
+}
+
+// CheckKey checks that a key of an identity is valid at a clock time, using
+// the statements of the identity and the ones of the history. Keys of
+// identities without statements aren't checked. The key of a device is valid
+// while the key of the identity which delegated it is.
+func (h *KeyHistory) CheckKey(identity *Identity, key []byte, time int) error {
+	if identity == nil {
+		return nil
+	}
+
+	if identity.IsDevice() {
+		if !bytes.Equal(key, identity.PublicKey) {
+			return errmsg.ErrKeyNotValid
+		}
+
+		if err := h.verifyCertificates(identity); err != nil {
+			return err
+		}
+
+		key = identity.identityKey()
+	}
+
+	rotations, revocations, err := h.verifyStatements(identity)
+	if err != nil {
+		return err
+	}
+
+	// the rotations of the history are seen before the ones of the identity
+	if h != nil {
+		h.lock.RLock()
+		rotations = append(append([]*KeyRotation{}, h.rotations[identity.ID]...), rotations...)
+		revocations = append(revocations, h.revocations[identity.ID]...)
+		h.lock.RUnlock()
+	}
+
+	if len(rotations) == 0 && len(revocations) == 0 && !identity.IsDevice() {
+		return nil
+	}
+
+	periods := keyPeriods(identity.rootPublicKey(), rotations)
+
+	if identity.IsDevice() {
+		if err := checkDevice(identity, periods, revocations, time); err != nil {
+			return err
+		}
+	}
+
+	idx := indexOfKey(periods, key)
+	if idx < 0 {
+		return errmsg.ErrKeyNotValid
+	}
+
+	if time < periods[idx].start || (periods[idx].end >= 0 && time >= periods[idx].end) {
+		return errmsg.ErrKeyNotValid
+	}
+
+	for _, r := range revocations {
+		if !bytes.Equal(r.PublicKey, key) || time <= r.After {
+			continue
+		}
+
+		if indexOfKey(periods, r.SignedBy) >= idx {
+			return errmsg.ErrKeyRevoked
+		}
+	}
+
+	return nil
+}
+
+// CheckKey checks that a key of an identity is valid at a clock time using
+// the statements of the identity only.
+func CheckKey(identity *Identity, key []byte, time int) error {
+	return (*KeyHistory)(nil).CheckKey(identity, key, time)
+}
+
+// verifyStatements returns the statements of an identity which aren't
+// already known, once their signature is verified.
+func (h *KeyHistory) verifyStatements(identity *Identity) ([]*KeyRotation, []*KeyRevocation, error) {
+	var (
+		rotations   []*KeyRotation
+		revocations []*KeyRevocation
+	)
+
+	if h != nil {
+		h.lock.RLock()
+		defer h.lock.RUnlock()
+	}
+
+	for _, r := range identity.Rotations {
+		data, err := r.signedBytes(identity.ID)
+		if err != nil {
+			return nil, nil, errmsg.ErrJSONSerializationFailed.Wrap(err)
+		}
+
+		if h.isKnown(data, r.Signature) {
+			continue
+		}
+
+		if err := identity.verifyWithKey(r.PublicKey, data, r.Signature); err != nil {
+			return nil, nil, errmsg.ErrKeyStatementNotVerified.Wrap(err)
+		}
+
+		rotations = append(rotations, r)
+	}
+
+	for _, r := range identity.Revocations {
+		data, err := r.signedBytes(identity.ID)
+		if err != nil {
+			return nil, nil, errmsg.ErrJSONSerializationFailed.Wrap(err)
+		}
+
+		if h.isKnown(data, r.Signature) {
+			continue
+		}
+
+		if err := identity.verifyWithKey(r.SignedBy, data, r.Signature); err != nil {
+			return nil, nil, errmsg.ErrKeyStatementNotVerified.Wrap(err)
+		}
+
+		revocations = append(revocations, r)
+	}
+
+	return rotations, revocations, nil
+}
+
+func (h *KeyHistory) isKnown(data, signature []byte) bool {
+	// h.lock must be locked
+	if h == nil {
+		return false
+	}
+
+	_, ok := h.known[statementKey(data, signature)]
+
+	return ok
+}
+
+// statementKey identifies a statement by its signed content and signature,
+// so a known signature can't be reused for another content.
+func statementKey(data, signature []byte) string {
+	return string(data) + "\x00" + string(signature)
+}
+
+type keyPeriod struct {
+	key   []byte
+	start int
+	end   int
+}
+
+// keyPeriods follows the rotations from a root key, it returns the keys of
+// the chain and the clock times during which they are valid, an end of -1
+// meaning the key is not retired. Rotations are given in the order they were
+// seen, the first rotation of a key is the one followed.
+func keyPeriods(root []byte, rotations []*KeyRotation) []keyPeriod {
+	periods := []keyPeriod{{key: root, start: 0, end: -1}}
+
+	for {
+		cur := len(periods) - 1
+
+		var next *KeyRotation
+
+		for _, r := range rotations {
+			if bytes.Equal(r.PublicKey, periods[cur].key) && r.Start >= periods[cur].start {
+				next = r
+				break
+			}
+		}
+
+		if next == nil {
+			return periods
+		}
+
+		periods[cur].end = next.Start
+
+		if indexOfKey(periods, next.NewPublicKey) >= 0 {
+			return periods
+		}
+
+		periods = append(periods, keyPeriod{key: next.NewPublicKey, start: next.Start, end: -1})
+	}
+}
+
+func indexOfKey(periods []keyPeriod, key []byte) int {
+	for i, p := range periods {
+		if bytes.Equal(p.key, key) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// rootPublicKey returns the key the identity was created with.
+func (i *Identity) rootPublicKey() []byte {
+	if len(i.Rotations) > 0 {
+		return i.Rotations[0].PublicKey
+	}
+
+	return i.identityKey()
+}
+
+// root returns the identity as it was created, before its keys were rotated
+// or delegated.
+func (i *Identity) root() *Identity {
+	if len(i.Rotations) == 0 && len(i.Revocations) == 0 && len(i.Certificates) == 0 {
+		return i
+	}
+
+	return &Identity{
+		ID:         i.ID,
+		PublicKey:  i.rootPublicKey(),
+		Signatures: i.Signatures,
+		Type:       i.Type,
+		Provider:   i.Provider,
+	}
+}
+
+// checkStatements checks the signatures of the statements of an identity,
+// and that its key is reached by its rotations and certificates.
+func (i *Identity) checkStatements() error {
+	if _, _, err := (*KeyHistory)(nil).verifyStatements(i); err != nil {
+		return err
+	}
+
+	if err := (*KeyHistory)(nil).verifyCertificates(i); err != nil {
+		return err
+	}
+
+	if _, err := i.checkCertificates(); err != nil {
+		return err
+	}
+
+	if len(i.Rotations) == 0 {
+		return nil
+	}
+
+	periods := keyPeriods(i.rootPublicKey(), i.Rotations)
+	if !bytes.Equal(periods[len(periods)-1].key, i.identityKey()) {
+		return errmsg.ErrKeyNotValid
+	}
+
+	return nil
+}
+
+func (i *Identity) verifyWithKey(key, data, signature []byte) error {
+	pubKey, err := i.unmarshalPublicKey(key)
+	if err != nil {
+		return err
+	}
+
+	ok, err := pubKey.Verify(data, signature)
+	if err != nil {
+		return errmsg.ErrSigNotVerified.Wrap(err)
+	}
+
+	if !ok {
+		return errmsg.ErrSigNotVerified
+	}
+
+	return nil
+}
+
+// unmarshalPublicKey reads a key serialized by the provider of the identity
+// type.
+func (i *Identity) unmarshalPublicKey(data []byte) (ic.PubKey, error) {
+	if i.Provider != nil && i.Provider.GetType() == i.Type {
+		return i.Provider.UnmarshalPublicKey(data)
+	}
+
+	if provider, err := ProviderFor(i.Type); err == nil {
+		return provider.UnmarshalPublicKey(data)
+	}
+
+	return UnmarshalPublicKey(data)
+}
@@ -0,0 +1,90 @@
+package identityprovider // import "berty.tech/go-ipfs-log/identityprovider"
+
+import (
+	"context"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+
+	"berty.tech/go-ipfs-log/errmsg"
+	"berty.tech/go-ipfs-log/keystore"
+)
+
+// Signer holds the private keys of the identities, it can live out of the
+// process such as with RemoteSigner.
+type Signer interface {
+	// PublicKey returns the public key of a given key ID, the key is
+	// created using keyType if it doesn't exist.
+	PublicKey(ctx context.Context, id string, keyType keystore.KeyType) (crypto.PubKey, error)
+
+	// Sign signs data using the key of a given key ID.
+	Sign(ctx context.Context, id string, data []byte) ([]byte, error)
+}
+
+type keystoreSigner struct {
+	keystore keystore.Interface
+}
+
+// NewKeystoreSigner creates a signer using the keys of a local keystore.
+func NewKeystoreSigner(ks keystore.Interface) Signer {
+	return &keystoreSigner{keystore: ks}
+}
+
+func (s *keystoreSigner) PublicKey(ctx context.Context, id string, keyType keystore.KeyType) (crypto.PubKey, error) {
+	private, err := s.keystore.GetKey(ctx, id)
+	if err != nil || private == nil {
+		private, err = createKey(ctx, s.keystore, id, keyType)
+		if err != nil {
+			return nil, errmsg.ErrKeyStoreCreateEntry.Wrap(err)
+		}
+	}
+
+	return private.GetPublic(), nil
+}
+
+func (s *keystoreSigner) Sign(ctx context.Context, id string, data []byte) ([]byte, error) {
+	private, err := s.keystore.GetKey(ctx, id)
+	if err != nil {
+		return nil, errmsg.ErrKeyNotInKeystore.Wrap(err)
+	}
+
+	sig, err := s.keystore.Sign(private, data)
+	if err != nil {
+		return nil, errmsg.ErrSigSign.Wrap(err)
+	}
+
+	return sig, nil
+}
+
+// createKey creates a key of a given type, secp256k1 keys are supported by
+// every keystore.
+func createKey(ctx context.Context, ks keystore.Interface, id string, keyType keystore.KeyType) (crypto.PrivKey, error) {
+	if keyType == "" || keyType == keystore.KeyTypeSecp256k1 {
+		return ks.CreateKey(ctx, id)
+	}
+
+	typed, ok := ks.(keystore.TypedInterface)
+	if !ok {
+		return nil, errmsg.ErrUnsupportedKeyType
+	}
+
+	return typed.CreateKeyWithType(ctx, id, keyType)
+}
+
+// signerOf returns the signer of the options, defaults to the keystore.
+func signerOf(options *CreateIdentityOptions) Signer {
+	if options == nil {
+		return nil
+	}
+
+	if options.Signer != nil {
+		return options.Signer
+	}
+
+	if options.Keystore != nil {
+		return NewKeystoreSigner(options.Keystore)
+	}
+
+	return nil
+}
+
+var _ Signer = (*keystoreSigner)(nil)
@@ -0,0 +1,211 @@
+package identityprovider // import "berty.tech/go-ipfs-log/identityprovider"
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+
+	"berty.tech/go-ipfs-log/errmsg"
+	"berty.tech/go-ipfs-log/keystore"
+)
+
+// signerService is the name of the JSON-RPC service of SignerServer.
+const signerService = "Signer"
+
+// PublicKeyRequest is the request of the Signer.PublicKey method.
+type PublicKeyRequest struct {
+	ID      string `json:"id"`
+	KeyType string `json:"keyType,omitempty"`
+}
+
+// PublicKeyResponse is the response of the Signer.PublicKey method, the key
+// uses the libp2p encoding.
+type PublicKeyResponse struct {
+	PublicKey []byte `json:"publicKey"`
+}
+
+// SignRequest is the request of the Signer.Sign method.
+type SignRequest struct {
+	ID   string `json:"id"`
+	Data []byte `json:"data"`
+}
+
+// SignResponse is the response of the Signer.Sign method.
+type SignResponse struct {
+	Signature []byte `json:"signature"`
+}
+
+// SignPersonalMessageRequest is the request of the Signer.SignPersonalMessage
+// method.
+type SignPersonalMessageRequest struct {
+	ID      string `json:"id"`
+	Message []byte `json:"message"`
+}
+
+// RemoteSigner is a signer whose keys are held by another process, it
+// talks JSON-RPC 1.0 over a stream such as a Unix socket.
+type RemoteSigner struct {
+	client *rpc.Client
+}
+
+// DialRemoteSigner connects to a signer served by a SignerServer, such as
+// DialRemoteSigner("unix", "/run/signer.sock").
+func DialRemoteSigner(network, address string) (*RemoteSigner, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, errmsg.ErrSignerUnavailable.Wrap(err)
+	}
+
+	return NewRemoteSigner(conn), nil
+}
+
+// NewRemoteSigner creates a signer talking to a server over conn.
+func NewRemoteSigner(conn io.ReadWriteCloser) *RemoteSigner {
+	return &RemoteSigner{client: jsonrpc.NewClient(conn)}
+}
+
+// PublicKey implements Signer.
+func (s *RemoteSigner) PublicKey(ctx context.Context, id string, keyType keystore.KeyType) (crypto.PubKey, error) {
+	res := &PublicKeyResponse{}
+	if err := s.call(ctx, "PublicKey", &PublicKeyRequest{ID: id, KeyType: string(keyType)}, res); err != nil {
+		return nil, err
+	}
+
+	pubKey, err := crypto.UnmarshalPublicKey(res.PublicKey)
+	if err != nil {
+		return nil, errmsg.ErrInvalidPubKeyFormat.Wrap(err)
+	}
+
+	return pubKey, nil
+}
+
+// Sign implements Signer.
+func (s *RemoteSigner) Sign(ctx context.Context, id string, data []byte) ([]byte, error) {
+	res := &SignResponse{}
+	if err := s.call(ctx, "Sign", &SignRequest{ID: id, Data: data}, res); err != nil {
+		return nil, err
+	}
+
+	return res.Signature, nil
+}
+
+// SignPersonalMessage implements EthereumSigner, the signer of the server
+// must implement it.
+func (s *RemoteSigner) SignPersonalMessage(ctx context.Context, id string, message []byte) ([]byte, error) {
+	res := &SignResponse{}
+	if err := s.call(ctx, "SignPersonalMessage", &SignPersonalMessageRequest{ID: id, Message: message}, res); err != nil {
+		return nil, err
+	}
+
+	return res.Signature, nil
+}
+
+// Close closes the connection to the server.
+func (s *RemoteSigner) Close() error {
+	return s.client.Close()
+}
+
+func (s *RemoteSigner) call(ctx context.Context, method string, req interface{}, res interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return errmsg.ErrSignerUnavailable.Wrap(err)
+	}
+
+	call := s.client.Go(signerService+"."+method, req, res, make(chan *rpc.Call, 1))
+
+	select {
+	case <-ctx.Done():
+		return errmsg.ErrSignerUnavailable.Wrap(ctx.Err())
+	case <-call.Done:
+	}
+
+	switch call.Error.(type) {
+	case nil:
+		return nil
+	case rpc.ServerError:
+		return errmsg.ErrSigSign.Wrap(call.Error)
+	}
+
+	return errmsg.ErrSignerUnavailable.Wrap(call.Error)
+}
+
+// SignerServer serves the keys of a signer to RemoteSigner clients, it runs
+// in the process holding the keys.
+type SignerServer struct {
+	server *rpc.Server
+}
+
+// NewSignerServer creates a server for the keys of signer.
+func NewSignerServer(signer Signer) (*SignerServer, error) {
+	server := rpc.NewServer()
+	if err := server.RegisterName(signerService, &signerServer{signer: signer}); err != nil {
+		return nil, err
+	}
+
+	return &SignerServer{server: server}, nil
+}
+
+// Serve accepts connections on l until it is closed.
+func (s *SignerServer) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves a single connection until the client hangs up.
+func (s *SignerServer) ServeConn(conn io.ReadWriteCloser) {
+	s.server.ServeCodec(jsonrpc.NewServerCodec(conn))
+}
+
+type signerServer struct {
+	signer Signer
+}
+
+func (s *signerServer) PublicKey(req *PublicKeyRequest, res *PublicKeyResponse) error {
+	pubKey, err := s.signer.PublicKey(context.Background(), req.ID, keystore.KeyType(req.KeyType))
+	if err != nil {
+		return err
+	}
+
+	res.PublicKey, err = crypto.MarshalPublicKey(pubKey)
+
+	return err
+}
+
+func (s *signerServer) Sign(req *SignRequest, res *SignResponse) error {
+	sig, err := s.signer.Sign(context.Background(), req.ID, req.Data)
+	if err != nil {
+		return err
+	}
+
+	res.Signature = sig
+
+	return nil
+}
+
+func (s *signerServer) SignPersonalMessage(req *SignPersonalMessageRequest, res *SignResponse) error {
+	signer, ok := s.signer.(EthereumSigner)
+	if !ok {
+		return errmsg.ErrSigSign.Wrap(fmt.Errorf("signer can't sign Ethereum messages"))
+	}
+
+	sig, err := signer.SignPersonalMessage(context.Background(), req.ID, req.Message)
+	if err != nil {
+		return err
+	}
+
+	res.Signature = sig
+
+	return nil
+}
+
+var _ EthereumSigner = (*RemoteSigner)(nil)
@@ -4,9 +4,9 @@ import (
 	"context"
 	"time"
 
+	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
 	format "github.com/ipfs/go-ipld-format"
-	coreiface "github.com/ipfs/kubo/core/coreiface"
 
 	"berty.tech/go-ipfs-log/accesscontroller"
 	"berty.tech/go-ipfs-log/identityprovider"
@@ -14,6 +14,19 @@ import (
 
 const KeyEncryptedLinks = "encrypted_links"
 const KeyEncryptedLinksNonce = "encrypted_links_nonce"
+const KeyEncryptedLinksKeyID = "encrypted_links_key_id"
+const KeyEncryptedPayload = "encrypted_payload"
+const KeyEncryptedPayloadKeyID = "encrypted_payload_key_id"
+const KeyEncryptedRecipients = "encrypted_recipients"
+const KeyEncryptedLinksAlgorithm = "encrypted_links_alg"
+const KeyEncryptedPayloadAlgorithm = "encrypted_payload_alg"
+const KeyEncryptedPayloadPadding = "encrypted_payload_pad"
+const KeyEncryptedLinksVersion = "encrypted_links_v"
+
+// EncryptedLinksSigned is the version of the entries whose signature covers
+// their encrypted links instead of the clear ones. Entries without version
+// sign their clear links.
+const EncryptedLinksSigned = "2"
 
 type WriteOpts struct {
 	Pin                 bool
@@ -34,9 +47,25 @@ type FetchOptions struct {
 	IO           IO
 }
 
+// Storage is the minimal block storage used to persist and retrieve the
+// entries of a log, see the storage package for implementations.
+type Storage interface {
+	// Get retrieves a block, it may be fetched from the network.
+	Get(ctx context.Context, c cid.Cid) (blocks.Block, error)
+
+	// Put stores a block.
+	Put(ctx context.Context, block blocks.Block) error
+
+	// Has checks whether a block is available locally.
+	Has(ctx context.Context, c cid.Cid) (bool, error)
+
+	// Pin prevents a stored block from being garbage collected.
+	Pin(ctx context.Context, c cid.Cid) error
+}
+
 type IO interface {
-	Write(ctx context.Context, ipfs coreiface.CoreAPI, obj interface{}, opts *WriteOpts) (cid.Cid, error)
-	Read(ctx context.Context, ipfs coreiface.CoreAPI, contentIdentifier cid.Cid) (format.Node, error)
+	Write(ctx context.Context, storage Storage, obj interface{}, opts *WriteOpts) (cid.Cid, error)
+	Read(ctx context.Context, storage Storage, contentIdentifier cid.Cid) (format.Node, error)
 	DecodeRawEntry(node format.Node, hash cid.Cid, p identityprovider.Interface) (IPFSLogEntry, error)
 	DecodeRawJSONLog(node format.Node) (*JSONLog, error)
 }
@@ -46,6 +75,13 @@ type IOPreSign interface {
 	PreSign(entry IPFSLogEntry) (IPFSLogEntry, error)
 }
 
+// IORegistry is implemented by the IOs restricting the identity providers
+// accepted in the entries they read.
+type IORegistry interface {
+	IO
+	IdentityRegistry() *identityprovider.Registry
+}
+
 type LogOptions struct {
 	ID               string
 	AccessController accesscontroller.Interface
@@ -55,6 +91,10 @@ type LogOptions struct {
 	SortFn           func(a, b IPFSLogEntry) (int, error)
 	Concurrency      uint
 	IO               IO
+
+	// KeyHistory records the key statements of the identities of the log,
+	// defaults to a new history.
+	KeyHistory *identityprovider.KeyHistory
 }
 
 type CreateEntryOptions struct {
@@ -9,11 +9,9 @@ import (
 	"berty.tech/go-ipfs-log/enc"
 	"github.com/ipfs/go-ipld-cbor/encoding"
 
-	"github.com/ipfs/boxo/path"
 	"github.com/ipfs/go-cid"
 	cbornode "github.com/ipfs/go-ipld-cbor"
 	format "github.com/ipfs/go-ipld-format"
-	coreiface "github.com/ipfs/kubo/core/coreiface"
 	ic "github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/polydawn/refmt/obj/atlas"
 
@@ -30,7 +28,16 @@ type IOCbor struct {
 	refEntry iface.IPFSLogEntry
 
 	constantIdentity *identityprovider.Identity
-	linkKey          enc.SharedKey
+	linkKeys         *enc.KeyRing
+	payloadKeys      *enc.KeyRing
+	recipients       []*enc.BoxPublicKey
+	recipientKey     *enc.BoxKeyPair
+	recipientsLinks  bool
+	envelopeKeys     *enc.KeyRing
+	headsKeys        *enc.KeyRing
+	sealHeads        bool
+	payloadPadding   enc.Padding
+	registry         *identityprovider.Registry
 	atlasEntries     []*atlas.AtlasEntry
 	cborMarshaller   encoding.PooledMarshaller
 	cborUnmarshaller encoding.PooledUnmarshaller
@@ -39,31 +46,107 @@ type IOCbor struct {
 type Options struct {
 	//ConstantIdentity *identityprovider.Identity
 	LinkKey enc.SharedKey
+
+	// LinkKeyRing takes precedence over LinkKey, links are encrypted using
+	// its current key and the key ID is recorded in the entries.
+	LinkKeyRing *enc.KeyRing
+
+	// PayloadKey encrypts the payload of the entries, each one using a
+	// random nonce.
+	PayloadKey enc.SharedKey
+
+	// PayloadKeyRing takes precedence over PayloadKey.
+	PayloadKeyRing *enc.KeyRing
+
+	// PayloadPadding pads the payloads before they are encrypted, so their
+	// size is hidden.
+	PayloadPadding enc.Padding
+
+	// Recipients takes precedence over the shared keys when writing, the
+	// payload and links of each entry are encrypted using a random content
+	// key which is sealed for every recipient.
+	Recipients []*enc.BoxPublicKey
+
+	// RecipientKey opens the content key of the entries sealed for it,
+	// entries sealed for other recipients are left encrypted.
+	RecipientKey *enc.BoxKeyPair
+
+	// RecipientsClearLinks leaves the links of the entries sealed for
+	// recipients in clear, so they can be fetched by anyone.
+	RecipientsClearLinks bool
+
+	// EnvelopeKey encrypts the whole entries, including the identity, key,
+	// signature, clock and log ID of their author. Entries are verified
+	// once decrypted, they can't be read at all without the key.
+	EnvelopeKey enc.SharedKey
+
+	// EnvelopeKeyRing takes precedence over EnvelopeKey.
+	EnvelopeKeyRing *enc.KeyRing
+
+	// HeadsKey encrypts the log heads written by ToMultihash, they are
+	// sealed for the Recipients instead if SealHeads is set.
+	HeadsKey enc.SharedKey
+
+	// HeadsKeyRing takes precedence over HeadsKey.
+	HeadsKeyRing *enc.KeyRing
+
+	// SealHeads seals the log heads for the Recipients.
+	SealHeads bool
+
+	// Registry restricts the identity providers of the entries read, entries
+	// of other identity types are rejected. Entries are verified using the
+	// default registry when not set.
+	Registry *identityprovider.Registry
 }
 
 func (i *IOCbor) DecodeRawJSONLog(node format.Node) (*iface.JSONLog, error) {
 	jsonLog := &iface.JSONLog{}
-	err := cbornode.DecodeInto(node.RawData(), jsonLog)
 
+	sealed := &jsonable.EncryptedJSONLog{}
+	if err := cbornode.DecodeInto(node.RawData(), sealed); err != nil || sealed.EncryptedHeads == "" {
+		if err := cbornode.DecodeInto(node.RawData(), jsonLog); err != nil {
+			return nil, errmsg.ErrCBOROperationFailed.Wrap(err)
+		}
+
+		return jsonLog, nil
+	}
+
+	dec, err := OpenJSONLog(i.headsKeys, i.recipientKey, sealed)
 	if err != nil {
-		return nil, errmsg.ErrCBOROperationFailed.Wrap(err)
+		return nil, err
+	}
+
+	if err := i.cborUnmarshaller.Unmarshal(dec, jsonLog); err != nil {
+		return nil, errmsg.ErrDecrypt.Wrap(fmt.Errorf("unable to unmarshal decrypted heads: %w", err))
 	}
 
 	return jsonLog, nil
 }
 
 func (i *IOCbor) DecodeRawEntry(node format.Node, hash cid.Cid, p identityprovider.Interface) (iface.IPFSLogEntry, error) {
-	obj := &jsonable.EntryV2{}
-	err := cbornode.DecodeInto(node.RawData(), obj)
+	obj, err := i.decodeEntry(node)
 	if err != nil {
-		return nil, errmsg.ErrCBOROperationFailed.Wrap(err)
+		return nil, err
 	}
 
-	obj, err = i.DecryptLinks(obj)
+	if err := CheckEncryptedLinks(obj); err != nil {
+		return nil, errmsg.ErrEntryDeserializationFailed.Wrap(err)
+	}
+
+	linkKeys, payloadKeys, err := KeyRingsForEntry(i.recipientKey, i.linkKeys, i.payloadKeys, obj)
 	if err != nil {
 		return nil, errmsg.ErrDecrypt.Wrap(err)
 	}
 
+	obj, err = i.decryptLinks(linkKeys, obj)
+	if err != nil {
+		return nil, errmsg.ErrDecrypt.Wrap(err)
+	}
+
+	if err := DecryptPayload(payloadKeys, obj); err != nil {
+		return nil, errmsg.ErrDecrypt.Wrap(err)
+	}
+
 	obj.Hash = hash
 
 	e := i.refEntry.New()
@@ -73,6 +156,10 @@ func (i *IOCbor) DecodeRawEntry(node format.Node, hash cid.Cid, p identityprovid
 
 	e.SetHash(hash)
 
+	if err := SetIdentityProvider(i.registry, e, p); err != nil {
+		return nil, err
+	}
+
 	if i.constantIdentity != nil {
 		e.SetIdentity(i.constantIdentity)
 		e.SetKey(i.constantIdentity.PublicKey)
@@ -81,6 +168,59 @@ func (i *IOCbor) DecodeRawEntry(node format.Node, hash cid.Cid, p identityprovid
 	return e, nil
 }
 
+// IdentityRegistry returns the registry of the identity providers accepted
+// in the entries, nil if not restricted.
+func (i *IOCbor) IdentityRegistry() *identityprovider.Registry {
+	return i.registry
+}
+
+// SetIdentityProvider sets the provider of the identity of an entry read
+// using a registry, entries of an identity type not in the registry are
+// rejected. It does nothing if the registry is nil.
+func SetIdentityProvider(registry *identityprovider.Registry, e iface.IPFSLogEntry, p identityprovider.Interface) error {
+	if registry == nil || e.GetIdentity() == nil {
+		return nil
+	}
+
+	provider, err := registry.ProviderOf(e.GetIdentity(), p)
+	if err != nil {
+		return errmsg.ErrEntryDeserializationFailed.Wrap(err)
+	}
+
+	e.GetIdentity().Provider = provider
+
+	return nil
+}
+
+// decodeEntry decodes an entry, opening its envelope if it is encrypted.
+func (i *IOCbor) decodeEntry(node format.Node) (*jsonable.EntryV2, error) {
+	obj := &jsonable.EntryV2{}
+
+	envelope := &jsonable.EncryptedEntry{}
+	if err := cbornode.DecodeInto(node.RawData(), envelope); err != nil || envelope.EncryptedEntry == "" {
+		if err := cbornode.DecodeInto(node.RawData(), obj); err != nil {
+			return nil, errmsg.ErrCBOROperationFailed.Wrap(err)
+		}
+
+		return obj, nil
+	}
+
+	if i.envelopeKeys == nil {
+		return nil, errmsg.ErrDecrypt.Wrap(enc.ErrInvalidKey)
+	}
+
+	dec, err := OpenEnvelope(i.envelopeKeys, envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := i.cborUnmarshaller.Unmarshal(dec, obj); err != nil {
+		return nil, errmsg.ErrDecrypt.Wrap(fmt.Errorf("unable to unmarshal decrypted entry: %w", err))
+	}
+
+	return obj, nil
+}
+
 var _io = (*IOCbor)(nil)
 
 func IO(refEntry iface.IPFSLogEntry, refClock iface.IPFSLogLamportClock) (*IOCbor, error) {
@@ -108,7 +248,31 @@ func IO(refEntry iface.IPFSLogEntry, refClock iface.IPFSLogLamportClock) (*IOCbo
 			AddField("Payload", atlas.StructMapEntry{SerialName: "payload"}).
 			AddField("Identity", atlas.StructMapEntry{SerialName: "identity"}).
 			AddField("EncryptedLinks", atlas.StructMapEntry{SerialName: "enc_links", OmitEmpty: true}).
+			// fields are declared in canonical dag-cbor order (length first)
+			AddField("EncryptedLinksV", atlas.StructMapEntry{SerialName: "enc_links_v", OmitEmpty: true}).
+			AddField("EncryptedPayload", atlas.StructMapEntry{SerialName: "enc_payload", OmitEmpty: true}).
+			AddField("EncryptedLinksAlg", atlas.StructMapEntry{SerialName: "enc_links_alg", OmitEmpty: true}).
+			AddField("EncryptedRecipients", atlas.StructMapEntry{SerialName: "enc_recipients", OmitEmpty: true}).
 			AddField("EncryptedLinksNonce", atlas.StructMapEntry{SerialName: "enc_links_nonce", OmitEmpty: true}).
+			AddField("EncryptedPayloadAlg", atlas.StructMapEntry{SerialName: "enc_payload_alg", OmitEmpty: true}).
+			AddField("EncryptedPayloadPad", atlas.StructMapEntry{SerialName: "enc_payload_pad", OmitEmpty: true}).
+			AddField("EncryptedLinksKeyID", atlas.StructMapEntry{SerialName: "enc_links_key_id", OmitEmpty: true}).
+			AddField("EncryptedPayloadKeyID", atlas.StructMapEntry{SerialName: "enc_payload_key_id", OmitEmpty: true}).
+			Complete(),
+
+		atlas.BuildEntry(jsonable.EncryptedEntry{}).
+			StructMap().
+			AddField("EncryptedEntry", atlas.StructMapEntry{SerialName: "enc_entry"}).
+			AddField("Alg", atlas.StructMapEntry{SerialName: "enc_entry_alg", OmitEmpty: true}).
+			AddField("KeyID", atlas.StructMapEntry{SerialName: "enc_entry_key_id", OmitEmpty: true}).
+			Complete(),
+
+		atlas.BuildEntry(jsonable.EncryptedJSONLog{}).
+			StructMap().
+			AddField("EncryptedHeads", atlas.StructMapEntry{SerialName: "enc_heads"}).
+			AddField("Alg", atlas.StructMapEntry{SerialName: "enc_heads_alg", OmitEmpty: true}).
+			AddField("Recipients", atlas.StructMapEntry{SerialName: "enc_recipients", OmitEmpty: true}).
+			AddField("KeyID", atlas.StructMapEntry{SerialName: "enc_heads_key_id", OmitEmpty: true}).
 			Complete(),
 
 		atlas.BuildEntry(jsonable.EntryV1{}).
@@ -147,7 +311,36 @@ func IO(refEntry iface.IPFSLogEntry, refClock iface.IPFSLogLamportClock) (*IOCbo
 			AddField("ID", atlas.StructMapEntry{SerialName: "id"}).
 			AddField("Type", atlas.StructMapEntry{SerialName: "type"}).
 			AddField("PublicKey", atlas.StructMapEntry{SerialName: "publicKey"}).
+			AddField("Rotations", atlas.StructMapEntry{SerialName: "rotations", OmitEmpty: true}).
 			AddField("Signatures", atlas.StructMapEntry{SerialName: "signatures"}).
+			AddField("Revocations", atlas.StructMapEntry{SerialName: "revocations", OmitEmpty: true}).
+			AddField("Certificates", atlas.StructMapEntry{SerialName: "certificates", OmitEmpty: true}).
+			Complete(),
+
+		// fields are listed in the canonical order of their serial name
+		atlas.BuildEntry(jsonable.KeyRotation{}).
+			StructMap().
+			AddField("Start", atlas.StructMapEntry{SerialName: "start"}).
+			AddField("PublicKey", atlas.StructMapEntry{SerialName: "publicKey"}).
+			AddField("Signature", atlas.StructMapEntry{SerialName: "signature"}).
+			AddField("NewPublicKey", atlas.StructMapEntry{SerialName: "newPublicKey"}).
+			Complete(),
+
+		atlas.BuildEntry(jsonable.KeyRevocation{}).
+			StructMap().
+			AddField("After", atlas.StructMapEntry{SerialName: "after"}).
+			AddField("SignedBy", atlas.StructMapEntry{SerialName: "signedBy"}).
+			AddField("PublicKey", atlas.StructMapEntry{SerialName: "publicKey"}).
+			AddField("Signature", atlas.StructMapEntry{SerialName: "signature"}).
+			Complete(),
+
+		atlas.BuildEntry(jsonable.DeviceCertificate{}).
+			StructMap().
+			AddField("Expiry", atlas.StructMapEntry{SerialName: "expiry"}).
+			AddField("IssuedBy", atlas.StructMapEntry{SerialName: "issuedBy"}).
+			AddField("PublicKey", atlas.StructMapEntry{SerialName: "publicKey"}).
+			AddField("Signature", atlas.StructMapEntry{SerialName: "signature"}).
+			AddField("Capabilities", atlas.StructMapEntry{SerialName: "capabilities", OmitEmpty: true}).
 			Complete(),
 
 		atlas.BuildEntry(jsonable.IdentitySignature{}).
@@ -217,7 +410,16 @@ func (i *IOCbor) ApplyOptions(options *Options) *IOCbor {
 		refEntry:     i.refEntry,
 		atlasEntries: i.atlasEntries,
 		//constantIdentity: options.ConstantIdentity,
-		linkKey: options.LinkKey,
+		linkKeys:        KeyRingFromOptions(options.LinkKeyRing, options.LinkKey),
+		payloadKeys:     KeyRingFromOptions(options.PayloadKeyRing, options.PayloadKey),
+		recipients:      options.Recipients,
+		recipientKey:    options.RecipientKey,
+		recipientsLinks: !options.RecipientsClearLinks,
+		envelopeKeys:    KeyRingFromOptions(options.EnvelopeKeyRing, options.EnvelopeKey),
+		headsKeys:       KeyRingFromOptions(options.HeadsKeyRing, options.HeadsKey),
+		sealHeads:       options.SealHeads && len(options.Recipients) > 0,
+		payloadPadding:  options.PayloadPadding,
+		registry:        options.Registry,
 	}
 
 	out.createCborMarshaller()
@@ -230,7 +432,7 @@ func (i *IOCbor) SetDebug(val bool) {
 }
 
 // WriteCBOR writes a CBOR representation of a given object in IPFS' DAG.
-func (i *IOCbor) Write(ctx context.Context, ipfs coreiface.CoreAPI, obj interface{}, opts *iface.WriteOpts) (cid.Cid, error) {
+func (i *IOCbor) Write(ctx context.Context, storage iface.Storage, obj interface{}, opts *iface.WriteOpts) (cid.Cid, error) {
 	if opts == nil {
 		opts = &iface.WriteOpts{}
 	}
@@ -243,7 +445,42 @@ func (i *IOCbor) Write(ctx context.Context, ipfs coreiface.CoreAPI, obj interfac
 		}
 
 		obj = jsonable.ToJsonableEntry(o)
-		break
+
+		if e, ok := obj.(*jsonable.EntryV2); ok && i.envelopeKeys != nil {
+			data, err := i.cborMarshaller.Marshal(e)
+			if err != nil {
+				return cid.Undef, errmsg.ErrEncrypt.Wrap(fmt.Errorf("unable to cbor entry: %w", err))
+			}
+
+			envelope, err := SealEnvelope(i.envelopeKeys, data)
+			if err != nil {
+				return cid.Undef, err
+			}
+
+			obj = envelope
+		}
+
+	case *iface.JSONLog:
+		if i.headsKeys == nil && !i.sealHeads {
+			break
+		}
+
+		data, err := i.cborMarshaller.Marshal(o)
+		if err != nil {
+			return cid.Undef, errmsg.ErrEncrypt.Wrap(fmt.Errorf("unable to cbor heads: %w", err))
+		}
+
+		var recipients []*enc.BoxPublicKey
+		if i.sealHeads {
+			recipients = i.recipients
+		}
+
+		sealed, err := SealJSONLog(i.headsKeys, recipients, data)
+		if err != nil {
+			return cid.Undef, err
+		}
+
+		obj = sealed
 	}
 
 	cborNode, err := cbornode.WrapObject(obj, math.MaxUint64, -1)
@@ -255,13 +492,13 @@ func (i *IOCbor) Write(ctx context.Context, ipfs coreiface.CoreAPI, obj interfac
 		fmt.Printf("\nStr of cbor: %x\n", cborNode.RawData())
 	}
 
-	err = ipfs.Dag().Add(ctx, cborNode)
+	err = storage.Put(ctx, cborNode)
 	if err != nil {
 		return cid.Undef, errmsg.ErrIPFSOperationFailed.Wrap(err)
 	}
 
 	if opts.Pin {
-		if err = ipfs.Pin().Add(ctx, path.FromCid(cborNode.Cid())); err != nil {
+		if err = storage.Pin(ctx, cborNode.Cid()); err != nil {
 			return cid.Undef, errmsg.ErrIPFSOperationFailed.Wrap(err)
 		}
 	}
@@ -270,20 +507,38 @@ func (i *IOCbor) Write(ctx context.Context, ipfs coreiface.CoreAPI, obj interfac
 }
 
 // Read reads a CBOR representation of a given object from IPFS' DAG.
-func (i *IOCbor) Read(ctx context.Context, ipfs coreiface.CoreAPI, contentIdentifier cid.Cid) (format.Node, error) {
-	return ipfs.Dag().Get(ctx, contentIdentifier)
+func (i *IOCbor) Read(ctx context.Context, storage iface.Storage, contentIdentifier cid.Cid) (format.Node, error) {
+	block, err := storage.Get(ctx, contentIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	if node, ok := block.(format.Node); ok {
+		return node, nil
+	}
+
+	return cbornode.DecodeBlock(block)
 }
 
 func (i *IOCbor) PreSign(entry iface.IPFSLogEntry) (iface.IPFSLogEntry, error) {
-	if i.linkKey == nil {
+	if i.linkKeys == nil && i.payloadKeys == nil && len(i.recipients) == 0 {
 		return entry, nil
 	}
 
-	if len(entry.GetNext()) == 0 && len(entry.GetRefs()) == 0 {
-		return entry, nil
+	entry = entry.Copy()
+
+	linkKeys, payloadKeys, err := KeyRingsForWrite(i.recipients, i.recipientsLinks, i.linkKeys, i.payloadKeys, entry)
+	if err != nil {
+		return nil, err
 	}
 
-	entry = entry.Copy()
+	if err := EncryptPayload(payloadKeys, i.payloadPadding, entry); err != nil {
+		return nil, err
+	}
+
+	if linkKeys == nil || !NeedsLinksEncryption(entry) {
+		return entry, nil
+	}
 
 	links := &jsonable.EntryV2{}
 	links.Next = entry.GetNext()
@@ -294,40 +549,25 @@ func (i *IOCbor) PreSign(entry iface.IPFSLogEntry) (iface.IPFSLogEntry, error) {
 		return nil, errmsg.ErrEncrypt.Wrap(fmt.Errorf("unable to cbor entry: %w", err))
 	}
 
-	nonce, err := i.linkKey.DeriveNonce(NonceRefForEntry(entry))
-	if err != nil {
-		return nil, errmsg.ErrEncrypt.Wrap(err)
-	}
-
-	encryptedLinks, err := i.linkKey.SealWithNonce(cborPayload, nonce)
-	if err != nil {
-		return nil, errmsg.ErrEncrypt.Wrap(fmt.Errorf("unable to encrypt message"))
+	if err := SealLinks(linkKeys, entry, cborPayload); err != nil {
+		return nil, err
 	}
 
-	entry.SetAdditionalDataValue(iface.KeyEncryptedLinks, base64.StdEncoding.EncodeToString(encryptedLinks))
-	entry.SetAdditionalDataValue(iface.KeyEncryptedLinksNonce, base64.StdEncoding.EncodeToString(nonce))
-
 	return entry, nil
 }
 
 func (i *IOCbor) DecryptLinks(entry *jsonable.EntryV2) (*jsonable.EntryV2, error) {
-	if i.linkKey == nil || len(entry.EncryptedLinks) == 0 || len(entry.EncryptedLinksNonce) == 0 {
-		return entry, nil
-	}
-
-	encryptedLinks, err := base64.StdEncoding.DecodeString(entry.EncryptedLinks)
-	if err != nil {
-		return nil, errmsg.ErrEntryDeserializationFailed.Wrap(err)
-	}
+	return i.decryptLinks(i.linkKeys, entry)
+}
 
-	encryptedLinksNonce, err := base64.StdEncoding.DecodeString(entry.EncryptedLinksNonce)
-	if err != nil {
-		return nil, errmsg.ErrEntryDeserializationFailed.Wrap(err)
+func (i *IOCbor) decryptLinks(keys *enc.KeyRing, entry *jsonable.EntryV2) (*jsonable.EntryV2, error) {
+	if keys == nil || len(entry.EncryptedLinks) == 0 || len(entry.EncryptedLinksNonce) == 0 {
+		return entry, nil
 	}
 
-	dec, err := i.linkKey.OpenWithNonce(encryptedLinks, encryptedLinksNonce)
+	dec, err := OpenLinks(keys, entry)
 	if err != nil {
-		return nil, errmsg.ErrDecrypt.Wrap(err)
+		return nil, err
 	}
 
 	links := &jsonable.EntryV2{}
@@ -348,13 +588,21 @@ func NonceRefForEntry(entry iface.IPFSLogEntry) []byte {
 		next += "-" + c.String()
 	}
 
+	// don't derive the nonce from a clear payload when it is encrypted
+	payload := entry.GetPayload()
+	if encrypted, ok := entry.GetAdditionalData()[iface.KeyEncryptedPayload]; ok {
+		payload = []byte(encrypted)
+	}
+
 	return []byte(fmt.Sprintf("%s,%s,%s,%s,%d,%s,%d",
 		next,
 		entry.GetKey(),
-		entry.GetPayload(),
+		payload,
 		entry.GetClock().GetID(),
 		entry.GetClock().GetTime(),
 		entry.GetLogID(),
 		entry.GetV(),
 	))
 }
+
+var _ iface.IORegistry = (*IOCbor)(nil)
@@ -0,0 +1,480 @@
+package cbor
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"berty.tech/go-ipfs-log/enc"
+	"berty.tech/go-ipfs-log/errmsg"
+	"berty.tech/go-ipfs-log/iface"
+	"berty.tech/go-ipfs-log/io/jsonable"
+)
+
+// KeyRingFromOptions returns ring if defined, or a ring holding key without
+// ID otherwise. It returns nil if neither is defined.
+func KeyRingFromOptions(ring *enc.KeyRing, key enc.SharedKey) *enc.KeyRing {
+	if ring != nil {
+		return ring
+	}
+
+	if key != nil {
+		return enc.NewKeyRingFromKey(key)
+	}
+
+	return nil
+}
+
+// NeedsLinksEncryption checks whether an entry has links which have not been
+// encrypted yet.
+func NeedsLinksEncryption(entry iface.IPFSLogEntry) bool {
+	if len(entry.GetNext()) == 0 && len(entry.GetRefs()) == 0 {
+		return false
+	}
+
+	_, ok := entry.GetAdditionalData()[iface.KeyEncryptedLinks]
+
+	return !ok
+}
+
+// CheckEncryptedLinks rejects entries holding both encrypted and clear
+// links. The encrypted links are signed instead of the clear ones, which the
+// writers leave empty, so clear links next to them could have been added by
+// anyone relaying the entry.
+func CheckEncryptedLinks(entry *jsonable.EntryV2) error {
+	if entry.EncryptedLinks == "" {
+		return nil
+	}
+
+	if len(entry.Next) > 0 || len(entry.Refs) > 0 {
+		return errmsg.ErrEntryLinksNotSigned
+	}
+
+	return nil
+}
+
+// SealLinks encrypts the serialized links of an entry with the current key of
+// the ring, the nonce being derived from the entry. The entry is marked so
+// that its encrypted links are signed instead of the clear ones.
+func SealLinks(keys *enc.KeyRing, entry iface.IPFSLogEntry, links []byte) error {
+	keyID, key := keys.Current()
+	if key == nil {
+		return errmsg.ErrEncrypt.Wrap(enc.ErrInvalidKey)
+	}
+
+	nonce, err := key.DeriveNonce(NonceRefForEntry(entry))
+	if err != nil {
+		return errmsg.ErrEncrypt.Wrap(err)
+	}
+
+	encryptedLinks, err := key.SealWithNonce(links, nonce)
+	if err != nil {
+		return errmsg.ErrEncrypt.Wrap(enc.ErrCannotEncrypt)
+	}
+
+	entry.SetAdditionalDataValue(iface.KeyEncryptedLinks, base64.StdEncoding.EncodeToString(encryptedLinks))
+	entry.SetAdditionalDataValue(iface.KeyEncryptedLinksNonce, base64.StdEncoding.EncodeToString(nonce))
+	entry.SetAdditionalDataValue(iface.KeyEncryptedLinksVersion, iface.EncryptedLinksSigned)
+
+	if keyID != "" {
+		entry.SetAdditionalDataValue(iface.KeyEncryptedLinksKeyID, keyID)
+	}
+
+	if alg := enc.AlgorithmOf(key); alg != enc.AlgorithmSecretbox {
+		entry.SetAdditionalDataValue(iface.KeyEncryptedLinksAlgorithm, alg)
+	}
+
+	return nil
+}
+
+// OpenLinks decrypts the serialized links of an entry, using the key
+// recorded in the entry or any key of the ring for entries without key ID.
+func OpenLinks(keys *enc.KeyRing, entry *jsonable.EntryV2) ([]byte, error) {
+	encryptedLinks, err := base64.StdEncoding.DecodeString(entry.EncryptedLinks)
+	if err != nil {
+		return nil, errmsg.ErrEntryDeserializationFailed.Wrap(err)
+	}
+
+	encryptedLinksNonce, err := base64.StdEncoding.DecodeString(entry.EncryptedLinksNonce)
+	if err != nil {
+		return nil, errmsg.ErrEntryDeserializationFailed.Wrap(err)
+	}
+
+	dec, err := keys.Open(entry.EncryptedLinksKeyID, func(key enc.SharedKey) ([]byte, error) {
+		if !matchesAlgorithm(key, entry.EncryptedLinksAlg) {
+			return nil, enc.ErrUnsupportedAlgorithm
+		}
+
+		return key.OpenWithNonce(encryptedLinks, encryptedLinksNonce)
+	})
+	if err != nil {
+		return nil, errmsg.ErrDecrypt.Wrap(err)
+	}
+
+	return dec, nil
+}
+
+// matchesAlgorithm checks whether a key uses the algorithm recorded in an
+// entry, entries recording no algorithm were encrypted using secretbox.
+func matchesAlgorithm(key enc.SharedKey, alg string) bool {
+	if alg == "" {
+		alg = enc.AlgorithmSecretbox
+	}
+
+	return enc.AlgorithmOf(key) == alg
+}
+
+// EncryptPayload seals the payload of an entry with the current key of the
+// ring, padded if padding is defined. It is a no-op if keys is nil or if the
+// payload has already been encrypted.
+func EncryptPayload(keys *enc.KeyRing, padding enc.Padding, entry iface.IPFSLogEntry) error {
+	if keys == nil {
+		return nil
+	}
+
+	if _, ok := entry.GetAdditionalData()[iface.KeyEncryptedPayload]; ok {
+		return nil
+	}
+
+	keyID, key := keys.Current()
+	if key == nil {
+		return errmsg.ErrEncrypt.Wrap(enc.ErrInvalidKey)
+	}
+
+	payload := entry.GetPayload()
+	if padding != nil {
+		payload = enc.Pad(payload, padding)
+	}
+
+	sealed, err := key.Seal(payload)
+	if err != nil {
+		return errmsg.ErrEncrypt.Wrap(err)
+	}
+
+	entry.SetAdditionalDataValue(iface.KeyEncryptedPayload, base64.StdEncoding.EncodeToString(sealed))
+
+	if padding != nil {
+		entry.SetAdditionalDataValue(iface.KeyEncryptedPayloadPadding, enc.PaddingISO7816)
+	}
+
+	if keyID != "" {
+		entry.SetAdditionalDataValue(iface.KeyEncryptedPayloadKeyID, keyID)
+	}
+
+	if alg := enc.AlgorithmOf(key); alg != enc.AlgorithmSecretbox {
+		entry.SetAdditionalDataValue(iface.KeyEncryptedPayloadAlgorithm, alg)
+	}
+
+	return nil
+}
+
+// DecryptPayload opens the payload of an entry, the payload is left empty if
+// keys is nil.
+func DecryptPayload(keys *enc.KeyRing, entry *jsonable.EntryV2) error {
+	if keys == nil || len(entry.EncryptedPayload) == 0 {
+		return nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(entry.EncryptedPayload)
+	if err != nil {
+		return errmsg.ErrEntryDeserializationFailed.Wrap(err)
+	}
+
+	payload, err := keys.Open(entry.EncryptedPayloadKeyID, func(key enc.SharedKey) ([]byte, error) {
+		if !matchesAlgorithm(key, entry.EncryptedPayloadAlg) {
+			return nil, enc.ErrUnsupportedAlgorithm
+		}
+
+		return key.Open(sealed)
+	})
+	if err != nil {
+		return errmsg.ErrDecrypt.Wrap(err)
+	}
+
+	switch entry.EncryptedPayloadPad {
+	case "":
+	case enc.PaddingISO7816:
+		if payload, err = enc.Unpad(payload); err != nil {
+			return errmsg.ErrDecrypt.Wrap(err)
+		}
+	default:
+		return errmsg.ErrDecrypt.Wrap(enc.ErrInvalidPadding)
+	}
+
+	entry.Payload = string(payload)
+
+	return nil
+}
+
+// SealForRecipients generates a random XChaCha20-Poly1305 content key for an
+// entry and records it sealed for each recipient, it returns a ring holding
+// the content key to encrypt the entry with. It returns nil if the entry has
+// already been sealed.
+func SealForRecipients(recipients []*enc.BoxPublicKey, entry iface.IPFSLogEntry) (*enc.KeyRing, error) {
+	if _, ok := entry.GetAdditionalData()[iface.KeyEncryptedRecipients]; ok {
+		return nil, nil
+	}
+
+	key, sealed, err := newRecipientsKey(recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	entry.SetAdditionalDataValue(iface.KeyEncryptedRecipients, sealed)
+
+	return enc.NewKeyRingFromKey(key), nil
+}
+
+// OpenAsRecipient returns a ring holding the content key of an entry sealed
+// for recipients, or nil if the entry isn't sealed for recipients or if
+// reader is nil. enc.ErrNotRecipient is returned if reader isn't one of the
+// recipients.
+func OpenAsRecipient(reader *enc.BoxKeyPair, entry *jsonable.EntryV2) (*enc.KeyRing, error) {
+	if reader == nil || entry.EncryptedRecipients == "" {
+		return nil, nil
+	}
+
+	// the content key is used for both the payload and the links
+	key, err := openRecipientsKey(reader, entry.EncryptedRecipients, entry.EncryptedPayloadAlg)
+	if err != nil {
+		return nil, err
+	}
+
+	return enc.NewKeyRingFromKey(key), nil
+}
+
+// newRecipientsKey generates a random content key, returned along with its
+// sealed boxes for the recipients, as base64 values separated by commas.
+func newRecipientsKey(recipients []*enc.BoxPublicKey) (enc.SharedKey, string, error) {
+	contentKey := make([]byte, enc.SecretBoxKeySize)
+	if _, err := rand.Read(contentKey); err != nil {
+		return nil, "", errmsg.ErrEncrypt.Wrap(err)
+	}
+
+	key, err := enc.NewXChaCha20Poly1305(contentKey)
+	if err != nil {
+		return nil, "", errmsg.ErrEncrypt.Wrap(err)
+	}
+
+	sealed, err := enc.SealForRecipients(contentKey, recipients)
+	if err != nil {
+		return nil, "", errmsg.ErrEncrypt.Wrap(err)
+	}
+
+	values := make([]string, len(sealed))
+	for i, s := range sealed {
+		values[i] = base64.StdEncoding.EncodeToString(s)
+	}
+
+	return key, strings.Join(values, ","), nil
+}
+
+// openRecipientsKey opens a content key sealed by newRecipientsKey.
+func openRecipientsKey(reader *enc.BoxKeyPair, recipients string, alg string) (enc.SharedKey, error) {
+	values := strings.Split(recipients, ",")
+	sealed := make([][]byte, len(values))
+
+	for i, v := range values {
+		s, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, errmsg.ErrEntryDeserializationFailed.Wrap(err)
+		}
+
+		sealed[i] = s
+	}
+
+	contentKey, err := enc.OpenAsRecipient(sealed, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := enc.NewSharedKey(alg, contentKey)
+	if err != nil {
+		return nil, errmsg.ErrDecrypt.Wrap(err)
+	}
+
+	return key, nil
+}
+
+// KeyRingsForWrite returns the rings used to encrypt the links and the
+// payload of an entry, a content key is sealed for the recipients if any. The
+// links of entries sealed for recipients are left in clear unless
+// recipientsLinks is set.
+func KeyRingsForWrite(recipients []*enc.BoxPublicKey, recipientsLinks bool, linkKeys, payloadKeys *enc.KeyRing, entry iface.IPFSLogEntry) (*enc.KeyRing, *enc.KeyRing, error) {
+	if len(recipients) == 0 {
+		return linkKeys, payloadKeys, nil
+	}
+
+	contentKeys, err := SealForRecipients(recipients, entry)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !recipientsLinks {
+		return nil, contentKeys, nil
+	}
+
+	return contentKeys, contentKeys, nil
+}
+
+// KeyRingsForEntry returns the rings used to decrypt the links and the
+// payload of an entry. The shared rings are never used for entries sealed for
+// recipients, nil rings are returned if reader isn't one of them.
+func KeyRingsForEntry(reader *enc.BoxKeyPair, linkKeys, payloadKeys *enc.KeyRing, entry *jsonable.EntryV2) (*enc.KeyRing, *enc.KeyRing, error) {
+	if entry.EncryptedRecipients == "" {
+		return linkKeys, payloadKeys, nil
+	}
+
+	contentKeys, err := OpenAsRecipient(reader, entry)
+	if errors.Is(err, enc.ErrNotRecipient) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	return contentKeys, contentKeys, nil
+}
+
+// DecryptEntryPayload opens the payload of an entry which has been decoded
+// without the key, as the encrypted payload is kept in its additional data.
+func DecryptEntryPayload(keys *enc.KeyRing, entry iface.IPFSLogEntry) error {
+	add := entry.GetAdditionalData()
+
+	obj := &jsonable.EntryV2{
+		EncryptedPayload:      add[iface.KeyEncryptedPayload],
+		EncryptedPayloadKeyID: add[iface.KeyEncryptedPayloadKeyID],
+		EncryptedPayloadAlg:   add[iface.KeyEncryptedPayloadAlgorithm],
+		EncryptedPayloadPad:   add[iface.KeyEncryptedPayloadPadding],
+	}
+
+	if keys == nil || obj.EncryptedPayload == "" {
+		return nil
+	}
+
+	if err := DecryptPayload(keys, obj); err != nil {
+		return err
+	}
+
+	entry.SetPayload([]byte(obj.Payload))
+
+	return nil
+}
+
+// SealEnvelope encrypts a serialized entry with the current key of the ring.
+func SealEnvelope(keys *enc.KeyRing, entry []byte) (*jsonable.EncryptedEntry, error) {
+	keyID, key := keys.Current()
+	if key == nil {
+		return nil, errmsg.ErrEncrypt.Wrap(enc.ErrInvalidKey)
+	}
+
+	sealed, err := key.Seal(entry)
+	if err != nil {
+		return nil, errmsg.ErrEncrypt.Wrap(err)
+	}
+
+	out := &jsonable.EncryptedEntry{
+		EncryptedEntry: base64.StdEncoding.EncodeToString(sealed),
+		KeyID:          keyID,
+	}
+
+	if alg := enc.AlgorithmOf(key); alg != enc.AlgorithmSecretbox {
+		out.Alg = alg
+	}
+
+	return out, nil
+}
+
+// OpenEnvelope decrypts an entry sealed by SealEnvelope.
+func OpenEnvelope(keys *enc.KeyRing, envelope *jsonable.EncryptedEntry) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(envelope.EncryptedEntry)
+	if err != nil {
+		return nil, errmsg.ErrEntryDeserializationFailed.Wrap(err)
+	}
+
+	dec, err := keys.Open(envelope.KeyID, func(key enc.SharedKey) ([]byte, error) {
+		if !matchesAlgorithm(key, envelope.Alg) {
+			return nil, enc.ErrUnsupportedAlgorithm
+		}
+
+		return key.Open(sealed)
+	})
+	if err != nil {
+		return nil, errmsg.ErrDecrypt.Wrap(err)
+	}
+
+	return dec, nil
+}
+
+// SealJSONLog encrypts serialized log heads, using a content key sealed for
+// the recipients if any, or the current key of the ring otherwise.
+func SealJSONLog(keys *enc.KeyRing, recipients []*enc.BoxPublicKey, jsonLog []byte) (*jsonable.EncryptedJSONLog, error) {
+	out := &jsonable.EncryptedJSONLog{}
+
+	var key enc.SharedKey
+
+	if len(recipients) > 0 {
+		var err error
+
+		if key, out.Recipients, err = newRecipientsKey(recipients); err != nil {
+			return nil, err
+		}
+	} else if keys != nil {
+		out.KeyID, key = keys.Current()
+	}
+
+	if key == nil {
+		return nil, errmsg.ErrEncrypt.Wrap(enc.ErrInvalidKey)
+	}
+
+	sealed, err := key.Seal(jsonLog)
+	if err != nil {
+		return nil, errmsg.ErrEncrypt.Wrap(err)
+	}
+
+	out.EncryptedHeads = base64.StdEncoding.EncodeToString(sealed)
+
+	if alg := enc.AlgorithmOf(key); alg != enc.AlgorithmSecretbox {
+		out.Alg = alg
+	}
+
+	return out, nil
+}
+
+// OpenJSONLog decrypts log heads sealed by SealJSONLog.
+func OpenJSONLog(keys *enc.KeyRing, reader *enc.BoxKeyPair, jsonLog *jsonable.EncryptedJSONLog) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(jsonLog.EncryptedHeads)
+	if err != nil {
+		return nil, errmsg.ErrEntryDeserializationFailed.Wrap(err)
+	}
+
+	if jsonLog.Recipients != "" {
+		if reader == nil {
+			return nil, errmsg.ErrDecrypt.Wrap(enc.ErrNotRecipient)
+		}
+
+		key, err := openRecipientsKey(reader, jsonLog.Recipients, jsonLog.Alg)
+		if err != nil {
+			return nil, errmsg.ErrDecrypt.Wrap(err)
+		}
+
+		keys = enc.NewKeyRingFromKey(key)
+	}
+
+	if keys == nil {
+		return nil, errmsg.ErrDecrypt.Wrap(enc.ErrInvalidKey)
+	}
+
+	dec, err := keys.Open(jsonLog.KeyID, func(key enc.SharedKey) ([]byte, error) {
+		if !matchesAlgorithm(key, jsonLog.Alg) {
+			return nil, enc.ErrUnsupportedAlgorithm
+		}
+
+		return key.Open(sealed)
+	})
+	if err != nil {
+		return nil, errmsg.ErrDecrypt.Wrap(err)
+	}
+
+	return dec, nil
+}
@@ -8,12 +8,11 @@ import (
 	"berty.tech/go-ipfs-log/io/cbor"
 	"github.com/ipfs/go-cid"
 	format "github.com/ipfs/go-ipld-format"
-	coreiface "github.com/ipfs/kubo/core/coreiface"
 )
 
 type CBOROptions = cbor.Options
 
-func ReadCBOR(ctx context.Context, ipfs coreiface.CoreAPI, c cid.Cid) (format.Node, error) {
+func ReadCBOR(ctx context.Context, ipfs iface.Storage, c cid.Cid) (format.Node, error) {
 	io, err := cbor.IO(&entry.Entry{}, &entry.LamportClock{})
 	if err != nil {
 		return nil, err
@@ -22,7 +21,7 @@ func ReadCBOR(ctx context.Context, ipfs coreiface.CoreAPI, c cid.Cid) (format.No
 	return io.Read(ctx, ipfs, c)
 }
 
-func WriteCBOR(ctx context.Context, ipfs coreiface.CoreAPI, obj interface{}, opts *iface.WriteOpts) (cid.Cid, error) {
+func WriteCBOR(ctx context.Context, ipfs iface.Storage, obj interface{}, opts *iface.WriteOpts) (cid.Cid, error) {
 	io, err := cbor.IO(&entry.Entry{}, &entry.LamportClock{})
 	if err != nil {
 		return cid.Undef, err
@@ -0,0 +1,417 @@
+// Package ipldprime implements an IO for IPFS Log entries based on
+// go-ipld-prime, supporting both the dag-cbor and the dag-json codecs.
+package ipldprime // import "berty.tech/go-ipfs-log/io/ipldprime"
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	ipldlegacy "github.com/ipfs/go-ipld-legacy"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/codec/dagjson"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/schema"
+	"github.com/multiformats/go-multicodec"
+	"github.com/multiformats/go-multihash"
+
+	"berty.tech/go-ipfs-log/enc"
+	"berty.tech/go-ipfs-log/errmsg"
+	"berty.tech/go-ipfs-log/identityprovider"
+	"berty.tech/go-ipfs-log/iface"
+	"berty.tech/go-ipfs-log/io/cbor"
+	"berty.tech/go-ipfs-log/io/jsonable"
+)
+
+// Codec is the multicodec used to serialize blocks.
+type Codec = multicodec.Code
+
+const (
+	DagCBOR Codec = multicodec.DagCbor
+	DagJSON Codec = multicodec.DagJson
+)
+
+type IOPrime struct {
+	refClock iface.IPFSLogLamportClock
+	refEntry iface.IPFSLogEntry
+
+	codec        Codec
+	linkKeys     *enc.KeyRing
+	payloadKeys  *enc.KeyRing
+	recipients   []*enc.BoxPublicKey
+	recipientKey *enc.BoxKeyPair
+
+	recipientsLinks bool
+	payloadPadding  enc.Padding
+	registry        *identityprovider.Registry
+}
+
+type Options struct {
+	// Codec used when writing blocks, defaults to DagCBOR. Blocks are always
+	// read using the codec of their CID.
+	Codec          Codec
+	LinkKey        enc.SharedKey
+	LinkKeyRing    *enc.KeyRing
+	PayloadKey     enc.SharedKey
+	PayloadKeyRing *enc.KeyRing
+	PayloadPadding enc.Padding
+
+	// Recipients, RecipientKey and RecipientsClearLinks behave as in the
+	// cbor IO.
+	Recipients           []*enc.BoxPublicKey
+	RecipientKey         *enc.BoxKeyPair
+	RecipientsClearLinks bool
+
+	// Registry behaves as in the cbor IO.
+	Registry *identityprovider.Registry
+}
+
+// IO creates a new IO using the dag-cbor codec, its output is compatible
+// with the one of the cbor IO.
+func IO(refEntry iface.IPFSLogEntry, refClock iface.IPFSLogLamportClock) (*IOPrime, error) {
+	return &IOPrime{
+		refClock: refClock,
+		refEntry: refEntry,
+		codec:    DagCBOR,
+	}, nil
+}
+
+func (i *IOPrime) ApplyOptions(options *Options) *IOPrime {
+	out := &IOPrime{
+		refClock:        i.refClock,
+		refEntry:        i.refEntry,
+		codec:           options.Codec,
+		linkKeys:        cbor.KeyRingFromOptions(options.LinkKeyRing, options.LinkKey),
+		payloadKeys:     cbor.KeyRingFromOptions(options.PayloadKeyRing, options.PayloadKey),
+		recipients:      options.Recipients,
+		recipientKey:    options.RecipientKey,
+		recipientsLinks: !options.RecipientsClearLinks,
+		payloadPadding:  options.PayloadPadding,
+		registry:        options.Registry,
+	}
+
+	if out.codec == 0 {
+		out.codec = DagCBOR
+	}
+
+	return out
+}
+
+// Codec returns the codec used to write blocks.
+func (i *IOPrime) Codec() Codec {
+	return i.codec
+}
+
+func encoderFor(codec Codec) (ipld.Encoder, error) {
+	switch codec {
+	case DagCBOR:
+		return dagcbor.Encode, nil
+	case DagJSON:
+		return dagjson.Encode, nil
+	}
+
+	return nil, errmsg.ErrCodecNotSupported.Wrap(fmt.Errorf("codec %s", codec))
+}
+
+func decoderFor(codec Codec) (ipld.Decoder, error) {
+	switch codec {
+	case DagCBOR:
+		return dagcbor.Decode, nil
+	case DagJSON:
+		return dagjson.Decode, nil
+	}
+
+	return nil, errmsg.ErrCodecNotSupported.Wrap(fmt.Errorf("codec %s", codec))
+}
+
+// encode serializes a value of the given schema type in a block.
+func (i *IOPrime) encode(bind interface{}, typ schema.Type) (format.Node, error) {
+	encoder, err := encoderFor(i.codec)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ipld.Marshal(encoder, bind, typ)
+	if err != nil {
+		return nil, errmsg.ErrIPLDOperationFailed.Wrap(err)
+	}
+
+	return newNode(data, i.codec)
+}
+
+// decode deserializes a block into a value of the given schema type, the
+// codec is picked from the block's CID.
+func decode(node format.Node, bind interface{}, typ schema.Type) error {
+	decoder, err := decoderFor(Codec(node.Cid().Prefix().Codec))
+	if err != nil {
+		return err
+	}
+
+	if _, err := ipld.Unmarshal(node.RawData(), decoder, bind, typ); err != nil {
+		return errmsg.ErrIPLDOperationFailed.Wrap(err)
+	}
+
+	return nil
+}
+
+func newNode(data []byte, codec Codec) (format.Node, error) {
+	c, err := cid.V1Builder{Codec: uint64(codec), MhType: multihash.SHA2_256}.Sum(data)
+	if err != nil {
+		return nil, errmsg.ErrIPLDOperationFailed.Wrap(err)
+	}
+
+	block, err := blocks.NewBlockWithCid(data, c)
+	if err != nil {
+		return nil, errmsg.ErrIPLDOperationFailed.Wrap(err)
+	}
+
+	return newNodeFromBlock(block)
+}
+
+func newNodeFromBlock(block blocks.Block) (format.Node, error) {
+	decoder, err := decoderFor(Codec(block.Cid().Prefix().Codec))
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := ipld.Decode(block.RawData(), decoder)
+	if err != nil {
+		return nil, errmsg.ErrIPLDOperationFailed.Wrap(err)
+	}
+
+	return &ipldlegacy.LegacyNode{Block: block, Node: n}, nil
+}
+
+// Write writes a representation of a given object in IPFS' DAG.
+func (i *IOPrime) Write(ctx context.Context, storage iface.Storage, obj interface{}, opts *iface.WriteOpts) (cid.Cid, error) {
+	if opts == nil {
+		opts = &iface.WriteOpts{}
+	}
+
+	var (
+		node format.Node
+		err  error
+	)
+
+	switch o := obj.(type) {
+	case iface.IPFSLogEntry:
+		switch e := jsonable.ToJsonableEntry(o).(type) {
+		case *jsonable.EntryV1:
+			node, err = i.encode(fromEntryV1(e), entryType)
+		case *jsonable.EntryV2:
+			node, err = i.encode(fromEntryV2(e), entryType)
+		default:
+			return cid.Undef, errmsg.ErrEntryVersionNotSupported.Wrap(fmt.Errorf("version %d", o.GetV()))
+		}
+
+	case *iface.JSONLog:
+		node, err = i.encode(fromJSONLog(o), jsonLogType)
+
+	default:
+		return cid.Undef, errmsg.ErrIPLDOperationFailed.Wrap(fmt.Errorf("unsupported type %T", obj))
+	}
+
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	if err := storage.Put(ctx, node); err != nil {
+		return cid.Undef, errmsg.ErrIPFSOperationFailed.Wrap(err)
+	}
+
+	if opts.Pin {
+		if err := storage.Pin(ctx, node.Cid()); err != nil {
+			return cid.Undef, errmsg.ErrIPFSOperationFailed.Wrap(err)
+		}
+	}
+
+	return node.Cid(), nil
+}
+
+// Read reads a representation of a given object from IPFS' DAG.
+func (i *IOPrime) Read(ctx context.Context, storage iface.Storage, contentIdentifier cid.Cid) (format.Node, error) {
+	block, err := storage.Get(ctx, contentIdentifier)
+	if err != nil {
+		return nil, err
+	}
+
+	if node, ok := block.(format.Node); ok {
+		return node, nil
+	}
+
+	return newNodeFromBlock(block)
+}
+
+func (i *IOPrime) DecodeRawEntry(node format.Node, hash cid.Cid, p identityprovider.Interface) (iface.IPFSLogEntry, error) {
+	n := &entryNode{}
+	if err := decode(node, n, entryType); err != nil {
+		return nil, errmsg.ErrEntryDeserializationFailed.Wrap(err)
+	}
+
+	obj := n.toJsonable()
+
+	if err := cbor.CheckEncryptedLinks(obj); err != nil {
+		return nil, errmsg.ErrEntryDeserializationFailed.Wrap(err)
+	}
+
+	linkKeys, payloadKeys, err := cbor.KeyRingsForEntry(i.recipientKey, i.linkKeys, i.payloadKeys, obj)
+	if err != nil {
+		return nil, errmsg.ErrDecrypt.Wrap(err)
+	}
+
+	obj, err = i.decryptLinks(linkKeys, obj)
+	if err != nil {
+		return nil, errmsg.ErrDecrypt.Wrap(err)
+	}
+
+	if err := cbor.DecryptPayload(payloadKeys, obj); err != nil {
+		return nil, errmsg.ErrDecrypt.Wrap(err)
+	}
+
+	e := i.refEntry.New()
+	if err := obj.ToPlain(e, p, i.refClock.New); err != nil {
+		return nil, errmsg.ErrEntryDeserializationFailed.Wrap(err)
+	}
+
+	e.SetHash(hash)
+
+	if err := cbor.SetIdentityProvider(i.registry, e, p); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// IdentityRegistry returns the registry of the identity providers accepted
+// in the entries, nil if not restricted.
+func (i *IOPrime) IdentityRegistry() *identityprovider.Registry {
+	return i.registry
+}
+
+func (i *IOPrime) DecodeRawJSONLog(node format.Node) (*iface.JSONLog, error) {
+	n := &jsonLogNode{}
+	if err := decode(node, n, jsonLogType); err != nil {
+		return nil, err
+	}
+
+	return &iface.JSONLog{
+		ID:    n.LogID,
+		Heads: n.Heads,
+	}, nil
+}
+
+// encodeLinks serializes the links of an entry, the output is identical to
+// the one used by the cbor IO so encrypted links can be read by both.
+func encodeLinks(next, refs []cid.Cid) ([]byte, error) {
+	node, err := qp.BuildMap(basicnode.Prototype.Any, 10, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "v", qp.Int(0))
+		qp.MapEntry(ma, "id", qp.String(""))
+		qp.MapEntry(ma, "key", qp.String(""))
+		qp.MapEntry(ma, "sig", qp.String(""))
+		qp.MapEntry(ma, "hash", qp.Null())
+		qp.MapEntry(ma, "next", linkList(next))
+		qp.MapEntry(ma, "refs", linkList(refs))
+		qp.MapEntry(ma, "clock", qp.Null())
+		qp.MapEntry(ma, "payload", qp.String(""))
+		qp.MapEntry(ma, "identity", qp.Null())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	if err := dagcbor.Encode(node, buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func linkList(cids []cid.Cid) qp.Assemble {
+	return qp.List(int64(len(cids)), func(la datamodel.ListAssembler) {
+		for _, c := range cids {
+			qp.ListEntry(la, qp.Link(cidlinkOf(c)))
+		}
+	})
+}
+
+// decodeLinks reads the links serialized by encodeLinks.
+func decodeLinks(data []byte) (next []cid.Cid, refs []cid.Cid, err error) {
+	node, err := ipld.Decode(data, dagcbor.Decode)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if next, err = lookupLinks(node, "next"); err != nil {
+		return nil, nil, err
+	}
+
+	if refs, err = lookupLinks(node, "refs"); err != nil {
+		return nil, nil, err
+	}
+
+	return next, refs, nil
+}
+
+func (i *IOPrime) PreSign(entry iface.IPFSLogEntry) (iface.IPFSLogEntry, error) {
+	if i.linkKeys == nil && i.payloadKeys == nil && len(i.recipients) == 0 {
+		return entry, nil
+	}
+
+	entry = entry.Copy()
+
+	linkKeys, payloadKeys, err := cbor.KeyRingsForWrite(i.recipients, i.recipientsLinks, i.linkKeys, i.payloadKeys, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cbor.EncryptPayload(payloadKeys, i.payloadPadding, entry); err != nil {
+		return nil, err
+	}
+
+	if linkKeys == nil || !cbor.NeedsLinksEncryption(entry) {
+		return entry, nil
+	}
+
+	payload, err := encodeLinks(entry.GetNext(), entry.GetRefs())
+	if err != nil {
+		return nil, errmsg.ErrEncrypt.Wrap(fmt.Errorf("unable to serialize links: %w", err))
+	}
+
+	if err := cbor.SealLinks(linkKeys, entry, payload); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+func (i *IOPrime) DecryptLinks(entry *jsonable.EntryV2) (*jsonable.EntryV2, error) {
+	return i.decryptLinks(i.linkKeys, entry)
+}
+
+func (i *IOPrime) decryptLinks(keys *enc.KeyRing, entry *jsonable.EntryV2) (*jsonable.EntryV2, error) {
+	if keys == nil || len(entry.EncryptedLinks) == 0 || len(entry.EncryptedLinksNonce) == 0 {
+		return entry, nil
+	}
+
+	dec, err := cbor.OpenLinks(keys, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	entry.Next, entry.Refs, err = decodeLinks(dec)
+	if err != nil {
+		return nil, errmsg.ErrDecrypt.Wrap(fmt.Errorf("unable to unmarshal decrypted message: %w", err))
+	}
+
+	return entry, nil
+}
+
+var _ iface.IOPreSign = (*IOPrime)(nil)
+var _ iface.IORegistry = (*IOPrime)(nil)
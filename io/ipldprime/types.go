@@ -0,0 +1,400 @@
+package ipldprime
+
+import (
+	_ "embed"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/schema"
+
+	"berty.tech/go-ipfs-log/iface"
+	"berty.tech/go-ipfs-log/io/jsonable"
+)
+
+//go:embed schema.ipldsch
+var schemaBytes []byte
+
+var (
+	entryType   schema.Type
+	jsonLogType schema.Type
+)
+
+func init() {
+	ts, err := ipld.LoadSchemaBytes(schemaBytes)
+	if err != nil {
+		panic(err)
+	}
+
+	entryType = ts.TypeByName("Entry")
+	jsonLogType = ts.TypeByName("JSONLog")
+}
+
+// entryNode is the Go binding of the Entry schema type.
+type entryNode struct {
+	V                   int64
+	LogID               string
+	Key                 string
+	Sig                 string
+	Hash                *string
+	Next                []cid.Cid
+	Refs                *[]cid.Cid
+	Clock               clockNode
+	Payload             string
+	Identity            *identityNode
+	EncryptedLinks      *string
+	EncryptedLinksNonce *string
+	EncryptedPayload    *string
+
+	EncryptedLinksKeyID   *string
+	EncryptedPayloadKeyID *string
+	EncryptedRecipients   *string
+	EncryptedLinksAlg     *string
+	EncryptedPayloadAlg   *string
+	EncryptedPayloadPad   *string
+	EncryptedLinksV       *string
+}
+
+type clockNode struct {
+	ClockID string
+	Time    int64
+}
+
+type identityNode struct {
+	IdentityID  string
+	PublicKey   string
+	Signatures  *identitySignatureNode
+	Type        string
+	Rotations   *[]keyRotationNode
+	Revocations *[]keyRevocationNode
+
+	Certificates *[]deviceCertificateNode
+}
+
+type keyRotationNode struct {
+	PublicKey    string
+	NewPublicKey string
+	Start        int64
+	Signature    string
+}
+
+type keyRevocationNode struct {
+	PublicKey string
+	After     int64
+	SignedBy  string
+	Signature string
+}
+
+type deviceCertificateNode struct {
+	PublicKey    string
+	IssuedBy     string
+	Expiry       int64
+	Capabilities *[]string
+	Signature    string
+}
+
+type identitySignatureNode struct {
+	SignedID  string
+	PublicKey string
+}
+
+type jsonLogNode struct {
+	LogID string
+	Heads []cid.Cid
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+
+	return &s
+}
+
+func nonNilCids(cids []cid.Cid) []cid.Cid {
+	if cids == nil {
+		return []cid.Cid{}
+	}
+
+	return cids
+}
+
+func toIdentityNode(id *jsonable.Identity) *identityNode {
+	if id == nil {
+		return nil
+	}
+
+	out := &identityNode{
+		IdentityID: id.ID,
+		PublicKey:  id.PublicKey,
+		Type:       id.Type,
+	}
+
+	if id.Signatures != nil {
+		out.Signatures = &identitySignatureNode{
+			SignedID:  id.Signatures.ID,
+			PublicKey: id.Signatures.PublicKey,
+		}
+	}
+
+	if len(id.Rotations) > 0 {
+		rotations := make([]keyRotationNode, len(id.Rotations))
+		for i, r := range id.Rotations {
+			rotations[i] = keyRotationNode{
+				PublicKey:    r.PublicKey,
+				NewPublicKey: r.NewPublicKey,
+				Start:        int64(r.Start),
+				Signature:    r.Signature,
+			}
+		}
+
+		out.Rotations = &rotations
+	}
+
+	if len(id.Revocations) > 0 {
+		revocations := make([]keyRevocationNode, len(id.Revocations))
+		for i, r := range id.Revocations {
+			revocations[i] = keyRevocationNode{
+				PublicKey: r.PublicKey,
+				After:     int64(r.After),
+				SignedBy:  r.SignedBy,
+				Signature: r.Signature,
+			}
+		}
+
+		out.Revocations = &revocations
+	}
+
+	if len(id.Certificates) > 0 {
+		certificates := make([]deviceCertificateNode, len(id.Certificates))
+		for i, c := range id.Certificates {
+			certificates[i] = deviceCertificateNode{
+				PublicKey: c.PublicKey,
+				IssuedBy:  c.IssuedBy,
+				Expiry:    int64(c.Expiry),
+				Signature: c.Signature,
+			}
+
+			if len(c.Capabilities) > 0 {
+				capabilities := append([]string(nil), c.Capabilities...)
+				certificates[i].Capabilities = &capabilities
+			}
+		}
+
+		out.Certificates = &certificates
+	}
+
+	return out
+}
+
+func (n *identityNode) toJsonable() *jsonable.Identity {
+	if n == nil {
+		return nil
+	}
+
+	out := &jsonable.Identity{
+		ID:        n.IdentityID,
+		PublicKey: n.PublicKey,
+		Type:      n.Type,
+	}
+
+	if n.Signatures != nil {
+		out.Signatures = &jsonable.IdentitySignature{
+			ID:        n.Signatures.SignedID,
+			PublicKey: n.Signatures.PublicKey,
+		}
+	}
+
+	if n.Rotations != nil {
+		for _, r := range *n.Rotations {
+			out.Rotations = append(out.Rotations, &jsonable.KeyRotation{
+				PublicKey:    r.PublicKey,
+				NewPublicKey: r.NewPublicKey,
+				Start:        int(r.Start),
+				Signature:    r.Signature,
+			})
+		}
+	}
+
+	if n.Revocations != nil {
+		for _, r := range *n.Revocations {
+			out.Revocations = append(out.Revocations, &jsonable.KeyRevocation{
+				PublicKey: r.PublicKey,
+				After:     int(r.After),
+				SignedBy:  r.SignedBy,
+				Signature: r.Signature,
+			})
+		}
+	}
+
+	if n.Certificates != nil {
+		for _, c := range *n.Certificates {
+			certificate := &jsonable.DeviceCertificate{
+				PublicKey: c.PublicKey,
+				IssuedBy:  c.IssuedBy,
+				Expiry:    int(c.Expiry),
+				Signature: c.Signature,
+			}
+
+			if c.Capabilities != nil {
+				certificate.Capabilities = *c.Capabilities
+			}
+
+			out.Certificates = append(out.Certificates, certificate)
+		}
+	}
+
+	return out
+}
+
+// fromEntryV1 converts a v1 entry, v1 entries have no refs field.
+func fromEntryV1(e *jsonable.EntryV1) *entryNode {
+	return &entryNode{
+		V:       int64(e.V),
+		LogID:   e.LogID,
+		Key:     e.Key,
+		Sig:     e.Sig,
+		Next:    nonNilCids(e.Next),
+		Clock:   clockNode{ClockID: e.Clock.ID, Time: int64(e.Clock.Time)},
+		Payload: e.Payload,
+
+		Identity: toIdentityNode(e.Identity),
+	}
+}
+
+func fromEntryV2(e *jsonable.EntryV2) *entryNode {
+	refs := nonNilCids(e.Refs)
+
+	return &entryNode{
+		V:                   int64(e.V),
+		LogID:               e.LogID,
+		Key:                 e.Key,
+		Sig:                 e.Sig,
+		Next:                nonNilCids(e.Next),
+		Refs:                &refs,
+		Clock:               clockNode{ClockID: e.Clock.ID, Time: int64(e.Clock.Time)},
+		Payload:             e.Payload,
+		Identity:            toIdentityNode(e.Identity),
+		EncryptedLinks:      optionalString(e.EncryptedLinks),
+		EncryptedLinksNonce: optionalString(e.EncryptedLinksNonce),
+		EncryptedPayload:    optionalString(e.EncryptedPayload),
+
+		EncryptedLinksKeyID:   optionalString(e.EncryptedLinksKeyID),
+		EncryptedPayloadKeyID: optionalString(e.EncryptedPayloadKeyID),
+		EncryptedRecipients:   optionalString(e.EncryptedRecipients),
+		EncryptedLinksAlg:     optionalString(e.EncryptedLinksAlg),
+		EncryptedPayloadAlg:   optionalString(e.EncryptedPayloadAlg),
+		EncryptedPayloadPad:   optionalString(e.EncryptedPayloadPad),
+		EncryptedLinksV:       optionalString(e.EncryptedLinksV),
+	}
+}
+
+// toJsonable returns the CBOR serializable version of the entry, which can
+// be converted to a plain entry using ToPlain.
+func (n *entryNode) toJsonable() *jsonable.EntryV2 {
+	out := &jsonable.EntryV2{
+		V:        uint64(n.V),
+		LogID:    n.LogID,
+		Key:      n.Key,
+		Sig:      n.Sig,
+		Next:     n.Next,
+		Clock:    &jsonable.LamportClock{ID: n.Clock.ClockID, Time: int(n.Clock.Time)},
+		Payload:  n.Payload,
+		Identity: n.Identity.toJsonable(),
+	}
+
+	if n.Refs != nil {
+		out.Refs = *n.Refs
+	}
+
+	if n.EncryptedLinks != nil {
+		out.EncryptedLinks = *n.EncryptedLinks
+	}
+
+	if n.EncryptedLinksNonce != nil {
+		out.EncryptedLinksNonce = *n.EncryptedLinksNonce
+	}
+
+	if n.EncryptedPayload != nil {
+		out.EncryptedPayload = *n.EncryptedPayload
+	}
+
+	if n.EncryptedLinksKeyID != nil {
+		out.EncryptedLinksKeyID = *n.EncryptedLinksKeyID
+	}
+
+	if n.EncryptedPayloadKeyID != nil {
+		out.EncryptedPayloadKeyID = *n.EncryptedPayloadKeyID
+	}
+
+	if n.EncryptedRecipients != nil {
+		out.EncryptedRecipients = *n.EncryptedRecipients
+	}
+
+	if n.EncryptedLinksAlg != nil {
+		out.EncryptedLinksAlg = *n.EncryptedLinksAlg
+	}
+
+	if n.EncryptedPayloadAlg != nil {
+		out.EncryptedPayloadAlg = *n.EncryptedPayloadAlg
+	}
+
+	if n.EncryptedPayloadPad != nil {
+		out.EncryptedPayloadPad = *n.EncryptedPayloadPad
+	}
+
+	if n.EncryptedLinksV != nil {
+		out.EncryptedLinksV = *n.EncryptedLinksV
+	}
+
+	return out
+}
+
+func fromJSONLog(l *iface.JSONLog) *jsonLogNode {
+	return &jsonLogNode{
+		LogID: l.ID,
+		Heads: nonNilCids(l.Heads),
+	}
+}
+
+func cidlinkOf(c cid.Cid) datamodel.Link {
+	return cidlink.Link{Cid: c}
+}
+
+// lookupLinks reads a list of links from a map node.
+func lookupLinks(node datamodel.Node, key string) ([]cid.Cid, error) {
+	list, err := node.LookupByString(key)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]cid.Cid, 0, list.Length())
+
+	it := list.ListIterator()
+	if it == nil {
+		return nil, fmt.Errorf("%s is not a list", key)
+	}
+
+	for !it.Done() {
+		_, item, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		link, err := item.AsLink()
+		if err != nil {
+			return nil, err
+		}
+
+		cl, ok := link.(cidlink.Link)
+		if !ok {
+			return nil, fmt.Errorf("unsupported link type %T", link)
+		}
+
+		out = append(out, cl.Cid)
+	}
+
+	return out, nil
+}
@@ -23,8 +23,46 @@ type Entry struct {
 	Payload  string
 	Identity *Identity
 
-	EncryptedLinks      string
-	EncryptedLinksNonce string
+	EncryptedLinks        string
+	EncryptedLinksNonce   string
+	EncryptedLinksKeyID   string
+	EncryptedPayload      string
+	EncryptedPayloadKeyID string
+
+	// EncryptedLinksAlg and EncryptedPayloadAlg are empty for values
+	// encrypted using secretbox.
+	EncryptedLinksAlg   string
+	EncryptedPayloadAlg string
+
+	// EncryptedPayloadPad is the padding of the payload, if any.
+	EncryptedPayloadPad string
+
+	// EncryptedLinksV is iface.EncryptedLinksSigned when the signature
+	// covers the encrypted links, it is empty for older entries.
+	EncryptedLinksV string
+
+	// EncryptedRecipients holds the content key of the entry sealed for each
+	// recipient, as base64 values separated by commas.
+	EncryptedRecipients string
+}
+
+// EncryptedEntry CBOR representable version of an Entry whose envelope is
+// encrypted, only the values needed to decrypt it are in clear.
+type EncryptedEntry struct {
+	EncryptedEntry string
+	KeyID          string
+	Alg            string
+}
+
+// EncryptedJSONLog CBOR representable version of encrypted log heads.
+type EncryptedJSONLog struct {
+	EncryptedHeads string
+	KeyID          string
+	Alg            string
+
+	// Recipients holds the content key sealed for each recipient, as base64
+	// values separated by commas.
+	Recipients string
 }
 
 // EntryV0 CBOR representable version of Entry v0
@@ -132,10 +170,36 @@ type IdentitySignature struct {
 }
 
 type Identity struct {
-	ID         string             `json:"id"`
-	PublicKey  string             `json:"public_key"`
-	Signatures *IdentitySignature `json:"signatures"`
-	Type       string             `json:"type"`
+	ID          string             `json:"id"`
+	PublicKey   string             `json:"public_key"`
+	Signatures  *IdentitySignature `json:"signatures"`
+	Type        string             `json:"type"`
+	Rotations   []*KeyRotation     `json:"rotations,omitempty"`
+	Revocations []*KeyRevocation   `json:"revocations,omitempty"`
+
+	Certificates []*DeviceCertificate `json:"certificates,omitempty"`
+}
+
+type KeyRotation struct {
+	PublicKey    string `json:"public_key"`
+	NewPublicKey string `json:"new_public_key"`
+	Start        int    `json:"start"`
+	Signature    string `json:"signature"`
+}
+
+type KeyRevocation struct {
+	PublicKey string `json:"public_key"`
+	After     int    `json:"after"`
+	SignedBy  string `json:"signed_by"`
+	Signature string `json:"signature"`
+}
+
+type DeviceCertificate struct {
+	PublicKey    string   `json:"public_key"`
+	IssuedBy     string   `json:"issued_by"`
+	Expiry       int      `json:"expiry"`
+	Capabilities []string `json:"capabilities,omitempty"`
+	Signature    string   `json:"signature"`
 }
 
 type LamportClock struct {
@@ -155,12 +219,135 @@ func (c *Identity) ToPlain(provider identityprovider.Interface) (*identityprovid
 		return nil, errmsg.ErrIdentityDeserialization.Wrap(err)
 	}
 
+	rotations := []*identityprovider.KeyRotation(nil)
+	for _, r := range c.Rotations {
+		rotation, err := r.ToPlain()
+		if err != nil {
+			return nil, errmsg.ErrIdentityDeserialization.Wrap(err)
+		}
+
+		rotations = append(rotations, rotation)
+	}
+
+	revocations := []*identityprovider.KeyRevocation(nil)
+	for _, r := range c.Revocations {
+		revocation, err := r.ToPlain()
+		if err != nil {
+			return nil, errmsg.ErrIdentityDeserialization.Wrap(err)
+		}
+
+		revocations = append(revocations, revocation)
+	}
+
+	certificates := []*identityprovider.DeviceCertificate(nil)
+	for _, d := range c.Certificates {
+		certificate, err := d.ToPlain()
+		if err != nil {
+			return nil, errmsg.ErrIdentityDeserialization.Wrap(err)
+		}
+
+		certificates = append(certificates, certificate)
+	}
+
 	return &identityprovider.Identity{
-		Signatures: idSignatures,
-		PublicKey:  publicKey,
-		Type:       c.Type,
-		ID:         c.ID,
-		Provider:   provider,
+		Signatures:   idSignatures,
+		PublicKey:    publicKey,
+		Type:         c.Type,
+		ID:           c.ID,
+		Rotations:    rotations,
+		Revocations:  revocations,
+		Certificates: certificates,
+		Provider:     provider,
+	}, nil
+}
+
+// ToPlain converts a CBOR serializable device certificate to a plain
+// DeviceCertificate.
+func (c *DeviceCertificate) ToPlain() (*identityprovider.DeviceCertificate, error) {
+	if c == nil {
+		return nil, errmsg.ErrKeyDeserialization
+	}
+
+	publicKey, err := hex.DecodeString(c.PublicKey)
+	if err != nil {
+		return nil, errmsg.ErrKeyDeserialization.Wrap(err)
+	}
+
+	issuedBy, err := hex.DecodeString(c.IssuedBy)
+	if err != nil {
+		return nil, errmsg.ErrKeyDeserialization.Wrap(err)
+	}
+
+	signature, err := hex.DecodeString(c.Signature)
+	if err != nil {
+		return nil, errmsg.ErrSigDeserialization.Wrap(err)
+	}
+
+	return &identityprovider.DeviceCertificate{
+		PublicKey:    publicKey,
+		IssuedBy:     issuedBy,
+		Expiry:       c.Expiry,
+		Capabilities: c.Capabilities,
+		Signature:    signature,
+	}, nil
+}
+
+// ToPlain converts a CBOR serializable key rotation to a plain KeyRotation.
+func (c *KeyRotation) ToPlain() (*identityprovider.KeyRotation, error) {
+	if c == nil {
+		return nil, errmsg.ErrKeyDeserialization
+	}
+
+	publicKey, err := hex.DecodeString(c.PublicKey)
+	if err != nil {
+		return nil, errmsg.ErrKeyDeserialization.Wrap(err)
+	}
+
+	newPublicKey, err := hex.DecodeString(c.NewPublicKey)
+	if err != nil {
+		return nil, errmsg.ErrKeyDeserialization.Wrap(err)
+	}
+
+	signature, err := hex.DecodeString(c.Signature)
+	if err != nil {
+		return nil, errmsg.ErrSigDeserialization.Wrap(err)
+	}
+
+	return &identityprovider.KeyRotation{
+		PublicKey:    publicKey,
+		NewPublicKey: newPublicKey,
+		Start:        c.Start,
+		Signature:    signature,
+	}, nil
+}
+
+// ToPlain converts a CBOR serializable key revocation to a plain
+// KeyRevocation.
+func (c *KeyRevocation) ToPlain() (*identityprovider.KeyRevocation, error) {
+	if c == nil {
+		return nil, errmsg.ErrKeyDeserialization
+	}
+
+	publicKey, err := hex.DecodeString(c.PublicKey)
+	if err != nil {
+		return nil, errmsg.ErrKeyDeserialization.Wrap(err)
+	}
+
+	signedBy, err := hex.DecodeString(c.SignedBy)
+	if err != nil {
+		return nil, errmsg.ErrKeyDeserialization.Wrap(err)
+	}
+
+	signature, err := hex.DecodeString(c.Signature)
+	if err != nil {
+		return nil, errmsg.ErrSigDeserialization.Wrap(err)
+	}
+
+	return &identityprovider.KeyRevocation{
+		PublicKey: publicKey,
+		After:     c.After,
+		SignedBy:  signedBy,
+		Signature: signature,
 	}, nil
 }
 
@@ -229,10 +416,23 @@ func ToJsonableEntry(e iface.IPFSLogEntry) interface{} {
 			if okEncrypted && okEncryptedNonce {
 				ret.EncryptedLinks = encryptedLinks
 				ret.EncryptedLinksNonce = encryptedLinksNonce
+				ret.EncryptedLinksKeyID = add[iface.KeyEncryptedLinksKeyID]
+				ret.EncryptedLinksAlg = add[iface.KeyEncryptedLinksAlgorithm]
+				ret.EncryptedLinksV = add[iface.KeyEncryptedLinksVersion]
 
 				ret.Next = []cid.Cid{}
 				ret.Refs = []cid.Cid{}
 			}
+
+			if encryptedPayload, ok := add[iface.KeyEncryptedPayload]; ok {
+				ret.EncryptedPayload = encryptedPayload
+				ret.EncryptedPayloadKeyID = add[iface.KeyEncryptedPayloadKeyID]
+				ret.EncryptedPayloadAlg = add[iface.KeyEncryptedPayloadAlgorithm]
+				ret.EncryptedPayloadPad = add[iface.KeyEncryptedPayloadPadding]
+				ret.Payload = ""
+			}
+
+			ret.EncryptedRecipients = add[iface.KeyEncryptedRecipients]
 		}
 
 		return ret
@@ -248,12 +448,42 @@ func ToJsonableLamportClock(l iface.IPFSLogLamportClock) *LamportClock {
 
 // ToJsonableIdentity converts an identity to a CBOR serializable identity.
 func ToJsonableIdentity(id *identityprovider.Identity) *Identity {
-	return &Identity{
+	out := &Identity{
 		ID:         id.ID,
 		PublicKey:  hex.EncodeToString(id.PublicKey),
 		Type:       id.Type,
 		Signatures: ToJsonableIdentitySignature(id.Signatures),
 	}
+
+	for _, r := range id.Rotations {
+		out.Rotations = append(out.Rotations, &KeyRotation{
+			PublicKey:    hex.EncodeToString(r.PublicKey),
+			NewPublicKey: hex.EncodeToString(r.NewPublicKey),
+			Start:        r.Start,
+			Signature:    hex.EncodeToString(r.Signature),
+		})
+	}
+
+	for _, r := range id.Revocations {
+		out.Revocations = append(out.Revocations, &KeyRevocation{
+			PublicKey: hex.EncodeToString(r.PublicKey),
+			After:     r.After,
+			SignedBy:  hex.EncodeToString(r.SignedBy),
+			Signature: hex.EncodeToString(r.Signature),
+		})
+	}
+
+	for _, c := range id.Certificates {
+		out.Certificates = append(out.Certificates, &DeviceCertificate{
+			PublicKey:    hex.EncodeToString(c.PublicKey),
+			IssuedBy:     hex.EncodeToString(c.IssuedBy),
+			Expiry:       c.Expiry,
+			Capabilities: c.Capabilities,
+			Signature:    hex.EncodeToString(c.Signature),
+		})
+	}
+
+	return out
 }
 
 // ToJsonableIdentitySignature converts to a CBOR serialized identity signature a plain IdentitySignature.
@@ -301,6 +531,45 @@ func (c *Entry) ToPlain(out iface.IPFSLogEntry, provider identityprovider.Interf
 	out.SetPayload([]byte(c.Payload))
 	out.SetIdentity(identity)
 
+	// encrypted values are kept as signed, so the entry can be verified and
+	// written again without the keys
+	if c.EncryptedLinks != "" && c.EncryptedLinksNonce != "" {
+		out.SetAdditionalDataValue(iface.KeyEncryptedLinks, c.EncryptedLinks)
+		out.SetAdditionalDataValue(iface.KeyEncryptedLinksNonce, c.EncryptedLinksNonce)
+
+		if c.EncryptedLinksKeyID != "" {
+			out.SetAdditionalDataValue(iface.KeyEncryptedLinksKeyID, c.EncryptedLinksKeyID)
+		}
+
+		if c.EncryptedLinksAlg != "" {
+			out.SetAdditionalDataValue(iface.KeyEncryptedLinksAlgorithm, c.EncryptedLinksAlg)
+		}
+
+		if c.EncryptedLinksV != "" {
+			out.SetAdditionalDataValue(iface.KeyEncryptedLinksVersion, c.EncryptedLinksV)
+		}
+	}
+
+	if c.EncryptedPayload != "" {
+		out.SetAdditionalDataValue(iface.KeyEncryptedPayload, c.EncryptedPayload)
+
+		if c.EncryptedPayloadKeyID != "" {
+			out.SetAdditionalDataValue(iface.KeyEncryptedPayloadKeyID, c.EncryptedPayloadKeyID)
+		}
+
+		if c.EncryptedPayloadAlg != "" {
+			out.SetAdditionalDataValue(iface.KeyEncryptedPayloadAlgorithm, c.EncryptedPayloadAlg)
+		}
+
+		if c.EncryptedPayloadPad != "" {
+			out.SetAdditionalDataValue(iface.KeyEncryptedPayloadPadding, c.EncryptedPayloadPad)
+		}
+	}
+
+	if c.EncryptedRecipients != "" {
+		out.SetAdditionalDataValue(iface.KeyEncryptedRecipients, c.EncryptedRecipients)
+	}
+
 	return nil
 }
 
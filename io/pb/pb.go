@@ -3,11 +3,11 @@ package pb
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 
 	"github.com/ipfs/go-cid"
 	format "github.com/ipfs/go-ipld-format"
 	dag "github.com/ipfs/go-merkledag"
-	coreiface "github.com/ipfs/kubo/core/coreiface"
 
 	"berty.tech/go-ipfs-log/errmsg"
 	idp "berty.tech/go-ipfs-log/identityprovider"
@@ -20,43 +20,78 @@ type pb struct {
 	refEntry iface.IPFSLogEntry
 }
 
-func (p *pb) Write(ctx context.Context, ipfs coreiface.CoreAPI, obj interface{}, _ *iface.WriteOpts) (cid.Cid, error) {
-	var err error
-	payload := []byte(nil)
+// jsonLog is the JSONLog representation used by js-ipfs-log before the
+// switch to dag-cbor, heads are stored as base58 strings.
+type jsonLog struct {
+	ID    string            `json:"id"`
+	Heads []json.RawMessage `json:"heads"`
+}
+
+func (p *pb) Write(ctx context.Context, storage iface.Storage, obj interface{}, opts *iface.WriteOpts) (cid.Cid, error) {
+	var payload []byte
 
 	switch o := obj.(type) {
 	case iface.IPFSLogEntry:
-		payload, err = json.Marshal(jsonable.ToJsonableEntry(o))
+		if o.GetV() != 0 {
+			return cid.Undef, errmsg.ErrEntryVersionNotSupported.Wrap(fmt.Errorf("version %d", o.GetV()))
+		}
+
+		data, err := json.Marshal(jsonable.ToJsonableEntry(o))
 		if err != nil {
-			return cid.Undef, err
+			return cid.Undef, errmsg.ErrJSONSerializationFailed.Wrap(err)
 		}
-		break
+
+		payload = data
 
 	case *iface.JSONLog:
-		payload, err = json.Marshal(o)
+		heads := make([]json.RawMessage, len(o.Heads))
+		for i, h := range o.Heads {
+			data, err := json.Marshal(h.String())
+			if err != nil {
+				return cid.Undef, errmsg.ErrJSONSerializationFailed.Wrap(err)
+			}
+
+			heads[i] = data
+		}
+
+		data, err := json.Marshal(&jsonLog{ID: o.ID, Heads: heads})
 		if err != nil {
-			return cid.Undef, err
+			return cid.Undef, errmsg.ErrJSONSerializationFailed.Wrap(err)
 		}
-		break
+
+		payload = data
+
+	default:
+		return cid.Undef, errmsg.ErrJSONSerializationFailed.Wrap(fmt.Errorf("unsupported type %T", obj))
 	}
 
 	node := &dag.ProtoNode{}
 	node.SetData(payload)
 
-	if err := ipfs.Dag().Add(ctx, node); err != nil {
-		return cid.Cid{}, err
+	if err := storage.Put(ctx, node); err != nil {
+		return cid.Undef, errmsg.ErrIPFSOperationFailed.Wrap(err)
+	}
+
+	if opts != nil && opts.Pin {
+		if err := storage.Pin(ctx, node.Cid()); err != nil {
+			return cid.Undef, errmsg.ErrIPFSOperationFailed.Wrap(err)
+		}
 	}
 
 	return node.Cid(), nil
 }
 
-func (p *pb) Read(ctx context.Context, ipfs coreiface.CoreAPI, contentIdentifier cid.Cid) (format.Node, error) {
-	node, err := ipfs.Dag().Get(ctx, contentIdentifier)
+func (p *pb) Read(ctx context.Context, storage iface.Storage, contentIdentifier cid.Cid) (format.Node, error) {
+	block, err := storage.Get(ctx, contentIdentifier)
 	if err != nil {
 		return nil, err
 	}
 
-	return node, nil
+	if node, ok := block.(format.Node); ok {
+		return node, nil
+	}
+
+	return dag.DecodeProtobufBlock(block)
 }
 
 func (p *pb) DecodeRawEntry(node format.Node, hash cid.Cid, idProvider idp.Interface) (iface.IPFSLogEntry, error) {
@@ -65,11 +100,11 @@ func (p *pb) DecodeRawEntry(node format.Node, hash cid.Cid, idProvider idp.Inter
 
 	pbNode, err := dag.DecodeProtobuf(node.RawData())
 	if err != nil {
-		return nil, errmsg.ErrPBReadUnmarshalFailed
+		return nil, errmsg.ErrPBReadUnmarshalFailed.Wrap(err)
 	}
 
 	if err := json.Unmarshal(pbNode.Data(), entry); err != nil {
-		return nil, err
+		return nil, errmsg.ErrEntryDeserializationFailed.Wrap(err)
 	}
 
 	if err := entry.ToPlain(out, idProvider, p.refClock.New); err != nil {
@@ -82,14 +117,43 @@ func (p *pb) DecodeRawEntry(node format.Node, hash cid.Cid, idProvider idp.Inter
 }
 
 func (p *pb) DecodeRawJSONLog(node format.Node) (*iface.JSONLog, error) {
-	jsonLog := &iface.JSONLog{}
-	err := json.Unmarshal(node.RawData(), jsonLog)
-
+	pbNode, err := dag.DecodeProtobuf(node.RawData())
 	if err != nil {
-		return nil, errmsg.ErrCBOROperationFailed.Wrap(err)
+		return nil, errmsg.ErrPBReadUnmarshalFailed.Wrap(err)
+	}
+
+	raw := &jsonLog{}
+	if err := json.Unmarshal(pbNode.Data(), raw); err != nil {
+		return nil, errmsg.ErrJSONSerializationFailed.Wrap(err)
+	}
+
+	heads := make([]cid.Cid, len(raw.Heads))
+	for i, h := range raw.Heads {
+		if heads[i], err = decodeHead(h); err != nil {
+			return nil, errmsg.ErrCIDSerializationFailed.Wrap(err)
+		}
+	}
+
+	return &iface.JSONLog{
+		ID:    raw.ID,
+		Heads: heads,
+	}, nil
+}
+
+// decodeHead accepts both the base58 strings written by js-ipfs-log and the
+// {"/": "..."} links written by earlier versions of this package.
+func decodeHead(data json.RawMessage) (cid.Cid, error) {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return cid.Parse(s)
+	}
+
+	var c cid.Cid
+	if err := json.Unmarshal(data, &c); err != nil {
+		return cid.Undef, err
 	}
 
-	return jsonLog, nil
+	return c, nil
 }
 
 var _io = (*pb)(nil)
@@ -99,7 +163,7 @@ func IO(entry iface.IPFSLogEntry, clock iface.IPFSLogLamportClock) (iface.IO, er
 		return _io, nil
 	}
 
-	_io := &pb{
+	_io = &pb{
 		refClock: clock,
 		refEntry: entry,
 	}
@@ -0,0 +1,287 @@
+package keystore // import "berty.tech/go-ipfs-log/keystore"
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"strings"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"berty.tech/go-ipfs-log/errmsg"
+)
+
+const (
+	kekSize = chacha20poly1305.KeySize
+
+	saltSize = 16
+
+	defaultArgon2Time    = 3
+	defaultArgon2Memory  = 64 * 1024
+	defaultArgon2Threads = 4
+)
+
+// reservedPrefix is the namespace of the keystore metadata, it is not listed
+// by ListKeys.
+const reservedPrefix = "/.keystore"
+
+// isReserved checks whether a datastore key belongs to the keystore
+// metadata, such keys can't be read nor written as private keys.
+func isReserved(key string) bool {
+	return key == reservedPrefix || strings.HasPrefix(key, reservedPrefix+"/")
+}
+
+var (
+	saltKey  = datastore.NewKey(reservedPrefix + "/salt")
+	checkKey = datastore.NewKey(reservedPrefix + "/check")
+
+	checkValue = []byte("go-ipfs-log keystore")
+)
+
+// EncryptionOptions defines the key-encryption key protecting the private
+// keys stored by an encrypted keystore, either Secret or Passphrase must be
+// set.
+type EncryptionOptions struct {
+	// Secret is a random 32 bytes key-encryption key.
+	Secret []byte
+
+	// Passphrase is stretched using Argon2id with a random salt stored in
+	// the datastore.
+	Passphrase []byte
+
+	// Time, Memory (in KiB) and Threads are the Argon2id parameters, they
+	// default to 3, 64 MiB and 4.
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+// NewEncryptedKeystore creates a keystore encrypting the private keys at rest
+// using XChaCha20-Poly1305. It is returned unlocked.
+//
+// Keys stored in clear by a plain keystore can't be read, they must be
+// encrypted first using MigrateClearKeys.
+func NewEncryptedKeystore(ctx context.Context, store datastore.Datastore, options *EncryptionOptions) (*Keystore, error) {
+	k, err := NewKeystore(store)
+	if err != nil {
+		return nil, err
+	}
+
+	k.encrypted = true
+
+	if err := k.Unlock(ctx, options); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// Unlock derives the key-encryption key and checks it against the one used
+// to encrypt the keystore.
+func (k *Keystore) Unlock(ctx context.Context, options *EncryptionOptions) error {
+	if !k.encrypted {
+		return nil
+	}
+
+	kek, err := k.deriveKEK(ctx, options)
+	if err != nil {
+		return errmsg.ErrKeystoreUnlockFailed.Wrap(err)
+	}
+
+	aead, err := chacha20poly1305.NewX(kek)
+	zero(kek)
+
+	if err != nil {
+		return errmsg.ErrKeystoreUnlockFailed.Wrap(err)
+	}
+
+	check, err := k.store.Get(ctx, checkKey)
+	switch err {
+	case nil:
+		if _, err := openValue(aead, checkKey.String(), check); err != nil {
+			return errmsg.ErrKeystoreUnlockFailed.Wrap(fmt.Errorf("wrong secret"))
+		}
+
+	case datastore.ErrNotFound:
+		sealed, err := sealValue(aead, checkKey.String(), checkValue)
+		if err != nil {
+			return errmsg.ErrKeystoreUnlockFailed.Wrap(err)
+		}
+
+		if err := k.store.Put(ctx, checkKey, sealed); err != nil {
+			return errmsg.ErrKeystoreUnlockFailed.Wrap(err)
+		}
+
+	default:
+		return errmsg.ErrKeystoreUnlockFailed.Wrap(err)
+	}
+
+	k.lock.Lock()
+	k.aead = aead
+	k.lock.Unlock()
+
+	return nil
+}
+
+// Lock forgets the key-encryption key and zeroes the cached private keys,
+// private keys can't be read nor created until the keystore is unlocked.
+func (k *Keystore) Lock() {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	// cached keys are zeroed on eviction
+	k.cache.Purge()
+
+	if k.encrypted {
+		k.aead = nil
+	}
+}
+
+// IsLocked checks whether the keystore is an encrypted one which is locked.
+func (k *Keystore) IsLocked() bool {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+
+	return k.encrypted && k.aead == nil
+}
+
+// MigrateClearKeys encrypts the keys stored in clear by a plain keystore in
+// the datastore of an unlocked encrypted keystore. It must only be called on
+// datastores whose clear keys are trusted, any value which isn't encrypted
+// and holds a private key is adopted.
+func (k *Keystore) MigrateClearKeys(ctx context.Context) error {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+
+	if !k.encrypted {
+		return nil
+	}
+
+	if k.aead == nil {
+		return errmsg.ErrKeystoreLocked
+	}
+
+	results, err := k.store.Query(ctx, query.Query{})
+	if err != nil {
+		return errmsg.ErrKeyStoreQueryFailed.Wrap(err)
+	}
+
+	entries, err := results.Rest()
+	if err != nil {
+		return errmsg.ErrKeyStoreQueryFailed.Wrap(err)
+	}
+
+	for _, e := range entries {
+		if isReserved(e.Key) {
+			continue
+		}
+
+		id := strings.TrimPrefix(e.Key, "/")
+
+		if keyBytes, err := openValue(k.aead, id, e.Value); err == nil {
+			zero(keyBytes)
+			continue
+		}
+
+		if _, err := unmarshalPrivateKey(e.Value); err != nil {
+			return errmsg.ErrKeyDeserialization.Wrap(fmt.Errorf("%s: %w", id, err))
+		}
+
+		if err := k.put(ctx, id, e.Value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (k *Keystore) deriveKEK(ctx context.Context, options *EncryptionOptions) ([]byte, error) {
+	if options == nil {
+		return nil, fmt.Errorf("missing encryption options")
+	}
+
+	if len(options.Secret) > 0 {
+		if len(options.Secret) != kekSize {
+			return nil, fmt.Errorf("secret must be %d bytes long", kekSize)
+		}
+
+		return append([]byte{}, options.Secret...), nil
+	}
+
+	if len(options.Passphrase) == 0 {
+		return nil, fmt.Errorf("missing secret or passphrase")
+	}
+
+	salt, err := k.store.Get(ctx, saltKey)
+	if err == datastore.ErrNotFound {
+		salt, err = randomBytes(saltSize)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := k.store.Put(ctx, saltKey, salt); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	return deriveFromPassphrase(options.Passphrase, salt, options), nil
+}
+
+// deriveFromPassphrase stretches a passphrase using Argon2id and the
+// parameters of options.
+func deriveFromPassphrase(passphrase []byte, salt []byte, options *EncryptionOptions) []byte {
+	time, memory, threads := options.Time, options.Memory, options.Threads
+	if time == 0 {
+		time = defaultArgon2Time
+	}
+
+	if memory == 0 {
+		memory = defaultArgon2Memory
+	}
+
+	if threads == 0 {
+		threads = defaultArgon2Threads
+	}
+
+	return argon2.IDKey(passphrase, salt, time, memory, threads, kekSize)
+}
+
+func randomBytes(size int) ([]byte, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// sealValue encrypts a value bound to its ID, so values can't be swapped in
+// the datastore.
+func sealValue(aead cipher.AEAD, id string, value []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(value)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, value, []byte(id)), nil
+}
+
+func openValue(aead cipher.AEAD, id string, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize()+aead.Overhead() {
+		return nil, fmt.Errorf("invalid encrypted value")
+	}
+
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(id))
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,271 @@
+package keystore // import "berty.tech/go-ipfs-log/keystore"
+
+import (
+	"context"
+	"encoding/pem"
+	"strings"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"berty.tech/go-ipfs-log/errmsg"
+)
+
+// ExportFormat is the serialization format of exported keys.
+type ExportFormat string
+
+const (
+	// ExportFormatLibp2p is the libp2p protobuf encoding of private keys,
+	// when encrypted it is prefixed by the Argon2id salt and sealed using
+	// XChaCha20-Poly1305.
+	ExportFormatLibp2p ExportFormat = "libp2p"
+
+	// ExportFormatPEM is a PEM encoded PKCS#8 private key, when encrypted
+	// it uses PBES2 with PBKDF2-HMAC-SHA256 and AES-256-CBC.
+	ExportFormatPEM ExportFormat = "pem"
+)
+
+// ExportOptions defines how a key is exported.
+type ExportOptions struct {
+	// Format defaults to ExportFormatLibp2p.
+	Format ExportFormat
+
+	// Passphrase encrypts the exported key if set.
+	Passphrase []byte
+}
+
+// ListKeys returns the IDs of the keys stored in the keystore.
+func (k *Keystore) ListKeys(ctx context.Context) ([]string, error) {
+	results, err := k.store.Query(ctx, query.Query{KeysOnly: true})
+	if err != nil {
+		return nil, errmsg.ErrKeyStoreQueryFailed.Wrap(err)
+	}
+
+	entries, err := results.Rest()
+	if err != nil {
+		return nil, errmsg.ErrKeyStoreQueryFailed.Wrap(err)
+	}
+
+	ids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if isReserved(e.Key) {
+			continue
+		}
+
+		ids = append(ids, strings.TrimPrefix(e.Key, "/"))
+	}
+
+	return ids, nil
+}
+
+// DeleteKey removes a key from the keystore, its cached copy is zeroed.
+func (k *Keystore) DeleteKey(ctx context.Context, id string) error {
+	if isReserved(datastore.NewKey(id).String()) {
+		return errmsg.ErrKeyNotInKeystore
+	}
+
+	ok, err := k.store.Has(ctx, datastore.NewKey(id))
+	if err != nil {
+		return errmsg.ErrKeyStoreDeleteFailed.Wrap(err)
+	}
+
+	if !ok {
+		return errmsg.ErrKeyNotInKeystore
+	}
+
+	k.cache.Remove(id)
+
+	if err := k.store.Delete(ctx, datastore.NewKey(id)); err != nil {
+		return errmsg.ErrKeyStoreDeleteFailed.Wrap(err)
+	}
+
+	return nil
+}
+
+// ExportKey serializes a key so it can be imported in another keystore.
+func (k *Keystore) ExportKey(ctx context.Context, id string, options *ExportOptions) ([]byte, error) {
+	if options == nil {
+		options = &ExportOptions{}
+	}
+
+	if isReserved(datastore.NewKey(id).String()) {
+		return nil, errmsg.ErrKeyNotInKeystore
+	}
+
+	priv, err := k.GetKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch options.Format {
+	case "", ExportFormatLibp2p:
+		keyBytes, err := marshalPrivateKey(priv)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(options.Passphrase) == 0 {
+			return keyBytes, nil
+		}
+
+		defer zero(keyBytes)
+
+		return sealExport(keyBytes, options.Passphrase)
+
+	case ExportFormatPEM:
+		der, err := marshalPKCS8(priv)
+		if err != nil {
+			return nil, errmsg.ErrKeyExportFailed.Wrap(err)
+		}
+
+		defer zero(der)
+
+		if len(options.Passphrase) == 0 {
+			return pem.EncodeToMemory(&pem.Block{Type: pemPrivateKey, Bytes: der}), nil
+		}
+
+		encrypted, err := encryptPKCS8(der, options.Passphrase)
+		if err != nil {
+			return nil, errmsg.ErrKeyExportFailed.Wrap(err)
+		}
+
+		return pem.EncodeToMemory(&pem.Block{Type: pemEncryptedPrivateKey, Bytes: encrypted}), nil
+	}
+
+	return nil, errmsg.ErrKeyExportFailed.Wrap(errmsg.ErrUnsupportedFormat)
+}
+
+// ImportKey stores under id a key exported by ExportKey, the format is
+// detected. It fails if a key is already stored under id.
+func (k *Keystore) ImportKey(ctx context.Context, id string, data []byte, passphrase []byte) (crypto.PrivKey, error) {
+	if isReserved(datastore.NewKey(id).String()) {
+		return nil, errmsg.ErrKeyImportFailed.Wrap(errmsg.ErrKeyNotInKeystore)
+	}
+
+	priv, err := parseExport(data, passphrase)
+	if err != nil {
+		return nil, errmsg.ErrKeyImportFailed.Wrap(err)
+	}
+
+	// the write lock keeps concurrent imports from overwriting each other
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if k.encrypted && k.aead == nil {
+		return nil, errmsg.ErrKeystoreLocked
+	}
+
+	exists, err := k.store.Has(ctx, datastore.NewKey(id))
+	if err != nil {
+		return nil, errmsg.ErrKeyImportFailed.Wrap(err)
+	}
+
+	if exists {
+		return nil, errmsg.ErrKeyImportFailed.Wrap(errmsg.ErrKeyAlreadyInKeystore)
+	}
+
+	keyBytes, err := marshalPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := k.put(ctx, id, keyBytes); err != nil {
+		return nil, err
+	}
+
+	k.cache.Add(id, append([]byte{}, keyBytes...))
+
+	return priv, nil
+}
+
+func parseExport(data []byte, passphrase []byte) (crypto.PrivKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		der := block.Bytes
+
+		switch block.Type {
+		case pemPrivateKey:
+		case pemEncryptedPrivateKey:
+			if len(passphrase) == 0 {
+				return nil, errmsg.ErrPassphraseRequired
+			}
+
+			decrypted, err := decryptPKCS8(der, passphrase)
+			if err != nil {
+				return nil, err
+			}
+
+			defer zero(decrypted)
+			der = decrypted
+
+		default:
+			return nil, errmsg.ErrUnsupportedFormat
+		}
+
+		return parsePKCS8(der)
+	}
+
+	keyBytes := data
+
+	if len(passphrase) > 0 {
+		opened, err := openExport(data, passphrase)
+		if err != nil {
+			return nil, err
+		}
+
+		defer zero(opened)
+		keyBytes = opened
+	}
+
+	priv, err := crypto.UnmarshalPrivateKey(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := KeyTypeOf(priv); err != nil {
+		return nil, err
+	}
+
+	return priv, nil
+}
+
+// sealExport encrypts an exported key using a key derived from a passphrase
+// with the default Argon2id parameters.
+func sealExport(keyBytes []byte, passphrase []byte) ([]byte, error) {
+	salt, err := randomBytes(saltSize)
+	if err != nil {
+		return nil, errmsg.ErrKeyExportFailed.Wrap(err)
+	}
+
+	kek := deriveFromPassphrase(passphrase, salt, &EncryptionOptions{})
+	defer zero(kek)
+
+	aead, err := chacha20poly1305.NewX(kek)
+	if err != nil {
+		return nil, errmsg.ErrKeyExportFailed.Wrap(err)
+	}
+
+	sealed, err := sealValue(aead, string(ExportFormatLibp2p), keyBytes)
+	if err != nil {
+		return nil, errmsg.ErrKeyExportFailed.Wrap(err)
+	}
+
+	return append(salt, sealed...), nil
+}
+
+func openExport(data []byte, passphrase []byte) ([]byte, error) {
+	if len(data) < saltSize {
+		return nil, errmsg.ErrInvalidPrivKeyFormat
+	}
+
+	kek := deriveFromPassphrase(passphrase, data[:saltSize], &EncryptionOptions{})
+	defer zero(kek)
+
+	aead, err := chacha20poly1305.NewX(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	return openValue(aead, string(ExportFormatLibp2p), data[saltSize:])
+}
@@ -17,3 +17,17 @@ type Interface interface {
 
 	Verify(signature []byte, publicKey crypto.PubKey, data []byte) error
 }
+
+// ManagedInterface is implemented by keystores whose keys can be listed,
+// deleted and moved to another keystore.
+type ManagedInterface interface {
+	Interface
+
+	ListKeys(ctx context.Context) ([]string, error)
+
+	DeleteKey(ctx context.Context, id string) error
+
+	ExportKey(ctx context.Context, id string, options *ExportOptions) ([]byte, error)
+
+	ImportKey(ctx context.Context, id string, data []byte, passphrase []byte) (crypto.PrivKey, error)
+}
@@ -3,8 +3,8 @@ package keystore // import "berty.tech/go-ipfs-log/keystore"
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/base64"
+	"crypto/cipher"
+	"sync"
 
 	lru "github.com/hashicorp/golang-lru"
 	"github.com/ipfs/go-datastore"
@@ -16,6 +16,10 @@ import (
 type Keystore struct {
 	store datastore.Datastore
 	cache *lru.Cache
+
+	lock      sync.RWMutex
+	encrypted bool
+	aead      cipher.AEAD
 }
 
 // Sign signs a value using a given private key.
@@ -39,7 +43,9 @@ func (k *Keystore) Verify(signature []byte, publicKey crypto.PubKey, data []byte
 
 // NewKeystore creates a new keystore.
 func NewKeystore(store datastore.Datastore) (*Keystore, error) {
-	cache, err := lru.New(128)
+	cache, err := lru.NewWithEvict(128, func(_ interface{}, value interface{}) {
+		zero(value.([]byte))
+	})
 	if err != nil {
 		return nil, errmsg.ErrKeyStoreInitFailed.Wrap(err)
 	}
@@ -52,70 +58,143 @@ func NewKeystore(store datastore.Datastore) (*Keystore, error) {
 
 // HasKey checks whether a given key ID exist in the keystore.
 func (k *Keystore) HasKey(ctx context.Context, id string) (bool, error) {
-	storedKey, ok := k.cache.Peek(id)
-
-	if ok == false {
-		value, err := k.store.Get(ctx, datastore.NewKey(id))
-		if err != nil {
-			return false, errmsg.ErrKeyNotInKeystore.Wrap(err)
-		}
+	if k.cache.Contains(id) {
+		return true, nil
+	}
 
-		if storedKey != nil {
-			k.cache.Add(id, base64.StdEncoding.EncodeToString(value))
-		}
+	ok, err := k.store.Has(ctx, datastore.NewKey(id))
+	if err != nil {
+		return false, errmsg.ErrKeyNotInKeystore.Wrap(err)
 	}
 
-	return storedKey != nil, nil
+	return ok, nil
 }
 
-// CreateKey creates a new key in the key store.
+// CreateKey creates a new secp256k1 key in the key store, secp256k1 is kept
+// for compatibility with OrbitDB.
 func (k *Keystore) CreateKey(ctx context.Context, id string) (crypto.PrivKey, error) {
-	// FIXME: I kept Secp256k1 for compatibility with OrbitDB, should we change this?
-	priv, _, err := crypto.GenerateSecp256k1Key(rand.Reader)
+	return k.CreateKeyWithType(ctx, id, KeyTypeSecp256k1)
+}
+
+// CreateKeyWithType creates a new key of a given type in the key store.
+func (k *Keystore) CreateKeyWithType(ctx context.Context, id string, keyType KeyType) (crypto.PrivKey, error) {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+
+	if k.encrypted && k.aead == nil {
+		return nil, errmsg.ErrKeystoreLocked
+	}
+
+	priv, err := k.generateKey(ctx, id, keyType)
 	if err != nil {
-		return nil, errmsg.ErrKeyGenerationFailed.Wrap(err)
+		return nil, err
 	}
 
-	keyBytes, err := priv.Raw()
+	keyBytes, err := marshalPrivateKey(priv)
 	if err != nil {
-		return nil, errmsg.ErrInvalidPrivKeyFormat.Wrap(err)
+		return nil, err
 	}
 
-	if err := k.store.Put(ctx, datastore.NewKey(id), keyBytes); err != nil {
-		return nil, errmsg.ErrKeyStorePutFailed.Wrap(err)
+	if err := k.put(ctx, id, keyBytes); err != nil {
+		return nil, err
 	}
 
-	k.cache.Add(id, base64.StdEncoding.EncodeToString(keyBytes))
+	k.cache.Add(id, append([]byte{}, keyBytes...))
 
 	return priv, nil
 }
 
 // GetKey retrieves a key from the keystore.
 func (k *Keystore) GetKey(ctx context.Context, id string) (crypto.PrivKey, error) {
-	var err error
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+
+	if k.encrypted && k.aead == nil {
+		return nil, errmsg.ErrKeystoreLocked
+	}
+
 	var keyBytes []byte
 
 	cachedKey, ok := k.cache.Get(id)
 	if !ok || cachedKey == nil {
-		keyBytes, err = k.store.Get(ctx, datastore.NewKey(id))
-
+		value, err := k.get(ctx, id)
 		if err != nil {
-			return nil, errmsg.ErrKeyNotInKeystore.Wrap(err)
+			return nil, err
 		}
-		k.cache.Add(id, base64.StdEncoding.EncodeToString(keyBytes))
+
+		keyBytes = value
+		k.cache.Add(id, append([]byte{}, value...))
 	} else {
-		keyBytes, err = base64.StdEncoding.DecodeString(cachedKey.(string))
+		keyBytes = append([]byte{}, cachedKey.([]byte)...)
+	}
+
+	defer zero(keyBytes)
+
+	return unmarshalPrivateKey(keyBytes)
+}
+
+// generateKey derives the key from the seed of the keystore if it uses a
+// mnemonic, it generates a random key otherwise.
+func (k *Keystore) generateKey(ctx context.Context, id string, keyType KeyType) (crypto.PrivKey, error) {
+	seed, err := k.seed(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if seed == nil {
+		return GenerateKey(keyType)
+	}
+
+	defer zero(seed)
+
+	return DeriveKey(seed, id, keyType)
+}
+
+// put stores a private key, encrypted if the keystore is.
+func (k *Keystore) put(ctx context.Context, id string, keyBytes []byte) error {
+	value := keyBytes
+
+	if k.encrypted {
+		sealed, err := sealValue(k.aead, id, keyBytes)
 		if err != nil {
-			return nil, errmsg.ErrInvalidPrivKeyFormat.Wrap(err)
+			return errmsg.ErrKeyStorePutFailed.Wrap(err)
 		}
+
+		value = sealed
+	}
+
+	if err := k.store.Put(ctx, datastore.NewKey(id), value); err != nil {
+		return errmsg.ErrKeyStorePutFailed.Wrap(err)
 	}
 
-	privateKey, err := crypto.UnmarshalSecp256k1PrivateKey(keyBytes)
+	return nil
+}
+
+// get reads a private key from the datastore, decrypting it if the keystore
+// is encrypted. Keys stored in clear in an encrypted keystore can't be read
+// until they are migrated by MigrateClearKeys.
+func (k *Keystore) get(ctx context.Context, id string) ([]byte, error) {
+	stored, err := k.store.Get(ctx, datastore.NewKey(id))
 	if err != nil {
-		return nil, errmsg.ErrInvalidPrivKeyFormat.Wrap(err)
+		return nil, errmsg.ErrKeyNotInKeystore.Wrap(err)
 	}
 
-	return privateKey, nil
+	// the datastore may return the value it holds, which is zeroed after use
+	value := append([]byte{}, stored...)
+
+	if !k.encrypted {
+		return value, nil
+	}
+
+	keyBytes, err := openValue(k.aead, id, value)
+	if err != nil {
+		return nil, errmsg.ErrDecrypt.Wrap(err)
+	}
+
+	return keyBytes, nil
 }
 
-var _ Interface = &Keystore{}
+var (
+	_ TypedInterface   = &Keystore{}
+	_ ManagedInterface = &Keystore{}
+)
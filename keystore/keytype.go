@@ -0,0 +1,101 @@
+package keystore // import "berty.tech/go-ipfs-log/keystore"
+
+import (
+	"context"
+	"crypto/rand"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+
+	"berty.tech/go-ipfs-log/errmsg"
+)
+
+// KeyType is the type of the keys created by a keystore.
+type KeyType string
+
+const (
+	// KeyTypeSecp256k1 is the default key type, for compatibility with
+	// OrbitDB.
+	KeyTypeSecp256k1 KeyType = "secp256k1"
+	KeyTypeEd25519   KeyType = "ed25519"
+	KeyTypeECDSAP256 KeyType = "ecdsa-p256"
+)
+
+// legacyPrivKeySize is the size of the secp256k1 keys stored as raw bytes,
+// keys are now stored using the libp2p encoding which records their type.
+const legacyPrivKeySize = 32
+
+// TypedInterface is implemented by keystores able to create keys of another
+// type than secp256k1.
+type TypedInterface interface {
+	Interface
+
+	CreateKeyWithType(ctx context.Context, id string, keyType KeyType) (crypto.PrivKey, error)
+}
+
+// KeyTypeOf returns the type of a key.
+func KeyTypeOf(key crypto.Key) (KeyType, error) {
+	switch key.Type() {
+	case crypto.Secp256k1:
+		return KeyTypeSecp256k1, nil
+	case crypto.Ed25519:
+		return KeyTypeEd25519, nil
+	case crypto.ECDSA:
+		return KeyTypeECDSAP256, nil
+	}
+
+	return "", errmsg.ErrUnsupportedKeyType
+}
+
+// GenerateKey generates a private key of a given type, defaults to
+// secp256k1.
+func GenerateKey(keyType KeyType) (crypto.PrivKey, error) {
+	var (
+		priv crypto.PrivKey
+		err  error
+	)
+
+	switch keyType {
+	case "", KeyTypeSecp256k1:
+		priv, _, err = crypto.GenerateSecp256k1Key(rand.Reader)
+	case KeyTypeEd25519:
+		priv, _, err = crypto.GenerateEd25519Key(rand.Reader)
+	case KeyTypeECDSAP256:
+		priv, _, err = crypto.GenerateECDSAKeyPair(rand.Reader)
+	default:
+		return nil, errmsg.ErrUnsupportedKeyType
+	}
+
+	if err != nil {
+		return nil, errmsg.ErrKeyGenerationFailed.Wrap(err)
+	}
+
+	return priv, nil
+}
+
+func marshalPrivateKey(priv crypto.PrivKey) ([]byte, error) {
+	keyBytes, err := crypto.MarshalPrivateKey(priv)
+	if err != nil {
+		return nil, errmsg.ErrInvalidPrivKeyFormat.Wrap(err)
+	}
+
+	return keyBytes, nil
+}
+
+func unmarshalPrivateKey(keyBytes []byte) (crypto.PrivKey, error) {
+	var (
+		priv crypto.PrivKey
+		err  error
+	)
+
+	if len(keyBytes) == legacyPrivKeySize {
+		priv, err = crypto.UnmarshalSecp256k1PrivateKey(keyBytes)
+	} else {
+		priv, err = crypto.UnmarshalPrivateKey(keyBytes)
+	}
+
+	if err != nil {
+		return nil, errmsg.ErrInvalidPrivKeyFormat.Wrap(err)
+	}
+
+	return priv, nil
+}
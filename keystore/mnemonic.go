@@ -0,0 +1,177 @@
+package keystore // import "berty.tech/go-ipfs-log/keystore"
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/big"
+
+	btcec "github.com/btcsuite/btcd/btcec/v2"
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/tyler-smith/go-bip39"
+	"golang.org/x/crypto/hkdf"
+
+	"berty.tech/go-ipfs-log/errmsg"
+)
+
+// mnemonicEntropySize is the entropy of generated mnemonics, in bits, which
+// gives 24 words.
+const mnemonicEntropySize = 256
+
+// seedKey is the datastore key of the BIP-39 seed, it is encrypted in an
+// encrypted keystore.
+var seedKey = datastore.NewKey(reservedPrefix + "/seed")
+
+// NewMnemonic generates a BIP-39 mnemonic of 24 English words.
+func NewMnemonic() (string, error) {
+	entropy, err := bip39.NewEntropy(mnemonicEntropySize)
+	if err != nil {
+		return "", errmsg.ErrKeyGenerationFailed.Wrap(err)
+	}
+
+	mnemonic, err := bip39.NewMnemonic(entropy)
+	if err != nil {
+		return "", errmsg.ErrKeyGenerationFailed.Wrap(err)
+	}
+
+	return mnemonic, nil
+}
+
+// UseMnemonic makes the keys created afterwards derive from a BIP-39
+// mnemonic and optional passphrase, so they can be recovered on another
+// device by creating the same key IDs in a keystore using the same mnemonic.
+//
+// The seed is stored in the datastore, keys created before remain random.
+func (k *Keystore) UseMnemonic(ctx context.Context, mnemonic string, passphrase string) error {
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, passphrase)
+	if err != nil {
+		return errmsg.ErrInvalidMnemonic.Wrap(err)
+	}
+
+	defer zero(seed)
+
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+
+	if k.encrypted && k.aead == nil {
+		return errmsg.ErrKeystoreLocked
+	}
+
+	current, err := k.seed(ctx)
+	if err != nil {
+		return err
+	}
+
+	if current != nil {
+		defer zero(current)
+
+		if !bytes.Equal(current, seed) {
+			return errmsg.ErrInvalidMnemonic.Wrap(fmt.Errorf("the keystore uses another mnemonic"))
+		}
+
+		return nil
+	}
+
+	return k.put(ctx, seedKey.String(), seed)
+}
+
+// seed returns the BIP-39 seed of the keystore, nil if it doesn't use a
+// mnemonic.
+func (k *Keystore) seed(ctx context.Context) ([]byte, error) {
+	ok, err := k.store.Has(ctx, seedKey)
+	if err != nil {
+		return nil, errmsg.ErrKeyNotInKeystore.Wrap(err)
+	}
+
+	if !ok {
+		return nil, nil
+	}
+
+	value, err := k.store.Get(ctx, seedKey)
+	if err != nil {
+		return nil, errmsg.ErrKeyNotInKeystore.Wrap(err)
+	}
+
+	if !k.encrypted {
+		return append([]byte{}, value...), nil
+	}
+
+	seed, err := openValue(k.aead, seedKey.String(), value)
+	if err != nil {
+		return nil, errmsg.ErrInvalidMnemonic.Wrap(err)
+	}
+
+	return seed, nil
+}
+
+// DeriveKey derives the key of a given ID and type from a BIP-39 seed.
+//
+// The key material is read from HKDF-SHA256, with the key type and ID as
+// info, discarding values which aren't valid scalars of the curve.
+func DeriveKey(seed []byte, id string, keyType KeyType) (crypto.PrivKey, error) {
+	if keyType == "" {
+		keyType = KeyTypeSecp256k1
+	}
+
+	r := hkdf.New(sha256.New, seed, nil, []byte(fmt.Sprintf("go-ipfs-log/keystore/%s/%s", keyType, id)))
+	material := make([]byte, 32)
+
+	defer zero(material)
+
+	for {
+		if _, err := io.ReadFull(r, material); err != nil {
+			return nil, errmsg.ErrKeyGenerationFailed.Wrap(err)
+		}
+
+		switch keyType {
+		case KeyTypeEd25519:
+			priv := ed25519.NewKeyFromSeed(material)
+			key, _, err := crypto.KeyPairFromStdKey(&priv)
+			if err != nil {
+				return nil, errmsg.ErrKeyGenerationFailed.Wrap(err)
+			}
+
+			return key, nil
+
+		case KeyTypeSecp256k1:
+			scalar := btcec.ModNScalar{}
+			if overflow := scalar.SetByteSlice(material); overflow || scalar.IsZero() {
+				continue
+			}
+
+			key, err := crypto.UnmarshalSecp256k1PrivateKey(material)
+			if err != nil {
+				return nil, errmsg.ErrKeyGenerationFailed.Wrap(err)
+			}
+
+			return key, nil
+
+		case KeyTypeECDSAP256:
+			curve := elliptic.P256()
+			d := new(big.Int).SetBytes(material)
+
+			if d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+				continue
+			}
+
+			priv := &ecdsa.PrivateKey{D: d, PublicKey: ecdsa.PublicKey{Curve: curve}}
+			priv.PublicKey.X, priv.PublicKey.Y = curve.ScalarBaseMult(material)
+
+			key, _, err := crypto.KeyPairFromStdKey(priv)
+			if err != nil {
+				return nil, errmsg.ErrKeyGenerationFailed.Wrap(err)
+			}
+
+			return key, nil
+
+		default:
+			return nil, errmsg.ErrUnsupportedKeyType
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package keystore // import "berty.tech/go-ipfs-log/keystore"
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	pemPrivateKey          = "PRIVATE KEY"
+	pemEncryptedPrivateKey = "ENCRYPTED PRIVATE KEY"
+
+	pbkdf2Iterations = 600000
+	pbkdf2SaltSize   = 16
+
+	// maxPBKDF2Iterations bounds the work done when importing a key.
+	maxPBKDF2Iterations = 10 * pbkdf2Iterations
+)
+
+var (
+	oidPublicKeyECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidSecp256k1      = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
+
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// pkcs8 is the PKCS#8 PrivateKeyInfo structure, see RFC 5208.
+type pkcs8 struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+// ecPrivateKey is the SEC 1 ECPrivateKey structure, see RFC 5915.
+type ecPrivateKey struct {
+	Version       int
+	PrivateKey    []byte
+	NamedCurveOID asn1.ObjectIdentifier `asn1:"optional,explicit,tag:0"`
+	PublicKey     asn1.BitString        `asn1:"optional,explicit,tag:1"`
+}
+
+// encryptedPrivateKeyInfo is the PKCS#8 EncryptedPrivateKeyInfo structure,
+// only PBES2 with PBKDF2 and AES-256-CBC is supported, see RFC 8018.
+type encryptedPrivateKeyInfo struct {
+	Algo          pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// marshalPKCS8 serializes a private key as a PKCS#8 PrivateKeyInfo, the
+// standard library doesn't support secp256k1 keys.
+func marshalPKCS8(priv crypto.PrivKey) ([]byte, error) {
+	if priv.Type() == crypto.Secp256k1 {
+		raw, err := priv.Raw()
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := asn1.Marshal(ecPrivateKey{Version: 1, PrivateKey: raw})
+		if err != nil {
+			return nil, err
+		}
+
+		params, err := asn1.Marshal(oidSecp256k1)
+		if err != nil {
+			return nil, err
+		}
+
+		return asn1.Marshal(pkcs8{
+			Algo: pkix.AlgorithmIdentifier{
+				Algorithm:  oidPublicKeyECDSA,
+				Parameters: asn1.RawValue{FullBytes: params},
+			},
+			PrivateKey: key,
+		})
+	}
+
+	std, err := crypto.PrivKeyToStdKey(priv)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, ok := std.(*ed25519.PrivateKey); ok {
+		std = *key
+	}
+
+	return x509.MarshalPKCS8PrivateKey(std)
+}
+
+func parsePKCS8(der []byte) (crypto.PrivKey, error) {
+	info := pkcs8{}
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	}
+
+	if info.Algo.Algorithm.Equal(oidPublicKeyECDSA) {
+		curve := asn1.ObjectIdentifier{}
+		if _, err := asn1.Unmarshal(info.Algo.Parameters.FullBytes, &curve); err != nil {
+			return nil, err
+		}
+
+		if curve.Equal(oidSecp256k1) {
+			key := ecPrivateKey{}
+			if _, err := asn1.Unmarshal(info.PrivateKey, &key); err != nil {
+				return nil, err
+			}
+
+			return crypto.UnmarshalSecp256k1PrivateKey(key.PrivateKey)
+		}
+	}
+
+	std, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := std.(type) {
+	case ed25519.PrivateKey:
+		std = &key
+	case interface{ Curve() elliptic.Curve }:
+		if key.Curve() != elliptic.P256() {
+			return nil, fmt.Errorf("unsupported curve %s", key.Curve().Params().Name)
+		}
+	}
+
+	priv, _, err := crypto.KeyPairFromStdKey(std)
+	if err != nil {
+		return nil, err
+	}
+
+	return priv, nil
+}
+
+// encryptPKCS8 encrypts a PrivateKeyInfo into an EncryptedPrivateKeyInfo.
+func encryptPKCS8(der []byte, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, pbkdf2SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(pbkdf2.Key(passphrase, salt, pbkdf2Iterations, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+
+	padding := aes.BlockSize - len(der)%aes.BlockSize
+	data := make([]byte, len(der)+padding)
+	copy(data, der)
+
+	for i := len(der); i < len(data); i++ {
+		data[i] = byte(padding)
+	}
+
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: pbkdf2Iterations,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ivParams, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParams}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algo:          pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: data,
+	})
+}
+
+func decryptPKCS8(der []byte, passphrase []byte) ([]byte, error) {
+	info := encryptedPrivateKeyInfo{}
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	}
+
+	if !info.Algo.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported encryption algorithm %s", info.Algo.Algorithm)
+	}
+
+	params := pbes2Params{}
+	if _, err := asn1.Unmarshal(info.Algo.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) || !params.EncryptionScheme.Algorithm.Equal(oidAES256CBC) {
+		return nil, fmt.Errorf("only PBKDF2 with AES-256-CBC is supported")
+	}
+
+	kdfParams := pbkdf2Params{}
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+		return nil, err
+	}
+
+	if !kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA256) {
+		return nil, fmt.Errorf("only HMAC-SHA256 is supported")
+	}
+
+	if kdfParams.IterationCount <= 0 || kdfParams.IterationCount > maxPBKDF2Iterations {
+		return nil, fmt.Errorf("invalid PBKDF2 iteration count %d", kdfParams.IterationCount)
+	}
+
+	iv := []byte{}
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+
+	data := info.EncryptedData
+	if len(iv) != aes.BlockSize || len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("invalid encrypted data")
+	}
+
+	block, err := aes.NewCipher(pbkdf2.Key(passphrase, kdfParams.Salt, kdfParams.IterationCount, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+
+	padding := int(out[len(out)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, fmt.Errorf("wrong passphrase")
+	}
+
+	for _, b := range out[len(out)-padding:] {
+		if int(b) != padding {
+			return nil, fmt.Errorf("wrong passphrase")
+		}
+	}
+
+	return out[:len(out)-padding], nil
+}
@@ -9,7 +9,6 @@ import (
 	"time"
 
 	"github.com/ipfs/go-cid"
-	coreiface "github.com/ipfs/kubo/core/coreiface"
 
 	"berty.tech/go-ipfs-log/accesscontroller"
 	"berty.tech/go-ipfs-log/entry"
@@ -32,7 +31,7 @@ type AppendOptions = iface.AppendOptions
 type SortFn = iface.EntrySortFn
 
 type IPFSLog struct {
-	Storage          coreiface.CoreAPI
+	Storage          iface.Storage
 	ID               string
 	AccessController accesscontroller.Interface
 	SortFn           iface.EntrySortFn
@@ -43,6 +42,7 @@ type IPFSLog struct {
 	Clock            iface.IPFSLogLamportClock
 	io               iface.IO
 	concurrency      uint
+	keyHistory       *identityprovider.KeyHistory
 	lock             sync.RWMutex
 }
 
@@ -93,14 +93,15 @@ func maxClockTimeForEntries(entries []iface.IPFSLogEntry, defValue int) int {
 //
 // Returns a log instance.
 //
-// ipfs is an instance of IPFS.
+// services is the block storage used to persist entries, see the storage
+// package for implementations backed by IPFS or by a local blockstore.
 //
 // identity is an instance of Identity and will be used to sign entries
 // Usually this should be a user id or similar.
 //
 // options.AccessController is an instance of accesscontroller.Interface,
 // which by default allows anyone to append to the IPFSLog.
-func NewLog(services coreiface.CoreAPI, identity *identityprovider.Identity, options *LogOptions) (*IPFSLog, error) {
+func NewLog(services iface.Storage, identity *identityprovider.Identity, options *LogOptions) (*IPFSLog, error) {
 	if services == nil {
 		return nil, errmsg.ErrIPFSNotDefined
 	}
@@ -152,6 +153,10 @@ func NewLog(services coreiface.CoreAPI, identity *identityprovider.Identity, opt
 		options.IO = io
 	}
 
+	if options.KeyHistory == nil {
+		options.KeyHistory = identityprovider.NewKeyHistory()
+	}
+
 	next := entry.NewOrderedMap()
 	for _, key := range options.Entries.Keys() {
 		e := options.Entries.UnsafeGet(key)
@@ -172,9 +177,15 @@ func NewLog(services coreiface.CoreAPI, identity *identityprovider.Identity, opt
 		Clock:            entry.NewLamportClock(identity.PublicKey, maxTime),
 		io:               options.IO,
 		concurrency:      options.Concurrency,
+		keyHistory:       options.KeyHistory,
 	}, nil
 }
 
+// KeyHistory returns the key statements of the identities of the log.
+func (l *IPFSLog) KeyHistory() *identityprovider.KeyHistory {
+	return l.keyHistory
+}
+
 func (l *IPFSLog) SetIdentity(identity *identityprovider.Identity) {
 	l.lock.Lock()
 	defer l.lock.Unlock()
@@ -301,6 +312,12 @@ func (l *IPFSLog) Has(c cid.Cid) bool {
 //
 // payload is the data that will be in the Entry
 func (l *IPFSLog) Append(ctx context.Context, payload []byte, opts *AppendOptions) (iface.IPFSLogEntry, error) {
+	return l.appendWithIO(ctx, payload, opts, l.io)
+}
+
+// appendWithIO appends an entry written using the given IO instead of the one
+// of the log.
+func (l *IPFSLog) appendWithIO(ctx context.Context, payload []byte, opts *AppendOptions, io iface.IO) (iface.IPFSLogEntry, error) {
 	l.lock.Lock()
 	defer l.lock.Unlock()
 
@@ -364,6 +381,15 @@ func (l *IPFSLog) Append(ctx context.Context, payload []byte, opts *AppendOption
 		}
 	}
 
+	// the key is checked before the entry is signed and stored
+	if err := l.keyHistory.Add(l.Identity); err != nil {
+		return nil, errmsg.ErrLogAppendDenied.Wrap(err)
+	}
+
+	if err := l.keyHistory.CheckKey(l.Identity, l.Identity.PublicKey, newTime); err != nil {
+		return nil, errmsg.ErrLogAppendDenied.Wrap(err)
+	}
+
 	// TODO: ensure port of ```Object.keys(Object.assign({}, this._headsIndex, references))``` is correctly implemented
 
 	// @TODO: Split Entry.create into creating object, checking permission, signing and then posting to IPFS
@@ -376,7 +402,7 @@ func (l *IPFSLog) Append(ctx context.Context, payload []byte, opts *AppendOption
 		Refs:    refs,
 	}, &iface.CreateEntryOptions{
 		Pin: opts.Pin,
-	}, l.io)
+	}, io)
 
 	if err != nil {
 		return nil, errmsg.ErrLogAppendFailed.Wrap(err)
@@ -399,6 +425,9 @@ func (l *IPFSLog) Append(ctx context.Context, payload []byte, opts *AppendOption
 
 type CanAppendContext struct {
 	log *IPFSLog
+
+	// history replaces the key history of the log while joining entries.
+	history *identityprovider.KeyHistory
 }
 
 func (c *CanAppendContext) GetLogEntries() []accesscontroller.LogEntry {
@@ -412,6 +441,14 @@ func (c *CanAppendContext) GetLogEntries() []accesscontroller.LogEntry {
 	return entries
 }
 
+func (c *CanAppendContext) GetKeyHistory() *identityprovider.KeyHistory {
+	if c.history != nil {
+		return c.history
+	}
+
+	return c.log.keyHistory
+}
+
 /* Iterator Provides entries values on a channel */
 func (l *IPFSLog) Iterator(options *IteratorOptions, output chan<- iface.IPFSLogEntry) error {
 	amount := -1
@@ -529,6 +566,19 @@ func (l *IPFSLog) Join(otherLog iface.IPFSLog, size int) (iface.IPFSLog, error)
 
 	newItems := difference(otherLog.GetEntries(), otherLog.RawHeads().Slice(), l)
 
+	// statements are recorded first in a copy of the history, so revocations
+	// apply to the entries they are joined with. They are only kept once
+	// every entry has been verified.
+	history := l.keyHistory.Copy()
+
+	for _, k := range newItems.Keys() {
+		if e := newItems.UnsafeGet(k); e != nil && e.Defined() {
+			if err := history.Add(e.GetIdentity()); err != nil {
+				return nil, errmsg.ErrLogJoinFailed.Wrap(err)
+			}
+		}
+	}
+
 	wg := &sync.WaitGroup{}
 	wg.Add(newItems.Len())
 	var err error
@@ -544,15 +594,10 @@ func (l *IPFSLog) Join(otherLog iface.IPFSLog, size int) (iface.IPFSLog, error)
 				return
 			}
 
-			if inErr := l.AccessController.CanAppend(e, l.Identity.Provider, &CanAppendContext{log: l}); inErr != nil {
+			if inErr := l.verifyEntry(e, newItems, history); inErr != nil {
 				err = inErr
 				return
 			}
-
-			if inErr := e.Verify(l.Identity.Provider, l.IO()); inErr != nil {
-				err = errmsg.ErrSigNotVerified.Wrap(inErr)
-				return
-			}
 		}(k)
 	}
 
@@ -561,6 +606,12 @@ func (l *IPFSLog) Join(otherLog iface.IPFSLog, size int) (iface.IPFSLog, error)
 		return nil, errmsg.ErrLogJoinFailed.Wrap(err)
 	}
 
+	for _, k := range newItems.Keys() {
+		if err := l.keyHistory.Add(newItems.UnsafeGet(k).GetIdentity()); err != nil {
+			return nil, errmsg.ErrLogJoinFailed.Wrap(err)
+		}
+	}
+
 	for _, k := range newItems.Keys() {
 		e := newItems.UnsafeGet(k)
 		for _, next := range e.GetNext() {
@@ -617,6 +668,53 @@ func (l *IPFSLog) Join(otherLog iface.IPFSLog, size int) (iface.IPFSLog, error)
 	return l, nil
 }
 
+// verifyEntry checks that an entry of another log can be added to the log,
+// as done when joining it. Its next entries are looked up in entries, which
+// may be nil, and in the log. Its key is checked using history.
+func (l *IPFSLog) verifyEntry(e iface.IPFSLogEntry, entries iface.IPFSLogOrderedEntries, history *identityprovider.KeyHistory) error {
+	if err := l.AccessController.CanAppend(e, l.Identity.Provider, &CanAppendContext{log: l, history: history}); err != nil {
+		return err
+	}
+
+	if err := e.Verify(l.Identity.Provider, l.IO()); err != nil {
+		return errmsg.ErrSigNotVerified.Wrap(err)
+	}
+
+	if err := l.checkClock(e, entries); err != nil {
+		return err
+	}
+
+	if err := history.CheckKey(e.GetIdentity(), e.GetKey(), e.GetClock().GetTime()); err != nil {
+		return errmsg.ErrSigNotVerified.Wrap(err)
+	}
+
+	return nil
+}
+
+// checkClock checks that the clock time of an entry isn't lower than the ones
+// of its next entries, the expiry of certificates and the revocations of keys
+// rely on it. Concurrent entries may share a time, an entry following one
+// past a given time is past it too. Next entries which aren't known aren't
+// checked.
+func (l *IPFSLog) checkClock(e iface.IPFSLogEntry, entries iface.IPFSLogOrderedEntries) error {
+	for _, n := range e.GetNext() {
+		next, ok := l.Entries.Get(n.String())
+		if !ok && entries != nil {
+			next, ok = entries.Get(n.String())
+		}
+
+		if !ok || next == nil || next.GetClock() == nil {
+			continue
+		}
+
+		if e.GetClock().GetTime() < next.GetClock().GetTime() {
+			return errmsg.ErrEntryClockDecreasing
+		}
+	}
+
+	return nil
+}
+
 func difference(entriesA iface.IPFSLogOrderedEntries, headsA []iface.IPFSLogEntry, logB *IPFSLog) iface.IPFSLogOrderedEntries {
 	if entriesA.Len() == 0 || len(headsA) == 0 || logB == nil {
 		return entry.NewOrderedMap()
@@ -733,7 +831,7 @@ func (l *IPFSLog) ToMultihash(ctx context.Context) (cid.Cid, error) {
 // NewFromMultihash Creates a IPFSLog from a hash
 //
 // Creating a log from a hash will retrieve entries from IPFS, thus causing side effects
-func NewFromMultihash(ctx context.Context, services coreiface.CoreAPI, identity *identityprovider.Identity, hash cid.Cid, logOptions *LogOptions, fetchOptions *FetchOptions) (*IPFSLog, error) {
+func NewFromMultihash(ctx context.Context, services iface.Storage, identity *identityprovider.Identity, hash cid.Cid, logOptions *LogOptions, fetchOptions *FetchOptions) (*IPFSLog, error) {
 	if services == nil {
 		return nil, errmsg.ErrIPFSNotDefined
 	}
@@ -799,7 +897,7 @@ func NewFromMultihash(ctx context.Context, services coreiface.CoreAPI, identity
 // NewFromEntryHash Creates a IPFSLog from a hash of an Entry
 //
 // Creating a log from a hash will retrieve entries from IPFS, thus causing side effects
-func NewFromEntryHash(ctx context.Context, services coreiface.CoreAPI, identity *identityprovider.Identity, hash cid.Cid, logOptions *LogOptions, fetchOptions *FetchOptions) (*IPFSLog, error) {
+func NewFromEntryHash(ctx context.Context, services iface.Storage, identity *identityprovider.Identity, hash cid.Cid, logOptions *LogOptions, fetchOptions *FetchOptions) (*IPFSLog, error) {
 	if logOptions == nil {
 		return nil, errmsg.ErrLogOptionsNotDefined
 	}
@@ -842,7 +940,7 @@ func NewFromEntryHash(ctx context.Context, services coreiface.CoreAPI, identity
 // NewFromJSON Creates a IPFSLog from a JSON Snapshot
 //
 // Creating a log from a JSON Snapshot will retrieve entries from IPFS, thus causing side effects
-func NewFromJSON(ctx context.Context, services coreiface.CoreAPI, identity *identityprovider.Identity, jsonLog *iface.JSONLog, logOptions *LogOptions, fetchOptions *entry.FetchOptions) (*IPFSLog, error) {
+func NewFromJSON(ctx context.Context, services iface.Storage, identity *identityprovider.Identity, jsonLog *iface.JSONLog, logOptions *LogOptions, fetchOptions *entry.FetchOptions) (*IPFSLog, error) {
 	if logOptions == nil {
 		return nil, errmsg.ErrLogOptionsNotDefined
 	}
@@ -889,7 +987,7 @@ func NewFromJSON(ctx context.Context, services coreiface.CoreAPI, identity *iden
 // NewFromEntry Creates a IPFSLog from an Entry
 //
 // Creating a log from an entry will retrieve entries from IPFS, thus causing side effects
-func NewFromEntry(ctx context.Context, services coreiface.CoreAPI, identity *identityprovider.Identity, sourceEntries []iface.IPFSLogEntry, logOptions *LogOptions, fetchOptions *entry.FetchOptions) (*IPFSLog, error) {
+func NewFromEntry(ctx context.Context, services iface.Storage, identity *identityprovider.Identity, sourceEntries []iface.IPFSLogEntry, logOptions *LogOptions, fetchOptions *entry.FetchOptions) (*IPFSLog, error) {
 	if logOptions == nil {
 		return nil, errmsg.ErrLogOptionsNotDefined
 	}
@@ -1002,3 +1100,4 @@ func (l *IPFSLog) sortedHeads(heads []iface.IPFSLogEntry) iface.IPFSLogOrderedEn
 }
 
 var _ iface.IPFSLog = (*IPFSLog)(nil)
+var _ accesscontroller.KeyHistoryContext = (*CanAppendContext)(nil)
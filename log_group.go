@@ -0,0 +1,632 @@
+package ipfslog // import "berty.tech/go-ipfs-log"
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+
+	"berty.tech/go-ipfs-log/enc"
+	"berty.tech/go-ipfs-log/entry"
+	"berty.tech/go-ipfs-log/errmsg"
+	"berty.tech/go-ipfs-log/identityprovider"
+	"berty.tech/go-ipfs-log/iface"
+	"berty.tech/go-ipfs-log/io/cbor"
+)
+
+// GroupEpochType is the type of the payload of the entries publishing a new
+// group key epoch.
+const GroupEpochType = "ipfslog/group-epoch"
+
+// GroupMember is a member of an epoch, the X25519 key used to seal the key of
+// the epoch for it is bound to its identity.
+type GroupMember struct {
+	// ID is the ID of the identity of the member.
+	ID string `json:"id"`
+
+	// Key is the hex encoded X25519 public key of the member.
+	Key string `json:"key"`
+}
+
+// NewGroupMember binds the X25519 public key of a member to its identity.
+func NewGroupMember(id string, key *enc.BoxPublicKey) *GroupMember {
+	return &GroupMember{ID: id, Key: hex.EncodeToString(key[:])}
+}
+
+// PublicKey returns the X25519 public key of the member.
+func (m *GroupMember) PublicKey() (*enc.BoxPublicKey, error) {
+	raw, err := hex.DecodeString(m.Key)
+	if err != nil || len(raw) != enc.BoxKeySize {
+		return nil, errmsg.ErrGroupKeyFailed.Wrap(enc.ErrInvalidKey)
+	}
+
+	key := &enc.BoxPublicKey{}
+	copy(key[:], raw)
+
+	return key, nil
+}
+
+// GroupEpoch is the payload of an epoch entry. The members are stored in
+// clear so that every member can check who was allowed to publish the
+// epoch, only the key is sealed for each of them.
+type GroupEpoch struct {
+	Type  string `json:"type"`
+	Epoch uint64 `json:"epoch"`
+
+	// Previous is the key ID of the epoch this epoch follows, empty for the
+	// first epoch.
+	Previous string `json:"previous,omitempty"`
+
+	Members   []*GroupMember `json:"members"`
+	Algorithm string         `json:"alg"`
+
+	// Keys are the base64 encoded sealed boxes of the key of the epoch, one
+	// for each member.
+	Keys []string `json:"keys"`
+}
+
+// KeyID returns the ID under which the entries of the epoch record their
+// key, it tells apart epochs concurrently created with the same number.
+func (e *GroupEpoch) KeyID() string {
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+
+	return fmt.Sprintf("epoch-%d-%x", e.Epoch, sum[:8])
+}
+
+func (e *GroupEpoch) hasMember(id string) bool {
+	for _, m := range e.Members {
+		if m.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseGroupEpoch returns the epoch published by an entry, if any. The epoch
+// must still be checked against the epochs it follows.
+func ParseGroupEpoch(e iface.IPFSLogEntry) (*GroupEpoch, bool) {
+	epoch := &GroupEpoch{}
+	if err := json.Unmarshal(e.GetPayload(), epoch); err != nil {
+		return nil, false
+	}
+
+	if epoch.Type != GroupEpochType || epoch.Epoch == 0 || len(epoch.Members) != len(epoch.Keys) {
+		return nil, false
+	}
+
+	return epoch, true
+}
+
+// GroupOptions defines how the entries of a group are written.
+type GroupOptions struct {
+	// IO is the IO the group IO is built upon, defaults to CBOR. Its keys
+	// are ignored.
+	IO *cbor.IOCbor
+
+	// Algorithm of the epoch keys, defaults to XChaCha20-Poly1305.
+	Algorithm string
+
+	// Admins are the IDs of the identities allowed to publish any epoch,
+	// the first epoch must be published by one of them.
+	Admins []string
+}
+
+// groupEpoch is an epoch along with the ID of the identity which published
+// it.
+type groupEpoch struct {
+	*GroupEpoch
+
+	keyID  string
+	author string
+}
+
+// Group manages the keys shared by the members of a log
+//
+// Each epoch key is published in an entry of the log sealed for the members
+// of the epoch, the payload of the other entries is encrypted using the key
+// of the latest epoch. Members are removed by starting a new epoch without
+// them, they can't read the entries written afterwards.
+//
+// An epoch is only accepted if it follows the previous one and is published
+// by one of its members or by an admin. When epochs with the same number
+// follow the same epoch, the one removing the author of the other wins, then
+// the one published by an admin, then the one already accepted, so that a
+// removed member can't replace the epoch removing it.
+//
+// Links are left in clear so entries can be fetched before their epoch key
+// is known.
+type Group struct {
+	lock sync.RWMutex
+
+	member    *enc.BoxKeyPair
+	admins    map[string]struct{}
+	algorithm string
+
+	// keys holds the keys of every accepted epoch to read the entries,
+	// writeKeys the key of the current epoch to write them.
+	keys      *enc.KeyRing
+	writeKeys *enc.KeyRing
+
+	epochs  map[string]*groupEpoch
+	chain   []*groupEpoch
+	current *GroupEpoch
+
+	base    *cbor.IOCbor
+	writeIO *cbor.IOCbor
+}
+
+// NewGroup creates the group keys of a member, identified by its key pair.
+func NewGroup(member *enc.BoxKeyPair, options *GroupOptions) (*Group, error) {
+	if member == nil {
+		return nil, errmsg.ErrGroupKeyFailed.Wrap(enc.ErrInvalidKey)
+	}
+
+	if options == nil || len(options.Admins) == 0 {
+		return nil, errmsg.ErrGroupKeyFailed.Wrap(fmt.Errorf("no admins"))
+	}
+
+	base := options.IO
+	if base == nil {
+		io, err := cbor.IO(&entry.Entry{}, &entry.LamportClock{})
+		if err != nil {
+			return nil, err
+		}
+
+		base = io
+	}
+
+	algorithm := options.Algorithm
+	if algorithm == "" {
+		algorithm = enc.AlgorithmXChaCha20Poly1305
+	}
+
+	if _, err := enc.NewSharedKey(algorithm, make([]byte, enc.SecretBoxKeySize)); err != nil {
+		return nil, errmsg.ErrGroupKeyFailed.Wrap(err)
+	}
+
+	admins := map[string]struct{}{}
+	for _, id := range options.Admins {
+		admins[id] = struct{}{}
+	}
+
+	writeKeys := enc.NewKeyRing()
+
+	return &Group{
+		member:    member,
+		admins:    admins,
+		algorithm: algorithm,
+		keys:      enc.NewKeyRing(),
+		writeKeys: writeKeys,
+		epochs:    map[string]*groupEpoch{},
+		base:      base,
+		writeIO:   base.ApplyOptions(&cbor.Options{PayloadKeyRing: writeKeys}),
+	}, nil
+}
+
+// IO returns the IO of the logs of the group, it decrypts the entries whose
+// epoch key is known. The epochs are learned using Sync.
+//
+// Entries can only be appended once an epoch is known, either started with
+// Rotate or learned with Sync, until then appending fails with
+// errmsg.ErrGroupNoEpoch.
+func (g *Group) IO() iface.IO {
+	return &groupIO{group: g}
+}
+
+// Epoch returns the latest epoch whose key is known to the member, 0 if none.
+func (g *Group) Epoch() uint64 {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+
+	if g.current == nil {
+		return 0
+	}
+
+	return g.current.Epoch
+}
+
+// Members returns the members of the latest epoch whose key is known to the
+// member.
+func (g *Group) Members() []*GroupMember {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+
+	if g.current == nil {
+		return nil
+	}
+
+	return append([]*GroupMember(nil), g.current.Members...)
+}
+
+// Rotate starts a new epoch for the given members, publishing its key in l,
+// which must use the IO of the group. The identity of l must be a member of
+// the latest epoch or an admin.
+func (g *Group) Rotate(ctx context.Context, l *IPFSLog, members []*GroupMember, opts *AppendOptions) (iface.IPFSLogEntry, error) {
+	if len(members) == 0 {
+		return nil, errmsg.ErrGroupKeyFailed.Wrap(fmt.Errorf("no members"))
+	}
+
+	g.lock.RLock()
+	var previous *groupEpoch
+	if len(g.chain) > 0 {
+		previous = g.chain[len(g.chain)-1]
+	}
+	allowed := g.allowed(l.Identity.ID, previous)
+	g.lock.RUnlock()
+
+	if !allowed {
+		return nil, errmsg.ErrGroupKeyFailed.Wrap(fmt.Errorf("not allowed to publish an epoch"))
+	}
+
+	key := make([]byte, enc.SecretBoxKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, errmsg.ErrGroupKeyFailed.Wrap(err)
+	}
+
+	epoch := &GroupEpoch{
+		Type:      GroupEpochType,
+		Epoch:     1,
+		Members:   members,
+		Algorithm: g.algorithm,
+	}
+
+	if previous != nil {
+		epoch.Epoch = previous.Epoch + 1
+		epoch.Previous = previous.keyID
+	}
+
+	recipients := make([]*enc.BoxPublicKey, len(members))
+	for i, m := range members {
+		if m == nil {
+			return nil, errmsg.ErrGroupKeyFailed.Wrap(enc.ErrInvalidKey)
+		}
+
+		publicKey, err := m.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+
+		recipients[i] = publicKey
+	}
+
+	sealed, err := enc.SealForRecipients(key, recipients)
+	if err != nil {
+		return nil, errmsg.ErrGroupKeyFailed.Wrap(err)
+	}
+
+	for _, s := range sealed {
+		epoch.Keys = append(epoch.Keys, base64.StdEncoding.EncodeToString(s))
+	}
+
+	payload, err := json.Marshal(epoch)
+	if err != nil {
+		return nil, errmsg.ErrGroupKeyFailed.Wrap(errmsg.ErrJSONSerializationFailed.Wrap(err))
+	}
+
+	e, err := l.appendWithIO(ctx, payload, opts, g.base)
+	if err != nil {
+		return nil, errmsg.ErrGroupKeyFailed.Wrap(err)
+	}
+
+	if err := g.learn(e); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// AddMember starts a new epoch including member, which can't read the
+// entries of the previous epochs.
+func (g *Group) AddMember(ctx context.Context, l *IPFSLog, member *GroupMember, opts *AppendOptions) (iface.IPFSLogEntry, error) {
+	if member == nil {
+		return nil, errmsg.ErrGroupKeyFailed.Wrap(enc.ErrInvalidKey)
+	}
+
+	members := g.Members()
+
+	for _, m := range members {
+		if m.ID == member.ID {
+			return nil, errmsg.ErrGroupKeyFailed.Wrap(fmt.Errorf("already a member"))
+		}
+	}
+
+	return g.Rotate(ctx, l, append(members, member), opts)
+}
+
+// RemoveMember starts a new epoch excluding the member with the given
+// identity ID.
+func (g *Group) RemoveMember(ctx context.Context, l *IPFSLog, id string, opts *AppendOptions) (iface.IPFSLogEntry, error) {
+	members := g.Members()
+
+	remaining := make([]*GroupMember, 0, len(members))
+	for _, m := range members {
+		if m.ID != id {
+			remaining = append(remaining, m)
+		}
+	}
+
+	if len(remaining) == len(members) {
+		return nil, errmsg.ErrGroupKeyFailed.Wrap(fmt.Errorf("not a member"))
+	}
+
+	return g.Rotate(ctx, l, remaining, opts)
+}
+
+// Sync learns the epoch keys published in l and decrypts the payloads of the
+// entries read before their epoch key was known, such as after a join. Only
+// the epoch entries passing the checks of a join are considered, entries
+// loaded without being joined are checked again.
+func (g *Group) Sync(l *IPFSLog) error {
+	values := l.Values().Slice()
+
+	for _, e := range values {
+		if _, ok := ParseGroupEpoch(e); !ok {
+			continue
+		}
+
+		if err := l.verifyEntry(e, nil, l.keyHistory); err != nil {
+			continue
+		}
+
+		if err := g.learn(e); err != nil {
+			return err
+		}
+	}
+
+	for _, e := range values {
+		if err := g.open(e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// learn records the epoch published by a verified entry and selects the
+// epochs accepted by the group again.
+func (g *Group) learn(e iface.IPFSLogEntry) error {
+	epoch, ok := ParseGroupEpoch(e)
+	if !ok || e.GetIdentity() == nil {
+		return nil
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	keyID := epoch.KeyID()
+	if _, ok := g.epochs[keyID]; ok {
+		return nil
+	}
+
+	g.epochs[keyID] = &groupEpoch{GroupEpoch: epoch, keyID: keyID, author: e.GetIdentity().ID}
+
+	return g.selectEpochs()
+}
+
+// selectEpochs selects the accepted epoch of each number, starting from the
+// first one, and updates the keys of the member.
+func (g *Group) selectEpochs() error {
+	ids := make([]string, 0, len(g.epochs))
+	for id := range g.epochs {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	var (
+		chain    []*groupEpoch
+		previous *groupEpoch
+	)
+
+	for number := uint64(1); ; number++ {
+		var selected *groupEpoch
+
+		// the epoch accepted before stays unless it is replaced
+		if int(number) <= len(g.chain) && g.follows(g.chain[number-1], previous) {
+			selected = g.chain[number-1]
+		}
+
+		kept := selected != nil
+
+		for _, id := range ids {
+			candidate := g.epochs[id]
+			if candidate.Epoch != number || candidate == selected || !g.follows(candidate, previous) {
+				continue
+			}
+
+			if err := g.addKey(candidate); err != nil {
+				return err
+			}
+
+			if selected == nil || g.replaces(candidate, selected, previous, kept) {
+				selected, kept = candidate, false
+			}
+		}
+
+		if selected == nil {
+			break
+		}
+
+		if err := g.addKey(selected); err != nil {
+			return err
+		}
+
+		chain = append(chain, selected)
+		previous = selected
+	}
+
+	g.chain = chain
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		key, ok := g.keys.Get(chain[i].keyID)
+		if !ok {
+			continue
+		}
+
+		if g.current == chain[i].GroupEpoch {
+			return nil
+		}
+
+		g.current = chain[i].GroupEpoch
+
+		if err := g.writeKeys.Rotate(chain[i].keyID, key); err != nil {
+			return errmsg.ErrGroupKeyFailed.Wrap(err)
+		}
+
+		return nil
+	}
+
+	return nil
+}
+
+// allowed checks whether an identity can publish the epoch following
+// previous, nil for the first epoch.
+func (g *Group) allowed(id string, previous *groupEpoch) bool {
+	if _, ok := g.admins[id]; ok {
+		return true
+	}
+
+	return previous != nil && previous.hasMember(id)
+}
+
+// follows checks whether an epoch follows previous and is published by an
+// identity allowed to.
+func (g *Group) follows(epoch *groupEpoch, previous *groupEpoch) bool {
+	if previous == nil {
+		return epoch.Epoch == 1 && epoch.Previous == "" && g.allowed(epoch.author, nil)
+	}
+
+	return epoch.Epoch == previous.Epoch+1 && epoch.Previous == previous.keyID && g.allowed(epoch.author, previous)
+}
+
+// replaces checks whether an epoch is accepted instead of the selected one,
+// both following previous. The rules don't depend on values the authors
+// choose freely.
+func (g *Group) replaces(epoch *groupEpoch, selected *groupEpoch, previous *groupEpoch, kept bool) bool {
+	removes := func(e *groupEpoch, id string) bool {
+		return previous != nil && previous.hasMember(id) && !e.hasMember(id)
+	}
+
+	if removes(epoch, selected.author) != removes(selected, epoch.author) {
+		return removes(epoch, selected.author)
+	}
+
+	_, epochAdmin := g.admins[epoch.author]
+	_, selectedAdmin := g.admins[selected.author]
+
+	if epochAdmin != selectedAdmin {
+		return epochAdmin
+	}
+
+	if kept {
+		return false
+	}
+
+	return epoch.keyID > selected.keyID
+}
+
+// addKey adds the key of an epoch to the ring if it is sealed for the member.
+func (g *Group) addKey(epoch *groupEpoch) error {
+	if _, ok := g.keys.Get(epoch.keyID); ok {
+		return nil
+	}
+
+	sealed := make([][]byte, len(epoch.Keys))
+	for i, k := range epoch.Keys {
+		s, err := base64.StdEncoding.DecodeString(k)
+		if err != nil {
+			return nil
+		}
+
+		sealed[i] = s
+	}
+
+	raw, err := enc.OpenAsRecipient(sealed, g.member)
+	if err != nil {
+		return nil
+	}
+
+	key, err := enc.NewSharedKey(epoch.Algorithm, raw)
+	if err != nil {
+		return errmsg.ErrGroupKeyFailed.Wrap(err)
+	}
+
+	if err := g.keys.Add(epoch.keyID, key); err != nil {
+		return errmsg.ErrGroupKeyFailed.Wrap(err)
+	}
+
+	return nil
+}
+
+// open decrypts the payload of an entry if its epoch key is known.
+func (g *Group) open(e iface.IPFSLogEntry) error {
+	id, ok := e.GetAdditionalData()[iface.KeyEncryptedPayloadKeyID]
+	if !ok {
+		return nil
+	}
+
+	if _, ok := g.keys.Get(id); !ok {
+		return nil
+	}
+
+	if err := cbor.DecryptEntryPayload(g.keys, e); err != nil {
+		return errmsg.ErrGroupKeyFailed.Wrap(err)
+	}
+
+	return nil
+}
+
+type groupIO struct {
+	group *Group
+}
+
+func (i *groupIO) Write(ctx context.Context, storage iface.Storage, obj interface{}, opts *iface.WriteOpts) (cid.Cid, error) {
+	return i.group.writeIO.Write(ctx, storage, obj, opts)
+}
+
+func (i *groupIO) Read(ctx context.Context, storage iface.Storage, contentIdentifier cid.Cid) (format.Node, error) {
+	return i.group.writeIO.Read(ctx, storage, contentIdentifier)
+}
+
+func (i *groupIO) DecodeRawEntry(node format.Node, hash cid.Cid, p identityprovider.Interface) (iface.IPFSLogEntry, error) {
+	e, err := i.group.base.DecodeRawEntry(node, hash, p)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := i.group.open(e); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func (i *groupIO) DecodeRawJSONLog(node format.Node) (*iface.JSONLog, error) {
+	return i.group.writeIO.DecodeRawJSONLog(node)
+}
+
+func (i *groupIO) PreSign(e iface.IPFSLogEntry) (iface.IPFSLogEntry, error) {
+	// the payload of the epochs is left in clear
+	if _, ok := ParseGroupEpoch(e); ok {
+		return e, nil
+	}
+
+	// entries being verified are already encrypted
+	if _, ok := e.GetAdditionalData()[iface.KeyEncryptedPayload]; !ok && i.group.Epoch() == 0 {
+		return nil, errmsg.ErrGroupNoEpoch
+	}
+
+	return i.group.writeIO.PreSign(e)
+}
+
+var _ iface.IOPreSign = (*groupIO)(nil)
@@ -5,8 +5,6 @@ import (
 	"fmt"
 	"time"
 
-	coreiface "github.com/ipfs/kubo/core/coreiface"
-
 	"berty.tech/go-ipfs-log/iface"
 
 	"berty.tech/go-ipfs-log/entry/sorting"
@@ -28,7 +26,7 @@ type FetchOptions struct {
 	SortFn        iface.EntrySortFn
 }
 
-func toMultihash(ctx context.Context, services coreiface.CoreAPI, log *IPFSLog) (cid.Cid, error) {
+func toMultihash(ctx context.Context, services iface.Storage, log *IPFSLog) (cid.Cid, error) {
 	if log.heads.Len() == 0 {
 		return cid.Undef, errmsg.ErrEmptyLogSerialization
 	}
@@ -36,7 +34,7 @@ func toMultihash(ctx context.Context, services coreiface.CoreAPI, log *IPFSLog)
 	return log.io.Write(ctx, services, log.ToJSONLog(), nil)
 }
 
-func fromMultihash(ctx context.Context, services coreiface.CoreAPI, hash cid.Cid, options *FetchOptions, io iface.IO) (*Snapshot, error) {
+func fromMultihash(ctx context.Context, services iface.Storage, hash cid.Cid, options *FetchOptions, io iface.IO) (*Snapshot, error) {
 	result, err := io.Read(ctx, services, hash)
 	if err != nil {
 		return nil, errmsg.ErrCBOROperationFailed.Wrap(err)
@@ -73,6 +71,12 @@ func fromMultihash(ctx context.Context, services coreiface.CoreAPI, hash cid.Cid
 	for _, e := range entries {
 		for _, h := range logHeads.Heads {
 			if h.String() == e.GetHash().String() {
+				// heads of another log can't be substituted to the ones
+				// of the manifest
+				if e.GetLogID() != logHeads.ID {
+					return nil, errmsg.ErrHeadsMismatch.Wrap(fmt.Errorf("entry %s belongs to log %s", h, e.GetLogID()))
+				}
+
 				heads = append(heads, e.GetHash())
 			}
 		}
@@ -85,7 +89,7 @@ func fromMultihash(ctx context.Context, services coreiface.CoreAPI, hash cid.Cid
 	}, nil
 }
 
-func fromEntryHash(ctx context.Context, services coreiface.CoreAPI, hashes []cid.Cid, options *FetchOptions, io iface.IO) ([]iface.IPFSLogEntry, error) {
+func fromEntryHash(ctx context.Context, services iface.Storage, hashes []cid.Cid, options *FetchOptions, io iface.IO) ([]iface.IPFSLogEntry, error) {
 	if services == nil {
 		return nil, errmsg.ErrIPFSNotDefined
 	}
@@ -124,7 +128,7 @@ func fromEntryHash(ctx context.Context, services coreiface.CoreAPI, hashes []cid
 	return entries, nil
 }
 
-func fromJSON(ctx context.Context, services coreiface.CoreAPI, jsonLog *iface.JSONLog, options *iface.FetchOptions) (*Snapshot, error) {
+func fromJSON(ctx context.Context, services iface.Storage, jsonLog *iface.JSONLog, options *iface.FetchOptions) (*Snapshot, error) {
 	if services == nil {
 		return nil, errmsg.ErrIPFSNotDefined
 	}
@@ -154,7 +158,7 @@ func fromJSON(ctx context.Context, services coreiface.CoreAPI, jsonLog *iface.JS
 	}, nil
 }
 
-func fromEntry(ctx context.Context, services coreiface.CoreAPI, sourceEntries []iface.IPFSLogEntry, options *iface.FetchOptions) (*Snapshot, error) {
+func fromEntry(ctx context.Context, services iface.Storage, sourceEntries []iface.IPFSLogEntry, options *iface.FetchOptions) (*Snapshot, error) {
 	if services == nil {
 		return nil, errmsg.ErrIPFSNotDefined
 	}
@@ -0,0 +1,305 @@
+package ipfslog // import "berty.tech/go-ipfs-log"
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+
+	"berty.tech/go-ipfs-log/accesscontroller"
+	"berty.tech/go-ipfs-log/entry"
+	"berty.tech/go-ipfs-log/errmsg"
+	"berty.tech/go-ipfs-log/identityprovider"
+	"berty.tech/go-ipfs-log/iface"
+	"berty.tech/go-ipfs-log/io/cbor"
+)
+
+// MigrationAttestationType is the type of the payload of attestation entries
+// appended to a migrated log.
+const MigrationAttestationType = "ipfslog/migration"
+
+const defaultAttestationBatchSize = 256
+
+// MigrateOptions defines how a log is migrated.
+type MigrateOptions struct {
+	// IO is the format of the migrated log, defaults to CBOR.
+	IO iface.IO
+
+	// Signers are the identities whose private keys are available, entries
+	// authored by one of them are re-signed with its key. Entries authored by
+	// anyone else are re-signed by the migrating identity and listed in an
+	// attestation entry.
+	Signers []*identityprovider.Identity
+
+	AccessController accesscontroller.Interface
+	SortFn           iface.EntrySortFn
+	Pin              bool
+
+	// AttestationBatchSize is the maximum number of migrated entries listed in
+	// a single attestation entry, defaults to 256.
+	AttestationBatchSize int
+}
+
+// MigrationResult is the outcome of a log migration.
+type MigrationResult struct {
+	Log *IPFSLog
+
+	// CIDs maps the CIDs of the source entries to the CIDs of the migrated
+	// entries.
+	CIDs map[cid.Cid]cid.Cid
+
+	// Attestations are the entries appended for entries which could not be
+	// re-signed by their original author.
+	Attestations []iface.IPFSLogEntry
+}
+
+// MigratedEntry describes an entry re-signed by the migrating identity,
+// keeping the original key and signature so the source entry can still be
+// verified.
+type MigratedEntry struct {
+	From cid.Cid `json:"from"`
+	To   cid.Cid `json:"to"`
+	V    uint64  `json:"v"`
+	Key  string  `json:"key"`
+	Sig  string  `json:"sig"`
+}
+
+// MigrationAttestation is the payload of an attestation entry.
+type MigrationAttestation struct {
+	Type    string          `json:"type"`
+	Entries []MigratedEntry `json:"entries"`
+}
+
+// ParseMigrationAttestation returns the attestation held by an entry, if any.
+func ParseMigrationAttestation(e iface.IPFSLogEntry) (*MigrationAttestation, bool) {
+	attestation := &MigrationAttestation{}
+	if err := json.Unmarshal(e.GetPayload(), attestation); err != nil {
+		return nil, false
+	}
+
+	if attestation.Type != MigrationAttestationType {
+		return nil, false
+	}
+
+	return attestation, true
+}
+
+// Migrate re-creates the entries of a log in another format
+//
+// Entries are written parents first using options.IO, their next and refs
+// pointing to the migrated entries. Payloads, log ID and clocks are kept.
+//
+// identity is the identity of the migrated log, it signs the entries whose
+// author is not part of options.Signers and the attestation entries.
+//
+// Returns the migrated log and the mapping from the source CIDs.
+func Migrate(ctx context.Context, source Log, services iface.Storage, identity *identityprovider.Identity, options *MigrateOptions) (*MigrationResult, error) {
+	if source == nil {
+		return nil, errmsg.ErrLogMigrationFailed.Wrap(fmt.Errorf("missing source log"))
+	}
+
+	if services == nil {
+		return nil, errmsg.ErrIPFSNotDefined
+	}
+
+	if identity == nil {
+		return nil, errmsg.ErrIdentityNotDefined
+	}
+
+	if options == nil {
+		options = &MigrateOptions{}
+	}
+
+	if options.IO == nil {
+		io, err := cbor.IO(&entry.Entry{}, &entry.LamportClock{})
+		if err != nil {
+			return nil, err
+		}
+
+		options.IO = io
+	}
+
+	batchSize := options.AttestationBatchSize
+	if batchSize <= 0 {
+		batchSize = defaultAttestationBatchSize
+	}
+
+	signers := map[string]*identityprovider.Identity{
+		hex.EncodeToString(identity.PublicKey): identity,
+	}
+	for _, s := range options.Signers {
+		signers[hex.EncodeToString(s.PublicKey)] = s
+	}
+
+	l, err := NewLog(services, identity, &LogOptions{
+		ID:               source.GetID(),
+		AccessController: options.AccessController,
+		SortFn:           options.SortFn,
+		IO:               options.IO,
+	})
+	if err != nil {
+		return nil, errmsg.ErrLogMigrationFailed.Wrap(err)
+	}
+
+	cids := map[cid.Cid]cid.Cid{}
+	mapCIDs := func(in []cid.Cid) []cid.Cid {
+		out := make([]cid.Cid, len(in))
+		for i, c := range in {
+			if mapped, ok := cids[c]; ok {
+				out[i] = mapped
+			} else {
+				// outside of the source log, left untouched
+				out[i] = c
+			}
+		}
+
+		return out
+	}
+
+	var attested []MigratedEntry
+
+	for _, e := range migrationOrder(source.Values().Slice()) {
+		signer, ok := signers[hex.EncodeToString(e.GetKey())]
+		if !ok {
+			signer = identity
+		}
+
+		migrated, err := entry.CreateEntryWithIO(ctx, services, signer, &entry.Entry{
+			LogID:          e.GetLogID(),
+			Payload:        e.GetPayload(),
+			Next:           mapCIDs(e.GetNext()),
+			Refs:           mapCIDs(e.GetRefs()),
+			Clock:          entry.CopyLamportClock(e.GetClock()),
+			AdditionalData: withoutEncryptedValues(e.GetAdditionalData()),
+		}, &iface.CreateEntryOptions{
+			Pin: options.Pin,
+		}, options.IO)
+		if err != nil {
+			return nil, errmsg.ErrLogMigrationFailed.Wrap(err)
+		}
+
+		if err := l.AccessController.CanAppend(migrated, identity.Provider, &CanAppendContext{log: l}); err != nil {
+			return nil, errmsg.ErrLogMigrationFailed.Wrap(errmsg.ErrLogAppendDenied.Wrap(err))
+		}
+
+		cids[e.GetHash()] = migrated.GetHash()
+
+		l.Entries.Set(migrated.GetHash().String(), migrated)
+		for _, n := range migrated.GetNext() {
+			l.Next.Set(n.String(), migrated)
+		}
+
+		if !ok {
+			attested = append(attested, MigratedEntry{
+				From: e.GetHash(),
+				To:   migrated.GetHash(),
+				V:    e.GetV(),
+				Key:  hex.EncodeToString(e.GetKey()),
+				Sig:  hex.EncodeToString(e.GetSig()),
+			})
+		}
+	}
+
+	heads := entry.FindHeads(l.Entries)
+	l.heads = entry.NewOrderedMapFromEntries(heads)
+	l.Clock = entry.NewLamportClock(identity.PublicKey, maxClockTimeForEntries(heads, 0))
+
+	var attestations []iface.IPFSLogEntry
+
+	for i := 0; i < len(attested); i += batchSize {
+		payload, err := json.Marshal(&MigrationAttestation{
+			Type:    MigrationAttestationType,
+			Entries: attested[i:minInt(i+batchSize, len(attested))],
+		})
+		if err != nil {
+			return nil, errmsg.ErrLogMigrationFailed.Wrap(errmsg.ErrJSONSerializationFailed.Wrap(err))
+		}
+
+		e, err := l.Append(ctx, payload, &AppendOptions{Pin: options.Pin})
+		if err != nil {
+			return nil, errmsg.ErrLogMigrationFailed.Wrap(err)
+		}
+
+		attestations = append(attestations, e)
+	}
+
+	return &MigrationResult{
+		Log:          l,
+		CIDs:         cids,
+		Attestations: attestations,
+	}, nil
+}
+
+// withoutEncryptedValues drops the values encrypted by the source IO, they
+// are computed again by the target IO if needed.
+func withoutEncryptedValues(additionalData map[string]string) map[string]string {
+	out := map[string]string{}
+
+	for k, v := range additionalData {
+		switch k {
+		case iface.KeyEncryptedLinks, iface.KeyEncryptedLinksNonce, iface.KeyEncryptedLinksKeyID,
+			iface.KeyEncryptedPayload, iface.KeyEncryptedPayloadKeyID, iface.KeyEncryptedRecipients,
+			iface.KeyEncryptedLinksAlgorithm, iface.KeyEncryptedPayloadAlgorithm, iface.KeyEncryptedPayloadPadding,
+			iface.KeyEncryptedLinksVersion:
+			continue
+		}
+
+		out[k] = v
+	}
+
+	return out
+}
+
+// migrationOrder sorts entries so that every entry comes after the entries it
+// points to, clocks can't be used as v0 entries all have a zero time.
+func migrationOrder(values []iface.IPFSLogEntry) []iface.IPFSLogEntry {
+	type frame struct {
+		e    iface.IPFSLogEntry
+		done bool
+	}
+
+	byHash := make(map[cid.Cid]iface.IPFSLogEntry, len(values))
+	for _, e := range values {
+		byHash[e.GetHash()] = e
+	}
+
+	visited := make(map[cid.Cid]struct{}, len(values))
+	ordered := make([]iface.IPFSLogEntry, 0, len(values))
+
+	for _, root := range values {
+		stack := []frame{{e: root}}
+
+		for len(stack) > 0 {
+			f := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			if f.done {
+				ordered = append(ordered, f.e)
+				continue
+			}
+
+			if _, ok := visited[f.e.GetHash()]; ok {
+				continue
+			}
+
+			visited[f.e.GetHash()] = struct{}{}
+			stack = append(stack, frame{e: f.e, done: true})
+
+			parents := append(append([]cid.Cid{}, f.e.GetNext()...), f.e.GetRefs()...)
+			for i := len(parents) - 1; i >= 0; i-- {
+				p, ok := byHash[parents[i]]
+				if !ok {
+					continue
+				}
+
+				if _, ok := visited[p.GetHash()]; !ok {
+					stack = append(stack, frame{e: p})
+				}
+			}
+		}
+	}
+
+	return ordered
+}
@@ -0,0 +1,70 @@
+package storage // import "berty.tech/go-ipfs-log/storage"
+
+import (
+	"context"
+
+	"github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+
+	"berty.tech/go-ipfs-log/iface"
+)
+
+type blockService struct {
+	bs blockservice.BlockService
+}
+
+// NewBlockService creates a storage backed by a block service, blocks
+// missing locally are fetched through its exchange.
+//
+// Pinning is a no-op, garbage collection is left to the block service owner.
+func NewBlockService(bs blockservice.BlockService) iface.Storage {
+	return &blockService{bs: bs}
+}
+
+func (s *blockService) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	return s.bs.GetBlock(ctx, c)
+}
+
+func (s *blockService) Put(ctx context.Context, block blocks.Block) error {
+	return s.bs.AddBlock(ctx, block)
+}
+
+func (s *blockService) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	return s.bs.Blockstore().Has(ctx, c)
+}
+
+func (s *blockService) Pin(context.Context, cid.Cid) error {
+	return nil
+}
+
+type blockStore struct {
+	bs blockstore.Blockstore
+}
+
+// NewBlockstore creates a storage backed by a local blockstore.
+//
+// Pinning is a no-op, garbage collection is left to the blockstore owner.
+func NewBlockstore(bs blockstore.Blockstore) iface.Storage {
+	return &blockStore{bs: bs}
+}
+
+func (s *blockStore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	return s.bs.Get(ctx, c)
+}
+
+func (s *blockStore) Put(ctx context.Context, block blocks.Block) error {
+	return s.bs.Put(ctx, block)
+}
+
+func (s *blockStore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	return s.bs.Has(ctx, c)
+}
+
+func (s *blockStore) Pin(context.Context, cid.Cid) error {
+	return nil
+}
+
+var _ iface.Storage = (*blockService)(nil)
+var _ iface.Storage = (*blockStore)(nil)
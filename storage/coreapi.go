@@ -0,0 +1,70 @@
+// Package storage provides block storage implementations for IPFS Log.
+package storage // import "berty.tech/go-ipfs-log/storage"
+
+import (
+	"context"
+
+	"github.com/ipfs/boxo/path"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	ipldlegacy "github.com/ipfs/go-ipld-legacy"
+	coreiface "github.com/ipfs/kubo/core/coreiface"
+	"github.com/ipfs/kubo/core/coreiface/options"
+
+	"berty.tech/go-ipfs-log/errmsg"
+	"berty.tech/go-ipfs-log/iface"
+)
+
+type coreAPI struct {
+	api     coreiface.CoreAPI
+	decoder *ipldlegacy.Decoder
+}
+
+// NewCoreAPI creates a storage backed by an IPFS node.
+func NewCoreAPI(api coreiface.CoreAPI) iface.Storage {
+	return &coreAPI{
+		api:     api,
+		decoder: ipldlegacy.NewDecoder(),
+	}
+}
+
+func (s *coreAPI) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	return s.api.Dag().Get(ctx, c)
+}
+
+func (s *coreAPI) Put(ctx context.Context, block blocks.Block) error {
+	node, ok := block.(format.Node)
+	if !ok {
+		var err error
+
+		if node, err = s.decoder.DecodeNode(ctx, block); err != nil {
+			return errmsg.ErrIPLDOperationFailed.Wrap(err)
+		}
+	}
+
+	return s.api.Dag().Add(ctx, node)
+}
+
+func (s *coreAPI) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	offline, err := s.api.WithOptions(options.Api.Offline(true))
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := offline.Block().Stat(ctx, path.FromCid(c)); err != nil {
+		if format.IsNotFound(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s *coreAPI) Pin(ctx context.Context, c cid.Cid) error {
+	return s.api.Pin().Add(ctx, path.FromCid(c))
+}
+
+var _ iface.Storage = (*coreAPI)(nil)
@@ -0,0 +1,93 @@
+package storage // import "berty.tech/go-ipfs-log/storage"
+
+import (
+	"context"
+	"sync"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+
+	"berty.tech/go-ipfs-log/iface"
+)
+
+// Memory is an in-memory storage, mostly useful for tests and ephemeral logs.
+type Memory struct {
+	lock   sync.RWMutex
+	blocks map[string][]byte
+	pins   map[string]struct{}
+}
+
+// NewMemory creates an empty in-memory storage.
+func NewMemory() *Memory {
+	return &Memory{
+		blocks: map[string][]byte{},
+		pins:   map[string]struct{}{},
+	}
+}
+
+// blocks are indexed by multihash, so CIDs differing only by their version
+// or codec share the same data, as in a blockstore.
+func memoryKey(c cid.Cid) string {
+	return string(c.Hash())
+}
+
+func (m *Memory) Get(_ context.Context, c cid.Cid) (blocks.Block, error) {
+	m.lock.RLock()
+	data, ok := m.blocks[memoryKey(c)]
+	m.lock.RUnlock()
+
+	if !ok {
+		return nil, format.ErrNotFound{Cid: c}
+	}
+
+	return blocks.NewBlockWithCid(data, c)
+}
+
+func (m *Memory) Put(_ context.Context, block blocks.Block) error {
+	m.lock.Lock()
+	m.blocks[memoryKey(block.Cid())] = block.RawData()
+	m.lock.Unlock()
+
+	return nil
+}
+
+func (m *Memory) Has(_ context.Context, c cid.Cid) (bool, error) {
+	m.lock.RLock()
+	_, ok := m.blocks[memoryKey(c)]
+	m.lock.RUnlock()
+
+	return ok, nil
+}
+
+func (m *Memory) Pin(_ context.Context, c cid.Cid) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.blocks[memoryKey(c)]; !ok {
+		return format.ErrNotFound{Cid: c}
+	}
+
+	m.pins[memoryKey(c)] = struct{}{}
+
+	return nil
+}
+
+// IsPinned checks whether a block has been pinned.
+func (m *Memory) IsPinned(c cid.Cid) bool {
+	m.lock.RLock()
+	_, ok := m.pins[memoryKey(c)]
+	m.lock.RUnlock()
+
+	return ok
+}
+
+// Len returns the number of stored blocks.
+func (m *Memory) Len() int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return len(m.blocks)
+}
+
+var _ iface.Storage = (*Memory)(nil)
@@ -0,0 +1,281 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-ipfs-log/accesscontroller"
+	"berty.tech/go-ipfs-log/entry"
+	"berty.tech/go-ipfs-log/errmsg"
+	idp "berty.tech/go-ipfs-log/identityprovider"
+	"berty.tech/go-ipfs-log/iface"
+	"berty.tech/go-ipfs-log/io/cbor"
+	"berty.tech/go-ipfs-log/io/ipldprime"
+	ks "berty.tech/go-ipfs-log/keystore"
+)
+
+// writerAccessController allows the entries of an identity, including its
+// devices having the write capability.
+type writerAccessController struct {
+	id string
+}
+
+func (ac *writerAccessController) CanAppend(e accesscontroller.LogEntry, _ idp.Interface, _ accesscontroller.CanAppendAdditionalContext) error {
+	if e.GetIdentity().ID != ac.id || !e.GetIdentity().HasCapability("write") {
+		return errmsg.ErrLogAppendDenied
+	}
+
+	return nil
+}
+
+func TestIdentityDelegation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := mocknet.New()
+	defer m.Close()
+	ipfs, closeNode := NewMemoryServices(ctx, t, m)
+	defer closeNode()
+
+	rootKeystore, err := ks.NewKeystore(dssync.MutexWrap(ds.NewMapDatastore()))
+	require.NoError(t, err)
+
+	deviceKeystore, err := ks.NewKeystore(dssync.MutexWrap(ds.NewMapDatastore()))
+	require.NoError(t, err)
+
+	root, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+		Keystore: rootKeystore,
+		ID:       "userA",
+		Type:     "orbitdb",
+	})
+	require.NoError(t, err)
+
+	reference, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+		Keystore: rootKeystore,
+		ID:       "userB",
+		Type:     "orbitdb",
+	})
+	require.NoError(t, err)
+
+	newDevice := func(t *testing.T, issuer *idp.Identity, issuerKeystore ks.Interface, keyID string, options *idp.DelegateOptions) (*idp.Identity, *idp.DeviceCertificate) {
+		t.Helper()
+
+		devicePubKey, err := idp.NewKeystoreSigner(deviceKeystore).PublicKey(ctx, keyID, ks.KeyTypeEd25519)
+		require.NoError(t, err)
+
+		options.Keystore = issuerKeystore
+		options.PublicKey = devicePubKey
+
+		certificate, err := idp.Delegate(ctx, issuer, options)
+		require.NoError(t, err)
+
+		// the device only knows the public part of the identity
+		device, err := idp.NewDeviceIdentity(ctx, issuer.Filtered(), certificate, &idp.CreateIdentityOptions{
+			Keystore: deviceKeystore,
+			ID:       keyID,
+		})
+		require.NoError(t, err)
+
+		return device, certificate
+	}
+
+	device, certificate := newDevice(t, root, rootKeystore, "laptop", &idp.DelegateOptions{
+		Expiry:       10,
+		Capabilities: []string{"write"},
+	})
+
+	require.Equal(t, root.ID, device.ID)
+	require.True(t, device.IsDevice())
+	require.False(t, root.IsDevice())
+	require.True(t, device.HasCapability("write"))
+	require.False(t, device.HasCapability(idp.CapabilityDelegate))
+	require.NoError(t, idp.VerifyIdentity(device.Filtered()))
+
+	ac := &writerAccessController{id: root.ID}
+
+	deviceLog, err := ipfslog.NewLog(ipfs, device, &ipfslog.LogOptions{ID: "A", AccessController: ac})
+	require.NoError(t, err)
+
+	deviceEntry, err := deviceLog.Append(ctx, []byte("one"), nil)
+	require.NoError(t, err)
+	require.Equal(t, device.PublicKey, deviceEntry.GetKey())
+	require.NoError(t, deviceEntry.Verify(reference.Provider, deviceLog.IO()))
+
+	t.Run("entries of devices are attributed to the identity", func(t *testing.T) {
+		l, err := ipfslog.NewLog(ipfs, reference, &ipfslog.LogOptions{ID: "A", AccessController: ac})
+		require.NoError(t, err)
+
+		_, err = l.Join(deviceLog, -1)
+		require.NoError(t, err)
+		require.Equal(t, 1, l.Values().Len())
+		require.Equal(t, root.ID, l.Values().At(0).GetIdentity().ID)
+	})
+
+	t.Run("device keys must match their certificate", func(t *testing.T) {
+		_, err := idp.NewDeviceIdentity(ctx, root.Filtered(), certificate, &idp.CreateIdentityOptions{
+			Keystore: deviceKeystore,
+			ID:       "phone",
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrKeyNotValid.Error())
+
+		forged := *certificate
+		forged.Capabilities = []string{"write", idp.CapabilityDelegate}
+
+		_, err = idp.NewDeviceIdentity(ctx, root.Filtered(), &forged, &idp.CreateIdentityOptions{
+			Keystore: deviceKeystore,
+			ID:       "laptop",
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrCertificateNotVerified.Error())
+
+		forgedDevice := device.Filtered()
+		forgedDevice.Certificates = []*idp.DeviceCertificate{&forged}
+		require.Error(t, idp.VerifyIdentity(forgedDevice))
+		require.Error(t, idp.CheckKey(forgedDevice, forgedDevice.PublicKey, 1))
+	})
+
+	t.Run("device keys expire", func(t *testing.T) {
+		l, err := ipfslog.NewLog(ipfs, device, &ipfslog.LogOptions{
+			ID:    "A",
+			Clock: entry.NewLamportClock(device.PublicKey, 10),
+		})
+		require.NoError(t, err)
+
+		_, err = l.Append(ctx, []byte("late"), nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrKeyNotValid.Error())
+	})
+
+	t.Run("expired devices can't follow later entries", func(t *testing.T) {
+		rootLog, err := ipfslog.NewLog(ipfs, root, &ipfslog.LogOptions{
+			ID:    "A",
+			Clock: entry.NewLamportClock(root.PublicKey, 11),
+		})
+		require.NoError(t, err)
+
+		later, err := rootLog.Append(ctx, []byte("later"), nil)
+		require.NoError(t, err)
+
+		// the device signs an entry before its expiry following a later one
+		forged, err := entry.CreateEntry(ctx, ipfs, device, &entry.Entry{
+			LogID:   "A",
+			Payload: []byte("forged"),
+			Next:    []cid.Cid{later.GetHash()},
+			Clock:   entry.NewLamportClock(device.PublicKey, 5),
+		}, nil)
+		require.NoError(t, err)
+		require.NoError(t, idp.CheckKey(device, device.PublicKey, 5))
+
+		forgedLog, err := ipfslog.NewFromEntry(ctx, ipfs, reference, []iface.IPFSLogEntry{forged}, &ipfslog.LogOptions{ID: "A"}, &entry.FetchOptions{})
+		require.NoError(t, err)
+		require.Equal(t, 2, forgedLog.Values().Len())
+
+		l, err := ipfslog.NewLog(ipfs, reference, &ipfslog.LogOptions{ID: "A"})
+		require.NoError(t, err)
+
+		_, err = l.Join(forgedLog, -1)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrEntryClockDecreasing.Error())
+
+		_, err = l.Join(rootLog, -1)
+		require.NoError(t, err)
+
+		_, err = l.Join(forgedLog, -1)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrEntryClockDecreasing.Error())
+	})
+
+	t.Run("devices delegate with the delegate capability", func(t *testing.T) {
+		devicePubKey, err := idp.NewKeystoreSigner(deviceKeystore).PublicKey(ctx, "tablet", ks.KeyTypeEd25519)
+		require.NoError(t, err)
+
+		_, err = idp.Delegate(ctx, device, &idp.DelegateOptions{Keystore: deviceKeystore, PublicKey: devicePubKey})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrCertificateNotVerified.Error())
+
+		delegating, _ := newDevice(t, root, rootKeystore, "desktop", &idp.DelegateOptions{
+			Capabilities: []string{"write", idp.CapabilityDelegate},
+		})
+
+		subDevice, _ := newDevice(t, delegating, deviceKeystore, "tablet", &idp.DelegateOptions{
+			Capabilities: []string{"write", "read"},
+		})
+		require.Len(t, subDevice.Certificates, 2)
+		require.True(t, subDevice.HasCapability("write"))
+		require.False(t, subDevice.HasCapability("read"))
+		require.NoError(t, idp.VerifyIdentity(subDevice.Filtered()))
+
+		l1, err := ipfslog.NewLog(ipfs, subDevice, &ipfslog.LogOptions{ID: "A", AccessController: ac})
+		require.NoError(t, err)
+
+		_, err = l1.Append(ctx, []byte("one"), nil)
+		require.NoError(t, err)
+
+		l2, err := ipfslog.NewLog(ipfs, reference, &ipfslog.LogOptions{ID: "A", AccessController: ac})
+		require.NoError(t, err)
+
+		_, err = l2.Join(l1, -1)
+		require.NoError(t, err)
+	})
+
+	t.Run("device keys are revoked by the identity", func(t *testing.T) {
+		revoking, err := idp.RevokeKey(ctx, root, &idp.RevokeKeyOptions{
+			Keystore:    rootKeystore,
+			Certificate: certificate,
+			After:       1,
+		})
+		require.NoError(t, err)
+
+		rootLog, err := ipfslog.NewLog(ipfs, revoking, &ipfslog.LogOptions{ID: "A"})
+		require.NoError(t, err)
+
+		_, err = rootLog.Append(ctx, []byte("revocation"), nil)
+		require.NoError(t, err)
+
+		l1, err := ipfslog.NewLog(ipfs, device, &ipfslog.LogOptions{ID: "A"})
+		require.NoError(t, err)
+
+		_, err = l1.Append(ctx, []byte("one"), nil)
+		require.NoError(t, err)
+
+		_, err = l1.Append(ctx, []byte("two"), nil)
+		require.NoError(t, err)
+
+		l2, err := ipfslog.NewLog(ipfs, reference, &ipfslog.LogOptions{ID: "A"})
+		require.NoError(t, err)
+
+		_, err = l2.Join(rootLog, -1)
+		require.NoError(t, err)
+
+		_, err = l2.Join(l1, -1)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrKeyRevoked.Error())
+	})
+
+	t.Run("certificates are serialized by the IOs", func(t *testing.T) {
+		cborio, err := cbor.IO(&entry.Entry{}, &entry.LamportClock{})
+		require.NoError(t, err)
+
+		primeio, err := ipldprime.IO(&entry.Entry{}, &entry.LamportClock{})
+		require.NoError(t, err)
+
+		for _, io := range []ipfslog.IO{cborio, primeio} {
+			decoded, err := entry.FromMultihashWithIO(ctx, ipfs, deviceEntry.GetHash(), reference.Provider, io)
+			require.NoError(t, err)
+			require.Equal(t, device.Certificates, decoded.GetIdentity().Certificates)
+			require.NoError(t, decoded.Verify(reference.Provider, io))
+
+			c, err := io.Write(ctx, ipfs, decoded, nil)
+			require.NoError(t, err)
+			require.Equal(t, deviceEntry.GetHash().String(), c.String())
+		}
+	})
+}
@@ -0,0 +1,130 @@
+package test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-ipfs-log/errmsg"
+	idp "berty.tech/go-ipfs-log/identityprovider"
+	ks "berty.tech/go-ipfs-log/keystore"
+)
+
+func TestDIDKeyIdentityProvider(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := mocknet.New()
+	defer m.Close()
+	ipfs, closeNode := NewMemoryServices(ctx, t, m)
+	defer closeNode()
+
+	keystore, err := ks.NewKeystore(dssync.MutexWrap(ds.NewMapDatastore()))
+	require.NoError(t, err)
+
+	t.Run("parses did:key test vectors", func(t *testing.T) {
+		for _, did := range []string{
+			"did:key:z6MkiTBz1ymuepAQ4HEHYSF1H8quG5GLVVQR3djdX3mDooWp",
+			"did:key:zQ3shokFTS3brHcDQrn82RUDfCZESWL1ZdCEJwekUDPQiYBme",
+			"did:key:zDnaerDaTF5BXEavCrfRZEk316dpbLsfPDZ3WJ5hRTPFU2169",
+		} {
+			pubKey, err := idp.PublicKeyFromDIDKey(did)
+			require.NoError(t, err)
+
+			encoded, err := idp.DIDKeyFromPublicKey(pubKey)
+			require.NoError(t, err)
+			require.Equal(t, did, encoded)
+		}
+
+		_, err := idp.PublicKeyFromDIDKey("did:web:example.com")
+		require.Error(t, err)
+	})
+
+	for keyType, prefix := range map[ks.KeyType]string{
+		ks.KeyTypeEd25519:   "did:key:z6Mk",
+		ks.KeyTypeSecp256k1: "did:key:zQ3s",
+		ks.KeyTypeECDSAP256: "did:key:zDn",
+	} {
+		keyType, prefix := keyType, prefix
+
+		t.Run(string(keyType), func(t *testing.T) {
+			identity, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+				Keystore: keystore,
+				ID:       "user-" + string(keyType),
+				Type:     idp.DIDKeyType,
+				KeyType:  keyType,
+			})
+			require.NoError(t, err)
+			require.True(t, strings.HasPrefix(identity.ID, prefix), identity.ID)
+			require.Equal(t, idp.DIDKeyType, identity.Type)
+
+			require.NoError(t, idp.VerifyIdentity(identity.Filtered()))
+
+			tampered := identity.Filtered()
+			tampered.PublicKey = append([]byte{}, identity.PublicKey...)
+			tampered.PublicKey[len(tampered.PublicKey)-1] ^= 1
+			require.Error(t, idp.VerifyIdentity(tampered))
+
+			didKey, err := idp.PublicKeyFromDIDKey(identity.ID)
+			require.NoError(t, err)
+
+			owner, err := keystore.GetKey(ctx, "user-"+string(keyType))
+			require.NoError(t, err)
+			require.True(t, didKey.Equals(owner.GetPublic()))
+
+			l1, err := ipfslog.NewLog(ipfs, identity, &ipfslog.LogOptions{ID: "A"})
+			require.NoError(t, err)
+
+			e, err := l1.Append(ctx, []byte("one"), nil)
+			require.NoError(t, err)
+			require.NoError(t, e.Verify(identity.Provider, l1.IO()))
+
+			reference, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+				Keystore: keystore,
+				ID:       "reference",
+				Type:     "orbitdb",
+			})
+			require.NoError(t, err)
+
+			l2, err := ipfslog.NewLog(ipfs, reference, &ipfslog.LogOptions{ID: "A"})
+			require.NoError(t, err)
+
+			_, err = l2.Join(l1, -1)
+			require.NoError(t, err)
+			require.Equal(t, 1, l2.Values().Len())
+			require.Equal(t, identity.ID, l2.Values().At(0).GetIdentity().ID)
+		})
+	}
+
+	t.Run("rejects identities of another DID", func(t *testing.T) {
+		identity, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+			Keystore: keystore,
+			ID:       "userA",
+			Type:     idp.DIDKeyType,
+			KeyType:  ks.KeyTypeEd25519,
+		})
+		require.NoError(t, err)
+
+		other, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+			Keystore: keystore,
+			ID:       "userB",
+			Type:     idp.DIDKeyType,
+			KeyType:  ks.KeyTypeEd25519,
+		})
+		require.NoError(t, err)
+
+		forged := identity.Filtered()
+		forged.Signatures = other.Signatures
+		forged.PublicKey = other.PublicKey
+
+		err = idp.VerifyIdentity(forged)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrSigNotVerified.Error())
+	})
+}
@@ -0,0 +1,133 @@
+package test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-ipfs-log/errmsg"
+	idp "berty.tech/go-ipfs-log/identityprovider"
+	ks "berty.tech/go-ipfs-log/keystore"
+)
+
+func TestEthereumIdentityProvider(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := mocknet.New()
+	defer m.Close()
+	ipfs, closeNode := NewMemoryServices(ctx, t, m)
+	defer closeNode()
+
+	keystore, err := ks.NewKeystore(dssync.MutexWrap(ds.NewMapDatastore()))
+	require.NoError(t, err)
+
+	t.Run("signs personal messages as web3", func(t *testing.T) {
+		priv, err := crypto.UnmarshalSecp256k1PrivateKey(MustBytesFromHex(t, "4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318"))
+		require.NoError(t, err)
+
+		address, err := idp.EthereumAddress(priv.GetPublic())
+		require.NoError(t, err)
+		require.Equal(t, "0x2c7536E3605D9C16a7a3D7b1898e529396a65c23", address)
+
+		signer, ok := idp.NewPrivKeySigner(priv).(idp.EthereumSigner)
+		require.True(t, ok)
+
+		sig, err := signer.SignPersonalMessage(ctx, "", []byte("Some data"))
+		require.NoError(t, err)
+		require.Equal(t, MustBytesFromHex(t, "b91467e570a6466aa9e9876cbcd013baba02900b8979d43fe208a4a4f339f5fd6007e74cd82e037b800186422fc2da167c747ef045e5d18a5f5d4300f8e1a0291c"), sig)
+	})
+
+	t.Run("signs personal messages over a remote signer", func(t *testing.T) {
+		server, err := idp.NewSignerServer(idp.NewKeystoreSigner(keystore))
+		require.NoError(t, err)
+
+		clientConn, serverConn := net.Pipe()
+		go server.ServeConn(serverConn)
+
+		signer := idp.NewRemoteSigner(clientConn)
+		defer signer.Close()
+
+		pubKey, err := signer.PublicKey(ctx, "remote-wallet", ks.KeyTypeSecp256k1)
+		require.NoError(t, err)
+
+		local, err := keystore.GetKey(ctx, "remote-wallet")
+		require.NoError(t, err)
+
+		expected, err := idp.NewPrivKeySigner(local).(idp.EthereumSigner).SignPersonalMessage(ctx, "", []byte("data"))
+		require.NoError(t, err)
+
+		sig, err := signer.SignPersonalMessage(ctx, "remote-wallet", []byte("data"))
+		require.NoError(t, err)
+		require.Len(t, sig, 65)
+		require.Equal(t, expected, sig)
+		require.True(t, pubKey.Equals(local.GetPublic()))
+	})
+
+	t.Run("uses the address of the wallet key", func(t *testing.T) {
+		identity, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+			Keystore: keystore,
+			ID:       "wallet",
+			Type:     idp.EthereumType,
+		})
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(identity.ID, "0x"))
+		require.Len(t, identity.ID, 42)
+
+		wallet, err := keystore.GetKey(ctx, "wallet")
+		require.NoError(t, err)
+
+		address, err := idp.EthereumAddress(wallet.GetPublic())
+		require.NoError(t, err)
+		require.Equal(t, address, identity.ID)
+
+		require.Len(t, identity.Signatures.PublicKey, 65)
+		require.NoError(t, idp.VerifyIdentity(identity.Filtered()))
+
+		forged := identity.Filtered()
+		forged.ID = "0x2c7536E3605D9C16a7a3D7b1898e529396a65c23"
+
+		err = idp.VerifyIdentity(forged)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrSigNotVerified.Error())
+
+		l1, err := ipfslog.NewLog(ipfs, identity, &ipfslog.LogOptions{ID: "A"})
+		require.NoError(t, err)
+
+		_, err = l1.Append(ctx, []byte("one"), nil)
+		require.NoError(t, err)
+
+		reference, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+			Keystore: keystore,
+			ID:       "reference",
+			Type:     "orbitdb",
+		})
+		require.NoError(t, err)
+
+		l2, err := ipfslog.NewLog(ipfs, reference, &ipfslog.LogOptions{ID: "A"})
+		require.NoError(t, err)
+
+		_, err = l2.Join(l1, -1)
+		require.NoError(t, err)
+		require.Equal(t, 1, l2.Values().Len())
+	})
+
+	t.Run("requires secp256k1 keys", func(t *testing.T) {
+		_, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+			Keystore: keystore,
+			ID:       "wallet-ed25519",
+			Type:     idp.EthereumType,
+			KeyType:  ks.KeyTypeEd25519,
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrUnsupportedKeyType.Error())
+	})
+}
@@ -0,0 +1,126 @@
+package test
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-ipfs-log/errmsg"
+	idp "berty.tech/go-ipfs-log/identityprovider"
+	ks "berty.tech/go-ipfs-log/keystore"
+)
+
+func TestPeerIdentityProvider(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := mocknet.New()
+	defer m.Close()
+	ipfs, closeNode := NewMemoryServices(ctx, t, m)
+	defer closeNode()
+
+	host, err := m.GenPeer()
+	require.NoError(t, err)
+
+	hostKey := host.Peerstore().PrivKey(host.ID())
+	require.NotNil(t, hostKey)
+
+	keystore, err := ks.NewKeystore(dssync.MutexWrap(ds.NewMapDatastore()))
+	require.NoError(t, err)
+
+	t.Run("uses the peer ID of the host", func(t *testing.T) {
+		identity, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+			Signer: idp.NewPrivKeySigner(hostKey),
+			Type:   idp.PeerIdentityType,
+		})
+		require.NoError(t, err)
+		require.Equal(t, host.ID().String(), identity.ID)
+
+		id, err := idp.PeerIDFromIdentity(identity)
+		require.NoError(t, err)
+		require.Equal(t, host.ID(), id)
+
+		require.NoError(t, idp.VerifyIdentity(identity.Filtered()))
+
+		l1, err := ipfslog.NewLog(ipfs, identity, &ipfslog.LogOptions{ID: "A"})
+		require.NoError(t, err)
+
+		e, err := l1.Append(ctx, []byte("one"), nil)
+		require.NoError(t, err)
+
+		pubKey, err := crypto.UnmarshalPublicKey(e.GetKey())
+		require.NoError(t, err)
+		require.True(t, host.ID().MatchesPublicKey(pubKey))
+
+		reference, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+			Keystore: keystore,
+			ID:       "reference",
+			Type:     "orbitdb",
+		})
+		require.NoError(t, err)
+
+		l2, err := ipfslog.NewLog(ipfs, reference, &ipfslog.LogOptions{ID: "A"})
+		require.NoError(t, err)
+
+		_, err = l2.Join(l1, -1)
+		require.NoError(t, err)
+		require.Equal(t, 1, l2.Values().Len())
+	})
+
+	t.Run("uses a host key stored in the keystore", func(t *testing.T) {
+		priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		require.NoError(t, err)
+
+		id, err := peer.IDFromPrivateKey(priv)
+		require.NoError(t, err)
+
+		exported, err := crypto.MarshalPrivateKey(priv)
+		require.NoError(t, err)
+
+		_, err = keystore.ImportKey(ctx, id.String(), exported, nil)
+		require.NoError(t, err)
+
+		identity, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+			Keystore: keystore,
+			ID:       id.String(),
+			Type:     idp.PeerIdentityType,
+		})
+		require.NoError(t, err)
+		require.Equal(t, id.String(), identity.ID)
+		require.NoError(t, idp.VerifyIdentity(identity.Filtered()))
+	})
+
+	t.Run("rejects keys not matching the peer ID", func(t *testing.T) {
+		identity, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+			Signer: idp.NewPrivKeySigner(hostKey),
+			Type:   idp.PeerIdentityType,
+		})
+		require.NoError(t, err)
+
+		other, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		require.NoError(t, err)
+
+		forged, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+			Signer: idp.NewPrivKeySigner(other),
+			Type:   idp.PeerIdentityType,
+		})
+		require.NoError(t, err)
+
+		forged.ID = identity.ID
+
+		_, err = idp.PeerIDFromIdentity(forged)
+		require.Error(t, err)
+
+		err = idp.VerifyIdentity(forged.Filtered())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrSigNotVerified.Error())
+	})
+}
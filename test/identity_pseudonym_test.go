@@ -0,0 +1,144 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-ipfs-log/enc"
+	"berty.tech/go-ipfs-log/errmsg"
+	idp "berty.tech/go-ipfs-log/identityprovider"
+	ks "berty.tech/go-ipfs-log/keystore"
+)
+
+func TestIdentityPseudonym(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := mocknet.New()
+	defer m.Close()
+	ipfs, closeNode := NewMemoryServices(ctx, t, m)
+	defer closeNode()
+
+	keystore, err := ks.NewKeystore(dssync.MutexWrap(ds.NewMapDatastore()))
+	require.NoError(t, err)
+
+	options := &idp.CreateIdentityOptions{
+		Keystore: keystore,
+		ID:       "userA",
+		Type:     "orbitdb",
+	}
+
+	root, err := idp.CreateIdentity(ctx, options)
+	require.NoError(t, err)
+
+	reference, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+		Keystore: keystore,
+		ID:       "userB",
+		Type:     "orbitdb",
+	})
+	require.NoError(t, err)
+
+	pseudonymA, err := idp.CreatePseudonym(ctx, "A", options)
+	require.NoError(t, err)
+
+	pseudonymB, err := idp.CreatePseudonym(ctx, "B", options)
+	require.NoError(t, err)
+
+	t.Run("pseudonyms are distinct for each log", func(t *testing.T) {
+		for _, p := range []*idp.Identity{pseudonymA, pseudonymB} {
+			require.NotEqual(t, root.ID, p.ID)
+			require.NotEqual(t, root.PublicKey, p.PublicKey)
+			require.NoError(t, idp.VerifyIdentity(p.Filtered()))
+		}
+
+		require.NotEqual(t, pseudonymA.ID, pseudonymB.ID)
+		require.NotEqual(t, pseudonymA.PublicKey, pseudonymB.PublicKey)
+
+		again, err := idp.CreatePseudonym(ctx, "A", options)
+		require.NoError(t, err)
+		require.Equal(t, pseudonymA.ID, again.ID)
+		require.Equal(t, pseudonymA.PublicKey, again.PublicKey)
+
+		// the log ID and the key ID can't be shifted into each other
+		shifted, err := idp.CreatePseudonym(ctx, "A/userA", &idp.CreateIdentityOptions{
+			Keystore: keystore,
+			ID:       "userA",
+			Type:     "orbitdb",
+		})
+		require.NoError(t, err)
+		require.NotEqual(t, pseudonymA.ID, shifted.ID)
+
+		_, err = idp.CreatePseudonym(ctx, "A", &idp.CreateIdentityOptions{ID: "userA", Type: "orbitdb"})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrKeystoreNotDefined.Error())
+	})
+
+	l1, err := ipfslog.NewLog(ipfs, pseudonymA, &ipfslog.LogOptions{ID: "A"})
+	require.NoError(t, err)
+
+	e, err := l1.Append(ctx, []byte("one"), nil)
+	require.NoError(t, err)
+	require.Equal(t, pseudonymA.PublicKey, e.GetKey())
+	require.Equal(t, pseudonymA.PublicKey, e.GetClock().GetID())
+	require.NoError(t, e.Verify(reference.Provider, l1.IO()))
+
+	t.Run("entries of pseudonyms are verified", func(t *testing.T) {
+		l2, err := ipfslog.NewLog(ipfs, reference, &ipfslog.LogOptions{ID: "A"})
+		require.NoError(t, err)
+
+		_, err = l2.Join(l1, -1)
+		require.NoError(t, err)
+		require.Equal(t, 1, l2.Values().Len())
+		require.Equal(t, pseudonymA.ID, l2.Values().At(0).GetIdentity().ID)
+	})
+
+	proof, err := idp.ProvePseudonym(ctx, root, pseudonymA, "A")
+	require.NoError(t, err)
+
+	t.Run("proofs link pseudonyms to their identity", func(t *testing.T) {
+		require.NoError(t, idp.VerifyPseudonymProof(proof, e.GetIdentity(), "A"))
+		require.Equal(t, root.ID, proof.Root.ID)
+
+		for _, err := range []error{
+			idp.VerifyPseudonymProof(proof, e.GetIdentity(), "B"),
+			idp.VerifyPseudonymProof(proof, pseudonymB, "A"),
+			idp.VerifyPseudonymProof(nil, pseudonymA, "A"),
+		} {
+			require.Error(t, err)
+			require.Contains(t, err.Error(), errmsg.ErrPseudonymProofNotVerified.Error())
+		}
+
+		// the proof can't be moved to another identity
+		forged := *proof
+		forged.Root = reference.Filtered()
+
+		err := idp.VerifyPseudonymProof(&forged, pseudonymA, "A")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrPseudonymProofNotVerified.Error())
+	})
+
+	t.Run("proofs are only opened by their verifiers", func(t *testing.T) {
+		verifier, err := enc.GenerateBoxKeyPair()
+		require.NoError(t, err)
+
+		other, err := enc.GenerateBoxKeyPair()
+		require.NoError(t, err)
+
+		sealed, err := enc.SealPseudonymProof(proof, []*enc.BoxPublicKey{verifier.Public})
+		require.NoError(t, err)
+
+		opened, err := enc.OpenPseudonymProof(sealed, verifier)
+		require.NoError(t, err)
+		require.NoError(t, idp.VerifyPseudonymProof(opened, pseudonymA, "A"))
+		require.Equal(t, root.ID, opened.Root.ID)
+
+		_, err = enc.OpenPseudonymProof(sealed, other)
+		require.ErrorIs(t, err, enc.ErrNotRecipient)
+	})
+}
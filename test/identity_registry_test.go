@@ -0,0 +1,167 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-ipfs-log/entry"
+	"berty.tech/go-ipfs-log/errmsg"
+	idp "berty.tech/go-ipfs-log/identityprovider"
+	"berty.tech/go-ipfs-log/io/cbor"
+	"berty.tech/go-ipfs-log/io/ipldprime"
+	ks "berty.tech/go-ipfs-log/keystore"
+)
+
+func TestIdentityRegistry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := mocknet.New()
+	defer m.Close()
+	ipfs, closeNode := NewMemoryServices(ctx, t, m)
+	defer closeNode()
+
+	keystore, err := ks.NewKeystore(dssync.MutexWrap(ds.NewMapDatastore()))
+	require.NoError(t, err)
+
+	orbitdbOnly, err := idp.NewRegistry(idp.NewOrbitDBIdentityProvider)
+	require.NoError(t, err)
+
+	withDIDKey, err := idp.NewRegistry(idp.NewOrbitDBIdentityProvider, idp.NewDIDKeyIdentityProvider)
+	require.NoError(t, err)
+
+	didIdentity, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+		Keystore: keystore,
+		ID:       "userA",
+		Type:     idp.DIDKeyType,
+		Registry: withDIDKey,
+	})
+	require.NoError(t, err)
+
+	orbitdbIdentity, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+		Keystore: keystore,
+		ID:       "userB",
+		Type:     "orbitdb",
+		Registry: orbitdbOnly,
+	})
+	require.NoError(t, err)
+
+	t.Run("registries are independent", func(t *testing.T) {
+		_, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+			Keystore: keystore,
+			ID:       "userC",
+			Type:     idp.DIDKeyType,
+			Registry: orbitdbOnly,
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrIdentityProviderNotSupported.Error())
+
+		require.NoError(t, withDIDKey.VerifyIdentity(didIdentity.Filtered()))
+		require.Error(t, orbitdbOnly.VerifyIdentity(didIdentity.Filtered()))
+
+		withDIDKey.Remove(idp.DIDKeyType)
+		require.False(t, withDIDKey.IsSupported(idp.DIDKeyType))
+		require.True(t, idp.IsSupported(idp.DIDKeyType))
+		require.NoError(t, idp.VerifyIdentity(didIdentity.Filtered()))
+
+		require.NoError(t, withDIDKey.Add(idp.NewDIDKeyIdentityProvider))
+		require.NoError(t, withDIDKey.VerifyIdentity(didIdentity.Filtered()))
+	})
+
+	t.Run("registries can be modified concurrently", func(t *testing.T) {
+		registry, err := idp.NewRegistry()
+		require.NoError(t, err)
+
+		wg := sync.WaitGroup{}
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				for j := 0; j < 100; j++ {
+					if i%2 == 0 {
+						require.NoError(t, registry.Add(idp.NewEthereumIdentityProvider))
+					} else {
+						registry.Remove(idp.EthereumType)
+					}
+					registry.IsSupported(idp.EthereumType)
+				}
+			}(i)
+		}
+		wg.Wait()
+	})
+
+	for name, newIO := range map[string]func(registry *idp.Registry) (ipfslog.IO, error){
+		"cbor": func(registry *idp.Registry) (ipfslog.IO, error) {
+			io, err := cbor.IO(&entry.Entry{}, &entry.LamportClock{})
+			if err != nil {
+				return nil, err
+			}
+
+			return io.ApplyOptions(&cbor.Options{Registry: registry}), nil
+		},
+		"ipldprime": func(registry *idp.Registry) (ipfslog.IO, error) {
+			io, err := ipldprime.IO(&entry.Entry{}, &entry.LamportClock{})
+			if err != nil {
+				return nil, err
+			}
+
+			return io.ApplyOptions(&ipldprime.Options{Registry: registry}), nil
+		},
+	} {
+		newIO := newIO
+
+		t.Run(fmt.Sprintf("%s IO rejects entries of unregistered identity types", name), func(t *testing.T) {
+			writerIO, err := newIO(nil)
+			require.NoError(t, err)
+
+			l1, err := ipfslog.NewLog(ipfs, didIdentity, &ipfslog.LogOptions{ID: "A", IO: writerIO})
+			require.NoError(t, err)
+
+			e, err := l1.Append(ctx, []byte("one"), nil)
+			require.NoError(t, err)
+
+			restrictedIO, err := newIO(orbitdbOnly)
+			require.NoError(t, err)
+
+			l2, err := ipfslog.NewLog(ipfs, orbitdbIdentity, &ipfslog.LogOptions{ID: "A", IO: restrictedIO})
+			require.NoError(t, err)
+
+			_, err = l2.Join(l1, -1)
+			require.Error(t, err)
+			require.Equal(t, 0, l2.Values().Len())
+
+			_, err = entry.FromMultihashWithIO(ctx, ipfs, e.GetHash(), orbitdbIdentity.Provider, restrictedIO)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), errmsg.ErrIdentityProviderNotSupported.Error())
+
+			// entries that can't be read are skipped when fetched
+			restricted, err := ipfslog.NewFromEntryHash(ctx, ipfs, orbitdbIdentity, e.GetHash(), &ipfslog.LogOptions{ID: "A", IO: restrictedIO}, &ipfslog.FetchOptions{})
+			require.NoError(t, err)
+			require.Equal(t, 0, restricted.Values().Len())
+
+			allowedIO, err := newIO(withDIDKey)
+			require.NoError(t, err)
+
+			l3, err := ipfslog.NewLog(ipfs, orbitdbIdentity, &ipfslog.LogOptions{ID: "A", IO: allowedIO})
+			require.NoError(t, err)
+
+			_, err = l3.Join(l1, -1)
+			require.NoError(t, err)
+			require.Equal(t, 1, l3.Values().Len())
+
+			l4, err := ipfslog.NewFromEntryHash(ctx, ipfs, orbitdbIdentity, e.GetHash(), &ipfslog.LogOptions{ID: "A", IO: allowedIO}, &ipfslog.FetchOptions{})
+			require.NoError(t, err)
+			require.Equal(t, 1, l4.Values().Len())
+			require.Equal(t, idp.DIDKeyType, l4.Values().At(0).GetIdentity().Provider.GetType())
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-ipfs-log/entry"
+	"berty.tech/go-ipfs-log/errmsg"
+	idp "berty.tech/go-ipfs-log/identityprovider"
+	"berty.tech/go-ipfs-log/io/cbor"
+	"berty.tech/go-ipfs-log/io/ipldprime"
+	ks "berty.tech/go-ipfs-log/keystore"
+)
+
+func TestIdentityKeyRotation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := mocknet.New()
+	defer m.Close()
+	ipfs, closeNode := NewMemoryServices(ctx, t, m)
+	defer closeNode()
+
+	keystore, err := ks.NewKeystore(dssync.MutexWrap(ds.NewMapDatastore()))
+	require.NoError(t, err)
+
+	identity, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+		Keystore: keystore,
+		ID:       "userA",
+		Type:     "orbitdb",
+	})
+	require.NoError(t, err)
+
+	reference, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+		Keystore: keystore,
+		ID:       "userB",
+		Type:     "orbitdb",
+	})
+	require.NoError(t, err)
+
+	l1, err := ipfslog.NewLog(ipfs, identity, &ipfslog.LogOptions{ID: "A"})
+	require.NoError(t, err)
+
+	_, err = l1.Append(ctx, []byte("one"), nil)
+	require.NoError(t, err)
+
+	_, err = l1.Append(ctx, []byte("two"), nil)
+	require.NoError(t, err)
+
+	rotated, err := idp.RotateKey(ctx, identity, &idp.RotateKeyOptions{
+		Keystore: keystore,
+		KeyType:  ks.KeyTypeEd25519,
+		Start:    l1.Clock.GetTime() + 1,
+	})
+	require.NoError(t, err)
+	require.Equal(t, identity.ID, rotated.ID)
+	require.NotEqual(t, identity.PublicKey, rotated.PublicKey)
+	require.Len(t, rotated.Rotations, 1)
+	require.Empty(t, identity.Rotations)
+	require.NoError(t, idp.VerifyIdentity(rotated.Filtered()))
+
+	l1.SetIdentity(rotated)
+
+	rotatedEntry, err := l1.Append(ctx, []byte("three"), nil)
+	require.NoError(t, err)
+	require.Equal(t, rotated.PublicKey, rotatedEntry.GetKey())
+	require.NoError(t, rotatedEntry.Verify(identity.Provider, l1.IO()))
+
+	t.Run("past entries stay valid", func(t *testing.T) {
+		l2, err := ipfslog.NewLog(ipfs, reference, &ipfslog.LogOptions{ID: "A"})
+		require.NoError(t, err)
+
+		_, err = l2.Join(l1, -1)
+		require.NoError(t, err)
+		require.Equal(t, 3, l2.Values().Len())
+	})
+
+	t.Run("retired keys are rejected", func(t *testing.T) {
+		// the writer doesn't know about the rotation
+		stale, err := ipfslog.NewLog(ipfs, identity, &ipfslog.LogOptions{
+			ID:    "A",
+			Clock: entry.NewLamportClock(identity.PublicKey, 10),
+		})
+		require.NoError(t, err)
+
+		_, err = stale.Append(ctx, []byte("stale"), nil)
+		require.NoError(t, err)
+
+		l2, err := ipfslog.NewLog(ipfs, reference, &ipfslog.LogOptions{ID: "A"})
+		require.NoError(t, err)
+
+		_, err = l2.Join(l1, -1)
+		require.NoError(t, err)
+
+		_, err = l2.Join(stale, -1)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrKeyNotValid.Error())
+
+		// it is rejected once the writer knows about the rotation
+		_, err = stale.Join(l1, -1)
+		require.NoError(t, err)
+
+		_, err = stale.Append(ctx, []byte("stale"), nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrKeyNotValid.Error())
+	})
+
+	t.Run("revoked keys are rejected", func(t *testing.T) {
+		revoked, err := idp.RevokeKey(ctx, rotated, &idp.RevokeKeyOptions{
+			Keystore:  keystore,
+			PublicKey: identity.PublicKey,
+			After:     1,
+		})
+		require.NoError(t, err)
+		require.Len(t, revoked.Revocations, 1)
+		require.NoError(t, idp.VerifyIdentity(revoked.Filtered()))
+
+		_, err = idp.RevokeKey(ctx, rotated, &idp.RevokeKeyOptions{
+			Keystore:  keystore,
+			PublicKey: reference.PublicKey,
+		})
+		require.Error(t, err)
+
+		l3, err := ipfslog.NewLog(ipfs, revoked, &ipfslog.LogOptions{
+			ID:    "A",
+			Clock: entry.NewLamportClock(revoked.PublicKey, l1.Clock.GetTime()),
+		})
+		require.NoError(t, err)
+
+		_, err = l3.Append(ctx, []byte("revocation"), nil)
+		require.NoError(t, err)
+
+		l2, err := ipfslog.NewLog(ipfs, reference, &ipfslog.LogOptions{ID: "A"})
+		require.NoError(t, err)
+
+		// the entry signed using the revoked key after its revocation
+		_, err = l2.Join(l3, -1)
+		require.NoError(t, err)
+
+		_, err = l2.Join(l1, -1)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrKeyRevoked.Error())
+	})
+
+	t.Run("forged statements are rejected", func(t *testing.T) {
+		forged := rotated.Filtered()
+		forged.Rotations = []*idp.KeyRotation{{
+			PublicKey:    rotated.Rotations[0].PublicKey,
+			NewPublicKey: rotated.Rotations[0].NewPublicKey,
+			Start:        0,
+			Signature:    rotated.Rotations[0].Signature,
+		}}
+
+		err := idp.VerifyIdentity(forged)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrKeyStatementNotVerified.Error())
+
+		require.Error(t, idp.CheckKey(forged, rotated.PublicKey, 0))
+		require.Error(t, idp.NewKeyHistory().Add(forged))
+
+		// a known signature can't be reused for another statement
+		history := idp.NewKeyHistory()
+		require.NoError(t, history.Add(rotated))
+		require.Error(t, history.CheckKey(forged, rotated.PublicKey, 0))
+	})
+
+	t.Run("statements are serialized by the IOs", func(t *testing.T) {
+		cborio, err := cbor.IO(&entry.Entry{}, &entry.LamportClock{})
+		require.NoError(t, err)
+
+		primeio, err := ipldprime.IO(&entry.Entry{}, &entry.LamportClock{})
+		require.NoError(t, err)
+
+		revoked, err := idp.RevokeKey(ctx, rotated, &idp.RevokeKeyOptions{
+			Keystore:  keystore,
+			PublicKey: identity.PublicKey,
+			After:     1,
+		})
+		require.NoError(t, err)
+
+		l4, err := ipfslog.NewLog(ipfs, revoked, &ipfslog.LogOptions{
+			ID:    "A",
+			Clock: entry.NewLamportClock(revoked.PublicKey, l1.Clock.GetTime()),
+		})
+		require.NoError(t, err)
+
+		revokedEntry, err := l4.Append(ctx, []byte("four"), nil)
+		require.NoError(t, err)
+
+		for _, io := range []ipfslog.IO{cborio, primeio} {
+			for _, e := range []ipfslog.Entry{rotatedEntry, revokedEntry} {
+				decoded, err := entry.FromMultihashWithIO(ctx, ipfs, e.GetHash(), identity.Provider, io)
+				require.NoError(t, err)
+				require.Equal(t, e.GetIdentity().Rotations, decoded.GetIdentity().Rotations)
+				require.Equal(t, e.GetIdentity().Revocations, decoded.GetIdentity().Revocations)
+				require.NoError(t, decoded.Verify(identity.Provider, io))
+
+				c, err := io.Write(ctx, ipfs, decoded, nil)
+				require.NoError(t, err)
+				require.Equal(t, e.GetHash().String(), c.String())
+			}
+		}
+	})
+
+	t.Run("compromised keys can't take over", func(t *testing.T) {
+		// the holder of the retired key signs another rotation after the
+		// legitimate one, starting earlier
+		compromised, err := idp.RotateKey(ctx, identity, &idp.RotateKeyOptions{
+			Keystore: keystore,
+			KeyID:    "compromised",
+			Start:    1,
+		})
+		require.NoError(t, err)
+		require.NoError(t, idp.VerifyIdentity(compromised.Filtered()))
+
+		history := idp.NewKeyHistory()
+		require.NoError(t, history.Add(rotated))
+		require.NoError(t, history.Add(compromised))
+
+		require.NoError(t, history.CheckKey(rotated, rotated.PublicKey, l1.Clock.GetTime()))
+		require.NoError(t, history.CheckKey(identity, identity.PublicKey, 2))
+
+		err = history.CheckKey(compromised, compromised.PublicKey, l1.Clock.GetTime())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrKeyNotValid.Error())
+
+		forger, err := ipfslog.NewLog(ipfs, compromised, &ipfslog.LogOptions{
+			ID:    "A",
+			Clock: entry.NewLamportClock(compromised.PublicKey, l1.Clock.GetTime()),
+		})
+		require.NoError(t, err)
+
+		_, err = forger.Append(ctx, []byte("forged"), nil)
+		require.NoError(t, err)
+
+		l2, err := ipfslog.NewLog(ipfs, reference, &ipfslog.LogOptions{ID: "A"})
+		require.NoError(t, err)
+
+		_, err = l2.Join(l1, -1)
+		require.NoError(t, err)
+
+		_, err = l2.Join(forger, -1)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrKeyNotValid.Error())
+
+		// the new key stays valid once the compromised rotation is known
+		l5, err := ipfslog.NewLog(ipfs, rotated, &ipfslog.LogOptions{
+			ID:    "A",
+			Clock: entry.NewLamportClock(rotated.PublicKey, l1.Clock.GetTime()),
+		})
+		require.NoError(t, err)
+
+		_, err = l5.Append(ctx, []byte("five"), nil)
+		require.NoError(t, err)
+
+		_, err = l2.Join(l5, -1)
+		require.NoError(t, err)
+	})
+
+	t.Run("statements of rejected entries aren't recorded", func(t *testing.T) {
+		compromised, err := idp.RotateKey(ctx, identity, &idp.RotateKeyOptions{
+			Keystore: keystore,
+			KeyID:    "compromised",
+			Start:    1,
+		})
+		require.NoError(t, err)
+
+		forger, err := ipfslog.NewLog(ipfs, compromised, &ipfslog.LogOptions{
+			ID:    "A",
+			Clock: entry.NewLamportClock(compromised.PublicKey, l1.Clock.GetTime()),
+		})
+		require.NoError(t, err)
+
+		_, err = forger.Append(ctx, []byte("forged"), nil)
+		require.NoError(t, err)
+
+		// both logs share the history, the forged entry is denied first
+		history := idp.NewKeyHistory()
+
+		denying, err := ipfslog.NewLog(ipfs, reference, &ipfslog.LogOptions{ID: "A", AccessController: &DenyAll{}, KeyHistory: history})
+		require.NoError(t, err)
+
+		_, err = denying.Join(forger, -1)
+		require.Error(t, err)
+
+		l2, err := ipfslog.NewLog(ipfs, reference, &ipfslog.LogOptions{ID: "A", KeyHistory: history})
+		require.NoError(t, err)
+
+		_, err = l2.Join(l1, -1)
+		require.NoError(t, err)
+		require.NoError(t, history.CheckKey(rotated, rotated.PublicKey, l1.Clock.GetTime()))
+	})
+}
@@ -0,0 +1,154 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-ipfs-log/enc"
+	"berty.tech/go-ipfs-log/entry"
+	idp "berty.tech/go-ipfs-log/identityprovider"
+	"berty.tech/go-ipfs-log/io/cbor"
+	"berty.tech/go-ipfs-log/io/ipldprime"
+	ks "berty.tech/go-ipfs-log/keystore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIOIPLDPrime(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := mocknet.New()
+	defer m.Close()
+	ipfs, closeNode := NewMemoryServices(ctx, t, m)
+	defer closeNode()
+
+	datastore := dssync.MutexWrap(NewIdentityDataStore(t))
+	keystore, err := ks.NewKeystore(datastore)
+	require.NoError(t, err)
+
+	identity, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+		Keystore: keystore,
+		ID:       "userA",
+		Type:     "orbitdb",
+	})
+	require.NoError(t, err)
+
+	cborio, err := cbor.IO(&entry.Entry{}, &entry.LamportClock{})
+	require.NoError(t, err)
+
+	primeCBOR, err := ipldprime.IO(&entry.Entry{}, &entry.LamportClock{})
+	require.NoError(t, err)
+
+	primeJSON := primeCBOR.ApplyOptions(&ipldprime.Options{Codec: ipldprime.DagJSON})
+
+	t.Run("dag-cbor output matches the cbor IO for v2 entries", func(t *testing.T) {
+		l, err := ipfslog.NewLog(ipfs, identity, &ipfslog.LogOptions{ID: "A", IO: cborio})
+		require.NoError(t, err)
+
+		_, err = l.Append(ctx, []byte("one"), nil)
+		require.NoError(t, err)
+
+		e, err := l.Append(ctx, []byte("two"), nil)
+		require.NoError(t, err)
+
+		decoded, err := entry.FromMultihashWithIO(ctx, ipfs, e.GetHash(), identity.Provider, primeCBOR)
+		require.NoError(t, err)
+		require.Equal(t, e.GetPayload(), decoded.GetPayload())
+		require.Equal(t, e.GetNext(), decoded.GetNext())
+		require.NoError(t, decoded.Verify(identity.Provider, primeCBOR))
+
+		c, err := primeCBOR.Write(ctx, ipfs, decoded, nil)
+		require.NoError(t, err)
+		require.Equal(t, e.GetHash().String(), c.String())
+	})
+
+	t.Run("dag-cbor output matches the cbor IO for v1 entries", func(t *testing.T) {
+		e := getEntriesV1Fixtures(t, identity)[0]
+
+		c, err := primeCBOR.Write(ctx, ipfs, &e, nil)
+		require.NoError(t, err)
+		require.Equal(t, CidB32(t, "zdpuAsJDrLKrAiU8M518eu6mgv9HzS3e1pfH5XC7LUsFgsK5c"), c.String())
+
+		decoded, err := entry.FromMultihashWithIO(ctx, ipfs, c, identity.Provider, primeCBOR)
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), decoded.GetV())
+		require.Equal(t, e.Payload, decoded.GetPayload())
+	})
+
+	t.Run("writes and loads a log using dag-json", func(t *testing.T) {
+		l, err := ipfslog.NewLog(ipfs, identity, &ipfslog.LogOptions{ID: "A", IO: primeJSON})
+		require.NoError(t, err)
+
+		for _, p := range []string{"one", "two", "three"} {
+			e, err := l.Append(ctx, []byte(p), &ipfslog.AppendOptions{PointerCount: 2})
+			require.NoError(t, err)
+			require.Equal(t, uint64(ipldprime.DagJSON), e.GetHash().Prefix().Codec)
+		}
+
+		h, err := l.ToMultihash(ctx)
+		require.NoError(t, err)
+		require.Equal(t, uint64(ipldprime.DagJSON), h.Prefix().Codec)
+
+		l2, err := ipfslog.NewFromMultihash(ctx, ipfs, identity, h, &ipfslog.LogOptions{IO: primeJSON}, &ipfslog.FetchOptions{})
+		require.NoError(t, err)
+		require.Equal(t, []string{"one", "two", "three"}, entriesAsStrings(l2.Values()))
+
+		l3, err := ipfslog.NewLog(ipfs, identity, &ipfslog.LogOptions{ID: "A", IO: primeJSON})
+		require.NoError(t, err)
+
+		_, err = l3.Join(l2, -1)
+		require.NoError(t, err)
+		require.Equal(t, 3, l3.Values().Len())
+	})
+
+	t.Run("reads dag-cbor entries when writing dag-json", func(t *testing.T) {
+		e, err := entry.CreateEntryWithIO(ctx, ipfs, identity, &entry.Entry{Payload: []byte("hello"), LogID: "A"}, nil, cborio)
+		require.NoError(t, err)
+
+		decoded, err := entry.FromMultihashWithIO(ctx, ipfs, e.GetHash(), identity.Provider, primeJSON)
+		require.NoError(t, err)
+		require.Equal(t, []byte("hello"), decoded.GetPayload())
+	})
+
+	t.Run("encrypted links are compatible with the cbor IO", func(t *testing.T) {
+		logKey, err := enc.NewSecretbox([]byte("0123456789abcdef0123456789abcdef"))
+		require.NoError(t, err)
+
+		encCBOR := cborio.ApplyOptions(&cbor.Options{LinkKey: logKey})
+		encPrime := primeCBOR.ApplyOptions(&ipldprime.Options{LinkKey: logKey})
+
+		l, err := ipfslog.NewLog(ipfs, identity, &ipfslog.LogOptions{ID: "X", IO: encCBOR})
+		require.NoError(t, err)
+
+		_, err = l.Append(ctx, []byte("one"), nil)
+		require.NoError(t, err)
+
+		e, err := l.Append(ctx, []byte("two"), nil)
+		require.NoError(t, err)
+
+		decoded, err := entry.FromMultihashWithIO(ctx, ipfs, e.GetHash(), identity.Provider, encPrime)
+		require.NoError(t, err)
+		require.Equal(t, e.GetNext(), decoded.GetNext())
+		require.NoError(t, decoded.Verify(identity.Provider, encPrime))
+
+		c, err := encPrime.Write(ctx, ipfs, e, nil)
+		require.NoError(t, err)
+		require.Equal(t, e.GetHash().String(), c.String())
+
+		l2, err := ipfslog.NewLog(ipfs, identity, &ipfslog.LogOptions{ID: "X", IO: encPrime})
+		require.NoError(t, err)
+
+		_, err = l2.Append(ctx, []byte("one"), nil)
+		require.NoError(t, err)
+
+		e2, err := l2.Append(ctx, []byte("two"), nil)
+		require.NoError(t, err)
+
+		decoded, err = entry.FromMultihashWithIO(ctx, ipfs, e2.GetHash(), identity.Provider, encCBOR)
+		require.NoError(t, err)
+		require.Equal(t, e2.GetNext(), decoded.GetNext())
+	})
+}
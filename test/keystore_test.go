@@ -0,0 +1,447 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/stretchr/testify/require"
+	"github.com/tyler-smith/go-bip39"
+
+	"berty.tech/go-ipfs-log/errmsg"
+	idp "berty.tech/go-ipfs-log/identityprovider"
+	ks "berty.tech/go-ipfs-log/keystore"
+)
+
+func TestEncryptedKeystore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	passphrase := &ks.EncryptionOptions{Passphrase: []byte("correct horse battery staple"), Memory: 1024, Threads: 1}
+
+	t.Run("encrypts keys at rest", func(t *testing.T) {
+		store := dssync.MutexWrap(ds.NewMapDatastore())
+
+		keystore, err := ks.NewEncryptedKeystore(ctx, store, passphrase)
+		require.NoError(t, err)
+
+		priv, err := keystore.CreateKey(ctx, "userA")
+		require.NoError(t, err)
+
+		raw, err := priv.Raw()
+		require.NoError(t, err)
+
+		stored, err := store.Get(ctx, ds.NewKey("userA"))
+		require.NoError(t, err)
+		require.False(t, bytes.Contains(stored, raw))
+
+		other, err := ks.NewEncryptedKeystore(ctx, store, passphrase)
+		require.NoError(t, err)
+
+		loaded, err := other.GetKey(ctx, "userA")
+		require.NoError(t, err)
+		require.True(t, priv.Equals(loaded))
+	})
+
+	t.Run("locks and unlocks", func(t *testing.T) {
+		store := dssync.MutexWrap(ds.NewMapDatastore())
+
+		keystore, err := ks.NewEncryptedKeystore(ctx, store, passphrase)
+		require.NoError(t, err)
+
+		priv, err := keystore.CreateKey(ctx, "userA")
+		require.NoError(t, err)
+
+		keystore.Lock()
+		require.True(t, keystore.IsLocked())
+
+		_, err = keystore.GetKey(ctx, "userA")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "keystore is locked")
+
+		_, err = keystore.CreateKey(ctx, "userB")
+		require.Error(t, err)
+
+		err = keystore.Unlock(ctx, &ks.EncryptionOptions{Passphrase: []byte("wrong"), Memory: 1024, Threads: 1})
+		require.Error(t, err)
+		require.True(t, keystore.IsLocked())
+
+		require.NoError(t, keystore.Unlock(ctx, passphrase))
+		require.False(t, keystore.IsLocked())
+
+		loaded, err := keystore.GetKey(ctx, "userA")
+		require.NoError(t, err)
+		require.True(t, priv.Equals(loaded))
+	})
+
+	t.Run("stores the key type", func(t *testing.T) {
+		store := dssync.MutexWrap(ds.NewMapDatastore())
+
+		keystore, err := ks.NewEncryptedKeystore(ctx, store, passphrase)
+		require.NoError(t, err)
+
+		for _, keyType := range []ks.KeyType{ks.KeyTypeSecp256k1, ks.KeyTypeEd25519, ks.KeyTypeECDSAP256} {
+			priv, err := keystore.CreateKeyWithType(ctx, string(keyType), keyType)
+			require.NoError(t, err)
+
+			keystore.Lock()
+			require.NoError(t, keystore.Unlock(ctx, passphrase))
+
+			loaded, err := keystore.GetKey(ctx, string(keyType))
+			require.NoError(t, err)
+			require.True(t, priv.Equals(loaded))
+
+			actual, err := ks.KeyTypeOf(loaded)
+			require.NoError(t, err)
+			require.Equal(t, keyType, actual)
+		}
+	})
+
+	t.Run("uses a provided secret", func(t *testing.T) {
+		store := dssync.MutexWrap(ds.NewMapDatastore())
+		secret := bytes.Repeat([]byte{1}, 32)
+
+		_, err := ks.NewEncryptedKeystore(ctx, store, &ks.EncryptionOptions{Secret: secret[:16]})
+		require.Error(t, err)
+
+		_, err = ks.NewEncryptedKeystore(ctx, store, &ks.EncryptionOptions{Secret: secret})
+		require.NoError(t, err)
+
+		_, err = ks.NewEncryptedKeystore(ctx, store, &ks.EncryptionOptions{Secret: bytes.Repeat([]byte{2}, 32)})
+		require.Error(t, err)
+	})
+
+	t.Run("encrypts keys stored in clear on migration", func(t *testing.T) {
+		store := dssync.MutexWrap(NewIdentityDataStore(t))
+
+		plain, err := ks.NewKeystore(store)
+		require.NoError(t, err)
+
+		identity, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+			Keystore: plain,
+			ID:       "userA",
+			Type:     "orbitdb",
+		})
+		require.NoError(t, err)
+
+		keystore, err := ks.NewEncryptedKeystore(ctx, store, passphrase)
+		require.NoError(t, err)
+
+		// clear keys aren't adopted implicitly
+		_, err = keystore.GetKey(ctx, "userA")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrDecrypt.Error())
+
+		stored, err := store.Get(ctx, ds.NewKey("userA"))
+		require.NoError(t, err)
+		require.Less(t, len(stored), 70)
+
+		require.NoError(t, keystore.MigrateClearKeys(ctx))
+		require.NoError(t, keystore.MigrateClearKeys(ctx))
+
+		encrypted, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+			Keystore: keystore,
+			ID:       "userA",
+			Type:     "orbitdb",
+		})
+		require.NoError(t, err)
+		require.Equal(t, identity.ID, encrypted.ID)
+		require.Equal(t, identity.PublicKey, encrypted.PublicKey)
+
+		stored, err = store.Get(ctx, ds.NewKey("userA"))
+		require.NoError(t, err)
+		require.Greater(t, len(stored), 32)
+
+		priv, err := keystore.GetKey(ctx, "userA")
+		require.NoError(t, err)
+
+		sig, err := keystore.Sign(priv, []byte("data"))
+		require.NoError(t, err)
+		require.NoError(t, keystore.Verify(sig, priv.GetPublic(), []byte("data")))
+	})
+}
+
+func TestKeystoreManagement(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	t.Run("lists and deletes keys", func(t *testing.T) {
+		keystore, err := ks.NewEncryptedKeystore(ctx, dssync.MutexWrap(ds.NewMapDatastore()), &ks.EncryptionOptions{Secret: bytes.Repeat([]byte{1}, 32)})
+		require.NoError(t, err)
+
+		_, err = keystore.CreateKey(ctx, "userA")
+		require.NoError(t, err)
+
+		_, err = keystore.CreateKeyWithType(ctx, "userB", ks.KeyTypeEd25519)
+		require.NoError(t, err)
+
+		ids, err := keystore.ListKeys(ctx)
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"userA", "userB"}, ids)
+
+		require.NoError(t, keystore.DeleteKey(ctx, "userA"))
+
+		ok, err := keystore.HasKey(ctx, "userA")
+		require.NoError(t, err)
+		require.False(t, ok)
+
+		_, err = keystore.GetKey(ctx, "userA")
+		require.Error(t, err)
+
+		err = keystore.DeleteKey(ctx, "userA")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrKeyNotInKeystore.Error())
+
+		ids, err = keystore.ListKeys(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []string{"userB"}, ids)
+	})
+
+	t.Run("doesn't expose the keystore metadata", func(t *testing.T) {
+		store := dssync.MutexWrap(ds.NewMapDatastore())
+		options := &ks.EncryptionOptions{Secret: bytes.Repeat([]byte{1}, 32)}
+
+		keystore, err := ks.NewEncryptedKeystore(ctx, store, options)
+		require.NoError(t, err)
+
+		priv, err := keystore.CreateKey(ctx, "userA")
+		require.NoError(t, err)
+
+		exported, err := keystore.ExportKey(ctx, "userA", nil)
+		require.NoError(t, err)
+
+		for _, id := range []string{"/.keystore/salt", "/.keystore/check", ".keystore/check", "/.keystore/seed", "/.keystore"} {
+			err = keystore.DeleteKey(ctx, id)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), errmsg.ErrKeyNotInKeystore.Error())
+
+			_, err = keystore.ExportKey(ctx, id, nil)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), errmsg.ErrKeyNotInKeystore.Error())
+
+			_, err = keystore.ImportKey(ctx, id, exported, nil)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), errmsg.ErrKeyImportFailed.Error())
+		}
+
+		reopened, err := ks.NewEncryptedKeystore(ctx, store, options)
+		require.NoError(t, err)
+
+		loaded, err := reopened.GetKey(ctx, "userA")
+		require.NoError(t, err)
+		require.True(t, priv.Equals(loaded))
+	})
+
+	t.Run("imports a key once when imported concurrently", func(t *testing.T) {
+		source, err := ks.NewKeystore(dssync.MutexWrap(ds.NewMapDatastore()))
+		require.NoError(t, err)
+
+		target, err := ks.NewKeystore(dssync.MutexWrap(ds.NewMapDatastore()))
+		require.NoError(t, err)
+
+		exported := make([][]byte, 8)
+		for i := range exported {
+			_, err = source.CreateKey(ctx, fmt.Sprintf("user%d", i))
+			require.NoError(t, err)
+
+			exported[i], err = source.ExportKey(ctx, fmt.Sprintf("user%d", i), nil)
+			require.NoError(t, err)
+		}
+
+		imported := make(chan crypto.PrivKey, len(exported))
+		wg := sync.WaitGroup{}
+		for _, data := range exported {
+			wg.Add(1)
+			go func(data []byte) {
+				defer wg.Done()
+
+				if priv, err := target.ImportKey(ctx, "userA", data, nil); err == nil {
+					imported <- priv
+				}
+			}(data)
+		}
+		wg.Wait()
+		close(imported)
+
+		require.Len(t, imported, 1)
+
+		loaded, err := target.GetKey(ctx, "userA")
+		require.NoError(t, err)
+		require.True(t, (<-imported).Equals(loaded))
+	})
+
+	for _, keyType := range []ks.KeyType{ks.KeyTypeSecp256k1, ks.KeyTypeEd25519, ks.KeyTypeECDSAP256} {
+		for _, format := range []ks.ExportFormat{ks.ExportFormatLibp2p, ks.ExportFormatPEM} {
+			keyType, format := keyType, format
+
+			t.Run(fmt.Sprintf("exports %s keys as %s", keyType, format), func(t *testing.T) {
+				source, err := ks.NewKeystore(dssync.MutexWrap(ds.NewMapDatastore()))
+				require.NoError(t, err)
+
+				priv, err := source.CreateKeyWithType(ctx, "userA", keyType)
+				require.NoError(t, err)
+
+				for _, passphrase := range [][]byte{nil, []byte("passphrase")} {
+					exported, err := source.ExportKey(ctx, "userA", &ks.ExportOptions{Format: format, Passphrase: passphrase})
+					require.NoError(t, err)
+
+					if format == ks.ExportFormatPEM {
+						block, _ := pem.Decode(exported)
+						require.NotNil(t, block)
+					}
+
+					target, err := ks.NewKeystore(dssync.MutexWrap(ds.NewMapDatastore()))
+					require.NoError(t, err)
+
+					if passphrase != nil {
+						_, err = target.ImportKey(ctx, "userA", exported, []byte("wrong"))
+						require.Error(t, err)
+					}
+
+					imported, err := target.ImportKey(ctx, "userA", exported, passphrase)
+					require.NoError(t, err)
+					require.True(t, priv.Equals(imported))
+
+					loaded, err := target.GetKey(ctx, "userA")
+					require.NoError(t, err)
+					require.True(t, priv.Equals(loaded))
+
+					_, err = target.ImportKey(ctx, "userA", exported, passphrase)
+					require.Error(t, err)
+					require.Contains(t, err.Error(), errmsg.ErrKeyAlreadyInKeystore.Error())
+				}
+			})
+		}
+	}
+
+	t.Run("bounds the PBKDF2 iteration count", func(t *testing.T) {
+		source, err := ks.NewKeystore(dssync.MutexWrap(ds.NewMapDatastore()))
+		require.NoError(t, err)
+
+		_, err = source.CreateKey(ctx, "userA")
+		require.NoError(t, err)
+
+		passphrase := []byte("passphrase")
+
+		exported, err := source.ExportKey(ctx, "userA", &ks.ExportOptions{Format: ks.ExportFormatPEM, Passphrase: passphrase})
+		require.NoError(t, err)
+
+		block, _ := pem.Decode(exported)
+		require.NotNil(t, block)
+
+		// the DER encoding of the 600000 iterations
+		iterations := []byte{0x02, 0x03, 0x09, 0x27, 0xc0}
+		require.Equal(t, 1, bytes.Count(block.Bytes, iterations))
+
+		for _, count := range [][]byte{{0x7f, 0xff, 0xff}, {0x80, 0x00, 0x00}} {
+			forged := *block
+			forged.Bytes = bytes.Replace(block.Bytes, iterations, append([]byte{0x02, 0x03}, count...), 1)
+
+			target, err := ks.NewKeystore(dssync.MutexWrap(ds.NewMapDatastore()))
+			require.NoError(t, err)
+
+			_, err = target.ImportKey(ctx, "userA", pem.EncodeToMemory(&forged), passphrase)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "iteration count")
+		}
+	})
+}
+
+func TestKeystoreMnemonic(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mnemonic, err := ks.NewMnemonic()
+	require.NoError(t, err)
+	require.Len(t, strings.Fields(mnemonic), 24)
+
+	newKeystore := func(t *testing.T) *ks.Keystore {
+		t.Helper()
+
+		keystore, err := ks.NewEncryptedKeystore(ctx, dssync.MutexWrap(ds.NewMapDatastore()), &ks.EncryptionOptions{Secret: bytes.Repeat([]byte{1}, 32)})
+		require.NoError(t, err)
+
+		return keystore
+	}
+
+	t.Run("restores identities on another device", func(t *testing.T) {
+		for _, keyType := range []ks.KeyType{ks.KeyTypeSecp256k1, ks.KeyTypeEd25519, ks.KeyTypeECDSAP256} {
+			device1 := newKeystore(t)
+			require.NoError(t, device1.UseMnemonic(ctx, mnemonic, "passphrase"))
+
+			device2 := newKeystore(t)
+			require.NoError(t, device2.UseMnemonic(ctx, mnemonic, "passphrase"))
+
+			identities := make([]*idp.Identity, 2)
+
+			for i, keystore := range []*ks.Keystore{device1, device2} {
+				identities[i], err = idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+					Keystore: keystore,
+					ID:       "userA",
+					Type:     "orbitdb",
+					KeyType:  keyType,
+				})
+				require.NoError(t, err)
+			}
+
+			require.Equal(t, identities[0].ID, identities[1].ID)
+			require.Equal(t, identities[0].PublicKey, identities[1].PublicKey)
+
+			other, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+				Keystore: device2,
+				ID:       "userB",
+				Type:     "orbitdb",
+				KeyType:  keyType,
+			})
+			require.NoError(t, err)
+			require.NotEqual(t, identities[0].ID, other.ID)
+		}
+	})
+
+	t.Run("depends on the passphrase", func(t *testing.T) {
+		seed1 := bip39Seed(t, mnemonic, "")
+		seed2 := bip39Seed(t, mnemonic, "passphrase")
+
+		key1, err := ks.DeriveKey(seed1, "userA", ks.KeyTypeEd25519)
+		require.NoError(t, err)
+
+		key2, err := ks.DeriveKey(seed2, "userA", ks.KeyTypeEd25519)
+		require.NoError(t, err)
+
+		require.False(t, key1.Equals(key2))
+	})
+
+	t.Run("rejects invalid mnemonics", func(t *testing.T) {
+		keystore := newKeystore(t)
+
+		words := strings.Fields(mnemonic)
+		words[0] = "notaword"
+
+		err := keystore.UseMnemonic(ctx, strings.Join(words, " "), "")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrInvalidMnemonic.Error())
+
+		other, err := ks.NewMnemonic()
+		require.NoError(t, err)
+
+		require.NoError(t, keystore.UseMnemonic(ctx, mnemonic, ""))
+		require.NoError(t, keystore.UseMnemonic(ctx, mnemonic, ""))
+		require.Error(t, keystore.UseMnemonic(ctx, other, ""))
+	})
+}
+
+func bip39Seed(t *testing.T, mnemonic, passphrase string) []byte {
+	t.Helper()
+
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, passphrase)
+	require.NoError(t, err)
+
+	return seed
+}
@@ -2,19 +2,28 @@ package test
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"math"
+	"strings"
 	"testing"
 
 	"berty.tech/go-ipfs-log/enc"
 	"berty.tech/go-ipfs-log/io/cbor"
+	"berty.tech/go-ipfs-log/io/ipldprime"
+	"berty.tech/go-ipfs-log/storage"
 
 	ipfslog "berty.tech/go-ipfs-log"
 	"berty.tech/go-ipfs-log/entry"
+	"berty.tech/go-ipfs-log/errmsg"
 	idp "berty.tech/go-ipfs-log/identityprovider"
 	"berty.tech/go-ipfs-log/iface"
 	"berty.tech/go-ipfs-log/keystore"
+	"github.com/ipfs/go-cid"
 	dssync "github.com/ipfs/go-datastore/sync"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"github.com/libp2p/go-libp2p/core/crypto"
 	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
 	"github.com/stretchr/testify/require"
 )
@@ -249,4 +258,654 @@ func TestLogAppendEncrypted(t *testing.T) {
 
 		require.Equal(t, result, []string{"helloA4"})
 	})
+
+	t.Run("verifies entries whose clear links are signed", func(t *testing.T) {
+		key, err := enc.NewSecretbox([]byte("0123456789abcdef0123456789abcdef"))
+		require.NoError(t, err)
+
+		io := cborioDefault.ApplyOptions(&cbor.Options{LinkKey: key})
+
+		store := storage.NewMemory()
+		fixtures := getLinkEncryptedEntriesFixtures(t)
+
+		for _, b := range fixtures {
+			require.NoError(t, store.Put(ctx, b))
+		}
+
+		for i, b := range fixtures {
+			e, err := entry.FromMultihashWithIO(ctx, store, b.Cid(), identity.Provider, io)
+			require.NoError(t, err)
+			require.Len(t, e.GetNext(), minInt(i, 1))
+			require.NotContains(t, e.GetAdditionalData(), iface.KeyEncryptedLinksVersion)
+			require.NoError(t, e.Verify(identity.Provider, io))
+		}
+
+		fetched, err := ipfslog.NewFromEntryHash(ctx, store, identity, fixtures[2].Cid(), &ipfslog.LogOptions{ID: "X", IO: io}, &ipfslog.FetchOptions{})
+		require.NoError(t, err)
+		require.Equal(t, []string{"hello1", "hello2", "hello3"}, entriesAsStrings(fetched.Values()))
+
+		l, err := ipfslog.NewLog(store, identity, &ipfslog.LogOptions{ID: "X", IO: io})
+		require.NoError(t, err)
+
+		_, err = l.Join(fetched, -1)
+		require.NoError(t, err)
+		require.Equal(t, 3, l.Values().Len())
+	})
+}
+
+func TestLogAppendEncryptedPayload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := storage.NewMemory()
+
+	datastore := dssync.MutexWrap(NewIdentityDataStore(t))
+	keystore, err := keystore.NewKeystore(datastore)
+	require.NoError(t, err)
+
+	identity, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+		Keystore: keystore,
+		ID:       "userA",
+		Type:     "orbitdb",
+	})
+	require.NoError(t, err)
+
+	cborioDefault, err := cbor.IO(&entry.Entry{}, &entry.LamportClock{})
+	require.NoError(t, err)
+
+	payloadKey, err := enc.NewSecretbox([]byte("0123456789abcdef0123456789abcdef"))
+	require.NoError(t, err)
+
+	payloadKeyDiff, err := enc.NewSecretbox([]byte("0123456789abcdef0123456789abcdeg"))
+	require.NoError(t, err)
+
+	linkKey, err := enc.NewSecretbox([]byte("abcdef0123456789abcdef0123456789"))
+	require.NoError(t, err)
+
+	cborio := cborioDefault.ApplyOptions(&cbor.Options{PayloadKey: payloadKey})
+	cborioLinks := cborioDefault.ApplyOptions(&cbor.Options{PayloadKey: payloadKey, LinkKey: linkKey})
+	cborioDiff := cborioDefault.ApplyOptions(&cbor.Options{PayloadKey: payloadKeyDiff})
+
+	appendAll := func(t *testing.T, l *ipfslog.IPFSLog, payloads ...string) iface.IPFSLogEntry {
+		t.Helper()
+
+		var last iface.IPFSLogEntry
+		for _, p := range payloads {
+			last, err = l.Append(ctx, []byte(p), nil)
+			require.NoError(t, err)
+		}
+
+		return last
+	}
+
+	t.Run("stores the payload encrypted", func(t *testing.T) {
+		l, err := ipfslog.NewLog(store, identity, &ipfslog.LogOptions{ID: "X", IO: cborio})
+		require.NoError(t, err)
+
+		e := appendAll(t, l, "secret message")
+		require.Equal(t, []byte("secret message"), e.GetPayload())
+
+		block, err := store.Get(ctx, e.GetHash())
+		require.NoError(t, err)
+		require.NotContains(t, string(block.RawData()), "secret message")
+
+		e2 := appendAll(t, l, "secret message")
+		require.NotEqual(t, e.GetAdditionalData()[iface.KeyEncryptedPayload], e2.GetAdditionalData()[iface.KeyEncryptedPayload])
+	})
+
+	t.Run("decrypts the payload transparently", func(t *testing.T) {
+		for _, io := range []iface.IO{cborio, cborioLinks} {
+			l, err := ipfslog.NewLog(store, identity, &ipfslog.LogOptions{ID: "X", IO: io})
+			require.NoError(t, err)
+
+			h := appendAll(t, l, "helloA1", "helloA2", "helloA3")
+
+			l2, err := ipfslog.NewFromEntryHash(ctx, store, identity, h.GetHash(), &ipfslog.LogOptions{ID: "X", IO: io}, &ipfslog.FetchOptions{})
+			require.NoError(t, err)
+			require.Equal(t, []string{"helloA1", "helloA2", "helloA3"}, entriesAsStrings(l2.Values()))
+
+			for _, e := range l2.Values().Slice() {
+				require.NoError(t, e.Verify(identity.Provider, io))
+			}
+		}
+	})
+
+	t.Run("verifies entries without the key", func(t *testing.T) {
+		l, err := ipfslog.NewLog(store, identity, &ipfslog.LogOptions{ID: "X", IO: cborioLinks})
+		require.NoError(t, err)
+
+		h := appendAll(t, l, "helloA1")
+
+		e, err := entry.FromMultihashWithIO(ctx, store, h.GetHash(), identity.Provider, cborioDefault)
+		require.NoError(t, err)
+		require.Empty(t, e.GetPayload())
+		require.NoError(t, e.Verify(identity.Provider, cborioDefault))
+
+		c, err := cborioDefault.Write(ctx, store, entry.Normalize(e, nil), nil)
+		require.NoError(t, err)
+		require.Equal(t, h.GetHash().String(), c.String())
+	})
+
+	t.Run("fails with a different key", func(t *testing.T) {
+		l, err := ipfslog.NewLog(store, identity, &ipfslog.LogOptions{ID: "X", IO: cborio})
+		require.NoError(t, err)
+
+		h := appendAll(t, l, "helloA1")
+
+		_, err = entry.FromMultihashWithIO(ctx, store, h.GetHash(), identity.Provider, cborioDiff)
+		require.Error(t, err)
+	})
+
+	t.Run("joins logs with encrypted payloads", func(t *testing.T) {
+		l1, err := ipfslog.NewLog(store, identity, &ipfslog.LogOptions{ID: "X", IO: cborioLinks})
+		require.NoError(t, err)
+
+		h := appendAll(t, l1, "helloA1", "helloA2")
+
+		l2, err := ipfslog.NewFromEntryHash(ctx, store, identity, h.GetHash(), &ipfslog.LogOptions{ID: "X", IO: cborioLinks}, &ipfslog.FetchOptions{})
+		require.NoError(t, err)
+
+		appendAll(t, l2, "helloB1")
+		appendAll(t, l1, "helloA3")
+
+		_, err = l1.Join(l2, -1)
+		require.NoError(t, err)
+		require.Equal(t, 4, l1.Values().Len())
+	})
+
+	t.Run("encrypted payloads are compatible with the ipld-prime IO", func(t *testing.T) {
+		primeio, err := ipldprime.IO(&entry.Entry{}, &entry.LamportClock{})
+		require.NoError(t, err)
+
+		primeio = primeio.ApplyOptions(&ipldprime.Options{PayloadKey: payloadKey, LinkKey: linkKey})
+
+		l, err := ipfslog.NewLog(store, identity, &ipfslog.LogOptions{ID: "X", IO: cborioLinks})
+		require.NoError(t, err)
+
+		h := appendAll(t, l, "helloA1", "helloA2")
+
+		e, err := entry.FromMultihashWithIO(ctx, store, h.GetHash(), identity.Provider, primeio)
+		require.NoError(t, err)
+		require.Equal(t, []byte("helloA2"), e.GetPayload())
+		require.Equal(t, h.GetNext(), e.GetNext())
+		require.NoError(t, e.Verify(identity.Provider, primeio))
+
+		c, err := primeio.Write(ctx, store, entry.Normalize(e, nil), nil)
+		require.NoError(t, err)
+		require.Equal(t, h.GetHash(), c)
+		require.Equal(t, uint64(cid.DagCBOR), c.Prefix().Codec)
+	})
+
+	t.Run("rejects clear links next to encrypted ones", func(t *testing.T) {
+		primeio, err := ipldprime.IO(&entry.Entry{}, &entry.LamportClock{})
+		require.NoError(t, err)
+
+		primeio = primeio.ApplyOptions(&ipldprime.Options{PayloadKey: payloadKey, LinkKey: linkKey})
+
+		l, err := ipfslog.NewLog(store, identity, &ipfslog.LogOptions{ID: "X", IO: cborioLinks})
+		require.NoError(t, err)
+
+		h := appendAll(t, l, "helloA1", "helloA2")
+
+		other, err := ipfslog.NewLog(store, identity, &ipfslog.LogOptions{ID: "X", IO: cborioLinks})
+		require.NoError(t, err)
+
+		injected := appendAll(t, other, "injected")
+
+		// a relay adds clear links to the entry, its signature still covers
+		// the encrypted ones only
+		block, err := store.Get(ctx, h.GetHash())
+		require.NoError(t, err)
+
+		raw := map[string]interface{}{}
+		require.NoError(t, cbornode.DecodeInto(block.RawData(), &raw))
+		require.Empty(t, raw["next"])
+
+		raw["next"] = []cid.Cid{injected.GetHash()}
+
+		forged, err := cbornode.WrapObject(raw, math.MaxUint64, -1)
+		require.NoError(t, err)
+		require.NoError(t, store.Put(ctx, forged))
+
+		for _, io := range []iface.IO{cborio, cborioLinks, primeio} {
+			_, err := entry.FromMultihashWithIO(ctx, store, forged.Cid(), identity.Provider, io)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), errmsg.ErrEntryLinksNotSigned.Error())
+		}
+	})
+}
+
+func TestLogAppendKeyRing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := storage.NewMemory()
+
+	datastore := dssync.MutexWrap(NewIdentityDataStore(t))
+	keystore, err := keystore.NewKeystore(datastore)
+	require.NoError(t, err)
+
+	identity, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+		Keystore: keystore,
+		ID:       "userA",
+		Type:     "orbitdb",
+	})
+	require.NoError(t, err)
+
+	cborioDefault, err := cbor.IO(&entry.Entry{}, &entry.LamportClock{})
+	require.NoError(t, err)
+
+	key1, err := enc.NewSecretbox([]byte("0123456789abcdef0123456789abcdef"))
+	require.NoError(t, err)
+
+	key2, err := enc.NewSecretbox([]byte("abcdef0123456789abcdef0123456789"))
+	require.NoError(t, err)
+
+	ring := enc.NewKeyRing()
+	require.NoError(t, ring.Add("k1", key1))
+
+	oldRing := enc.NewKeyRing()
+	require.NoError(t, oldRing.Add("k1", key1))
+
+	cborio := cborioDefault.ApplyOptions(&cbor.Options{LinkKeyRing: ring, PayloadKeyRing: ring})
+	cborioOld := cborioDefault.ApplyOptions(&cbor.Options{LinkKeyRing: oldRing, PayloadKeyRing: oldRing})
+
+	l, err := ipfslog.NewLog(store, identity, &ipfslog.LogOptions{ID: "X", IO: cborio})
+	require.NoError(t, err)
+
+	_, err = l.Append(ctx, []byte("helloA1"), nil)
+	require.NoError(t, err)
+
+	beforeRotation, err := l.Append(ctx, []byte("helloA2"), nil)
+	require.NoError(t, err)
+	require.Equal(t, "k1", beforeRotation.GetAdditionalData()[iface.KeyEncryptedLinksKeyID])
+	require.Equal(t, "k1", beforeRotation.GetAdditionalData()[iface.KeyEncryptedPayloadKeyID])
+
+	require.NoError(t, ring.Rotate("k2", key2))
+
+	_, err = l.Append(ctx, []byte("helloA3"), nil)
+	require.NoError(t, err)
+
+	afterRotation, err := l.Append(ctx, []byte("helloA4"), nil)
+	require.NoError(t, err)
+	require.Equal(t, "k2", afterRotation.GetAdditionalData()[iface.KeyEncryptedLinksKeyID])
+	require.Equal(t, "k2", afterRotation.GetAdditionalData()[iface.KeyEncryptedPayloadKeyID])
+
+	t.Run("reads entries encrypted before and after a rotation", func(t *testing.T) {
+		l2, err := ipfslog.NewFromEntryHash(ctx, store, identity, afterRotation.GetHash(), &ipfslog.LogOptions{ID: "X", IO: cborio}, &ipfslog.FetchOptions{})
+		require.NoError(t, err)
+		require.Equal(t, []string{"helloA1", "helloA2", "helloA3", "helloA4"}, entriesAsStrings(l2.Values()))
+
+		for _, e := range l2.Values().Slice() {
+			require.NoError(t, e.Verify(identity.Provider, cborio))
+		}
+	})
+
+	t.Run("can't read entries encrypted after a rotation with the old keys", func(t *testing.T) {
+		e, err := entry.FromMultihashWithIO(ctx, store, beforeRotation.GetHash(), identity.Provider, cborioOld)
+		require.NoError(t, err)
+		require.Equal(t, []byte("helloA2"), e.GetPayload())
+
+		_, err = entry.FromMultihashWithIO(ctx, store, afterRotation.GetHash(), identity.Provider, cborioOld)
+		require.Error(t, err)
+	})
+
+	t.Run("reads entries encrypted without key id", func(t *testing.T) {
+		legacyio := cborioDefault.ApplyOptions(&cbor.Options{LinkKey: key1, PayloadKey: key1})
+
+		l, err := ipfslog.NewLog(store, identity, &ipfslog.LogOptions{ID: "X", IO: legacyio})
+		require.NoError(t, err)
+
+		_, err = l.Append(ctx, []byte("hello1"), nil)
+		require.NoError(t, err)
+
+		h, err := l.Append(ctx, []byte("hello2"), nil)
+		require.NoError(t, err)
+		require.NotContains(t, h.GetAdditionalData(), iface.KeyEncryptedLinksKeyID)
+
+		l2, err := ipfslog.NewFromEntryHash(ctx, store, identity, h.GetHash(), &ipfslog.LogOptions{ID: "X", IO: cborio}, &ipfslog.FetchOptions{})
+		require.NoError(t, err)
+		require.Equal(t, []string{"hello1", "hello2"}, entriesAsStrings(l2.Values()))
+	})
+}
+
+func TestLogAppendRecipients(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := storage.NewMemory()
+
+	datastore := dssync.MutexWrap(NewIdentityDataStore(t))
+	keystore, err := keystore.NewKeystore(datastore)
+	require.NoError(t, err)
+
+	identity, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+		Keystore: keystore,
+		ID:       "userA",
+		Type:     "orbitdb",
+	})
+	require.NoError(t, err)
+
+	cborioDefault, err := cbor.IO(&entry.Entry{}, &entry.LamportClock{})
+	require.NoError(t, err)
+
+	var readers []*enc.BoxKeyPair
+	for i := 0; i < 3; i++ {
+		priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		require.NoError(t, err)
+
+		kp, err := enc.BoxKeyPairFromPrivKey(priv)
+		require.NoError(t, err)
+
+		readers = append(readers, kp)
+	}
+
+	recipients := []*enc.BoxPublicKey{readers[0].Public, readers[1].Public}
+
+	writer := cborioDefault.ApplyOptions(&cbor.Options{Recipients: recipients})
+
+	l, err := ipfslog.NewLog(store, identity, &ipfslog.LogOptions{ID: "X", IO: writer})
+	require.NoError(t, err)
+
+	_, err = l.Append(ctx, []byte("hello1"), nil)
+	require.NoError(t, err)
+
+	h, err := l.Append(ctx, []byte("hello2"), nil)
+	require.NoError(t, err)
+	require.Len(t, strings.Split(h.GetAdditionalData()[iface.KeyEncryptedRecipients], ","), 2)
+
+	t.Run("recipients read the entries", func(t *testing.T) {
+		for _, r := range readers[:2] {
+			readerio := cborioDefault.ApplyOptions(&cbor.Options{RecipientKey: r})
+
+			l2, err := ipfslog.NewFromEntryHash(ctx, store, identity, h.GetHash(), &ipfslog.LogOptions{ID: "X", IO: readerio}, &ipfslog.FetchOptions{})
+			require.NoError(t, err)
+			require.Equal(t, []string{"hello1", "hello2"}, entriesAsStrings(l2.Values()))
+
+			for _, e := range l2.Values().Slice() {
+				require.NoError(t, e.Verify(identity.Provider, readerio))
+			}
+		}
+	})
+
+	t.Run("other readers can't read the entries", func(t *testing.T) {
+		for _, io := range []*cbor.IOCbor{
+			cborioDefault,
+			cborioDefault.ApplyOptions(&cbor.Options{RecipientKey: readers[2]}),
+		} {
+			e, err := entry.FromMultihashWithIO(ctx, store, h.GetHash(), identity.Provider, io)
+			require.NoError(t, err)
+			require.Empty(t, e.GetPayload())
+			require.Empty(t, e.GetNext())
+			require.NoError(t, e.Verify(identity.Provider, io))
+		}
+	})
+
+	t.Run("sealed entries are compatible with the ipld-prime IO", func(t *testing.T) {
+		primeio, err := ipldprime.IO(&entry.Entry{}, &entry.LamportClock{})
+		require.NoError(t, err)
+
+		e, err := entry.FromMultihashWithIO(ctx, store, h.GetHash(), identity.Provider, primeio.ApplyOptions(&ipldprime.Options{RecipientKey: readers[1]}))
+		require.NoError(t, err)
+		require.Equal(t, []byte("hello2"), e.GetPayload())
+		require.Len(t, e.GetNext(), 1)
+
+		e.SetHash(cid.Undef)
+		c, err := primeio.Write(ctx, store, e, nil)
+		require.NoError(t, err)
+		require.Equal(t, h.GetHash(), c)
+	})
+}
+
+func TestLogAppendEncryptionAlgorithms(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := storage.NewMemory()
+
+	datastore := dssync.MutexWrap(NewIdentityDataStore(t))
+	keystore, err := keystore.NewKeystore(datastore)
+	require.NoError(t, err)
+
+	identity, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+		Keystore: keystore,
+		ID:       "userA",
+		Type:     "orbitdb",
+	})
+	require.NoError(t, err)
+
+	cborioDefault, err := cbor.IO(&entry.Entry{}, &entry.LamportClock{})
+	require.NoError(t, err)
+
+	boxKey, err := enc.NewSecretbox([]byte("0123456789abcdef0123456789abcdef"))
+	require.NoError(t, err)
+
+	xchachaKey, err := enc.NewXChaCha20Poly1305([]byte("abcdef0123456789abcdef0123456789"))
+	require.NoError(t, err)
+
+	ring := enc.NewKeyRing()
+	require.NoError(t, ring.Add("box", boxKey))
+
+	cborio := cborioDefault.ApplyOptions(&cbor.Options{LinkKeyRing: ring, PayloadKeyRing: ring})
+
+	l, err := ipfslog.NewLog(store, identity, &ipfslog.LogOptions{ID: "X", IO: cborio})
+	require.NoError(t, err)
+
+	_, err = l.Append(ctx, []byte("hello1"), nil)
+	require.NoError(t, err)
+
+	boxed, err := l.Append(ctx, []byte("hello2"), nil)
+	require.NoError(t, err)
+	require.NotContains(t, boxed.GetAdditionalData(), iface.KeyEncryptedLinksAlgorithm)
+	require.NotContains(t, boxed.GetAdditionalData(), iface.KeyEncryptedPayloadAlgorithm)
+
+	require.NoError(t, ring.Rotate("xchacha", xchachaKey))
+
+	last, err := l.Append(ctx, []byte("hello3"), nil)
+	require.NoError(t, err)
+	require.Equal(t, enc.AlgorithmXChaCha20Poly1305, last.GetAdditionalData()[iface.KeyEncryptedLinksAlgorithm])
+	require.Equal(t, enc.AlgorithmXChaCha20Poly1305, last.GetAdditionalData()[iface.KeyEncryptedPayloadAlgorithm])
+
+	t.Run("reads entries using both algorithms", func(t *testing.T) {
+		l2, err := ipfslog.NewFromEntryHash(ctx, store, identity, last.GetHash(), &ipfslog.LogOptions{ID: "X", IO: cborio}, &ipfslog.FetchOptions{})
+		require.NoError(t, err)
+		require.Equal(t, []string{"hello1", "hello2", "hello3"}, entriesAsStrings(l2.Values()))
+
+		for _, e := range l2.Values().Slice() {
+			require.NoError(t, e.Verify(identity.Provider, cborio))
+		}
+	})
+
+	t.Run("uses random nonces for identical content", func(t *testing.T) {
+		xchachaio := cborioDefault.ApplyOptions(&cbor.Options{LinkKey: xchachaKey, PayloadKey: xchachaKey})
+
+		var entries []iface.IPFSLogEntry
+		for i := 0; i < 2; i++ {
+			e, err := entry.CreateEntryWithIO(ctx, store, identity, &entry.Entry{
+				LogID:   "Y",
+				Payload: []byte("same"),
+				Next:    []cid.Cid{boxed.GetHash()},
+				Clock:   entry.NewLamportClock(identity.PublicKey, 1),
+			}, nil, xchachaio)
+			require.NoError(t, err)
+
+			entries = append(entries, e)
+		}
+
+		require.NotEqual(t, entries[0].GetAdditionalData()[iface.KeyEncryptedLinksNonce], entries[1].GetAdditionalData()[iface.KeyEncryptedLinksNonce])
+		require.NotEqual(t, entries[0].GetAdditionalData()[iface.KeyEncryptedPayload], entries[1].GetAdditionalData()[iface.KeyEncryptedPayload])
+
+		e, err := entry.FromMultihashWithIO(ctx, store, entries[0].GetHash(), identity.Provider, xchachaio)
+		require.NoError(t, err)
+		require.Equal(t, []byte("same"), e.GetPayload())
+		require.Equal(t, []cid.Cid{boxed.GetHash()}, e.GetNext())
+	})
+
+	t.Run("fails with a key of another algorithm", func(t *testing.T) {
+		sameBytes, err := enc.NewSecretbox([]byte("abcdef0123456789abcdef0123456789"))
+		require.NoError(t, err)
+
+		_, err = entry.FromMultihashWithIO(ctx, store, last.GetHash(), identity.Provider, cborioDefault.ApplyOptions(&cbor.Options{LinkKey: sameBytes, PayloadKey: sameBytes}))
+		require.Error(t, err)
+	})
+}
+
+func TestLogAppendEncryptedEnvelope(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := storage.NewMemory()
+
+	datastore := dssync.MutexWrap(NewIdentityDataStore(t))
+	keystore, err := keystore.NewKeystore(datastore)
+	require.NoError(t, err)
+
+	identity, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+		Keystore: keystore,
+		ID:       "userA",
+		Type:     "orbitdb",
+	})
+	require.NoError(t, err)
+
+	cborioDefault, err := cbor.IO(&entry.Entry{}, &entry.LamportClock{})
+	require.NoError(t, err)
+
+	envelopeKey, err := enc.NewXChaCha20Poly1305([]byte("0123456789abcdef0123456789abcdef"))
+	require.NoError(t, err)
+
+	payloadKey, err := enc.NewSecretbox([]byte("abcdef0123456789abcdef0123456789"))
+	require.NoError(t, err)
+
+	ring := enc.NewKeyRing()
+	require.NoError(t, ring.Add("k1", envelopeKey))
+
+	cborio := cborioDefault.ApplyOptions(&cbor.Options{EnvelopeKeyRing: ring, PayloadKey: payloadKey})
+
+	l, err := ipfslog.NewLog(store, identity, &ipfslog.LogOptions{ID: "private-log", IO: cborio})
+	require.NoError(t, err)
+
+	_, err = l.Append(ctx, []byte("hello1"), nil)
+	require.NoError(t, err)
+
+	h, err := l.Append(ctx, []byte("hello2"), nil)
+	require.NoError(t, err)
+
+	t.Run("hides the metadata of the entries", func(t *testing.T) {
+		block, err := store.Get(ctx, h.GetHash())
+		require.NoError(t, err)
+
+		raw := string(block.RawData())
+		for _, leak := range []string{"private-log", "userA", hex.EncodeToString(identity.PublicKey), hex.EncodeToString(h.GetSig()), "clock", "identity"} {
+			require.NotContains(t, raw, leak)
+		}
+
+		require.Contains(t, raw, "enc_entry")
+		require.Contains(t, raw, "k1")
+	})
+
+	t.Run("reads and verifies entries with the key", func(t *testing.T) {
+		l2, err := ipfslog.NewFromEntryHash(ctx, store, identity, h.GetHash(), &ipfslog.LogOptions{ID: "private-log", IO: cborio}, &ipfslog.FetchOptions{})
+		require.NoError(t, err)
+		require.Equal(t, []string{"hello1", "hello2"}, entriesAsStrings(l2.Values()))
+
+		for _, e := range l2.Values().Slice() {
+			require.Equal(t, identity.ID, e.GetIdentity().ID)
+			require.NoError(t, e.Verify(identity.Provider, cborio))
+		}
+	})
+
+	t.Run("fails without the key", func(t *testing.T) {
+		for _, io := range []*cbor.IOCbor{
+			cborioDefault,
+			cborioDefault.ApplyOptions(&cbor.Options{PayloadKey: payloadKey, EnvelopeKey: payloadKey}),
+		} {
+			_, err := entry.FromMultihashWithIO(ctx, store, h.GetHash(), identity.Provider, io)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "decryption error")
+		}
+	})
+
+	t.Run("reads entries before and after a rotation", func(t *testing.T) {
+		key2, err := enc.NewSecretbox([]byte("0123456789abcdef0123456789abcdeg"))
+		require.NoError(t, err)
+
+		require.NoError(t, ring.Rotate("k2", key2))
+
+		h3, err := l.Append(ctx, []byte("hello3"), nil)
+		require.NoError(t, err)
+
+		block, err := store.Get(ctx, h3.GetHash())
+		require.NoError(t, err)
+		require.Contains(t, string(block.RawData()), "k2")
+
+		l2, err := ipfslog.NewFromEntryHash(ctx, store, identity, h3.GetHash(), &ipfslog.LogOptions{ID: "private-log", IO: cborio}, &ipfslog.FetchOptions{})
+		require.NoError(t, err)
+		require.Equal(t, []string{"hello1", "hello2", "hello3"}, entriesAsStrings(l2.Values()))
+	})
+}
+
+func TestLogAppendPaddedPayload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := storage.NewMemory()
+
+	datastore := dssync.MutexWrap(NewIdentityDataStore(t))
+	keystore, err := keystore.NewKeystore(datastore)
+	require.NoError(t, err)
+
+	identity, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+		Keystore: keystore,
+		ID:       "userA",
+		Type:     "orbitdb",
+	})
+	require.NoError(t, err)
+
+	cborioDefault, err := cbor.IO(&entry.Entry{}, &entry.LamportClock{})
+	require.NoError(t, err)
+
+	payloadKey, err := enc.NewSecretbox([]byte("0123456789abcdef0123456789abcdef"))
+	require.NoError(t, err)
+
+	cborio := cborioDefault.ApplyOptions(&cbor.Options{PayloadKey: payloadKey, PayloadPadding: enc.PadToPowerOfTwo(64)})
+	cborioNoPadding := cborioDefault.ApplyOptions(&cbor.Options{PayloadKey: payloadKey})
+
+	l, err := ipfslog.NewLog(store, identity, &ipfslog.LogOptions{ID: "X", IO: cborio})
+	require.NoError(t, err)
+
+	payloads := []string{"a", "a longer message\x00\x00", string(make([]byte, 100))}
+
+	var entries []iface.IPFSLogEntry
+	for _, p := range payloads {
+		e, err := l.Append(ctx, []byte(p), nil)
+		require.NoError(t, err)
+		require.Equal(t, enc.PaddingISO7816, e.GetAdditionalData()[iface.KeyEncryptedPayloadPadding])
+
+		entries = append(entries, e)
+	}
+
+	t.Run("hides the size of the payloads", func(t *testing.T) {
+		sizes := make([]int, len(entries))
+		for i, e := range entries {
+			sizes[i] = len(e.GetAdditionalData()[iface.KeyEncryptedPayload])
+		}
+
+		require.Equal(t, sizes[0], sizes[1])
+		require.Greater(t, sizes[2], sizes[1])
+	})
+
+	t.Run("removes the padding when reading", func(t *testing.T) {
+		primeio, err := ipldprime.IO(&entry.Entry{}, &entry.LamportClock{})
+		require.NoError(t, err)
+
+		for _, io := range []iface.IO{cborio, cborioNoPadding, primeio.ApplyOptions(&ipldprime.Options{PayloadKey: payloadKey})} {
+			l2, err := ipfslog.NewFromEntryHash(ctx, store, identity, entries[2].GetHash(), &ipfslog.LogOptions{ID: "X", IO: io}, &ipfslog.FetchOptions{})
+			require.NoError(t, err)
+			require.Equal(t, payloads, entriesAsStrings(l2.Values()))
+
+			for _, e := range l2.Values().Slice() {
+				require.NoError(t, e.Verify(identity.Provider, io))
+			}
+		}
+	})
 }
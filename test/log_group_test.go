@@ -0,0 +1,266 @@
+package test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-ipfs-log/enc"
+	"berty.tech/go-ipfs-log/entry"
+	"berty.tech/go-ipfs-log/errmsg"
+	idp "berty.tech/go-ipfs-log/identityprovider"
+	"berty.tech/go-ipfs-log/iface"
+	"berty.tech/go-ipfs-log/io/cbor"
+	ks "berty.tech/go-ipfs-log/keystore"
+	"berty.tech/go-ipfs-log/storage"
+)
+
+// groupPayloads returns the payloads of the entries which don't publish an
+// epoch, in order.
+func groupPayloads(l *ipfslog.IPFSLog) []string {
+	var out []string
+
+	for _, e := range l.Values().Slice() {
+		if _, ok := ipfslog.ParseGroupEpoch(e); ok {
+			continue
+		}
+
+		out = append(out, string(e.GetPayload()))
+	}
+
+	return out
+}
+
+func TestLogGroup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := storage.NewMemory()
+
+	datastore := dssync.MutexWrap(NewIdentityDataStore(t))
+	keystore, err := ks.NewKeystore(datastore)
+	require.NoError(t, err)
+
+	var identities []*idp.Identity
+	var keys []*enc.BoxKeyPair
+	var members []*ipfslog.GroupMember
+
+	for _, char := range []rune{'A', 'B', 'C'} {
+		identity, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+			Keystore: keystore,
+			ID:       fmt.Sprintf("user%c", char),
+			Type:     "orbitdb",
+		})
+		require.NoError(t, err)
+
+		member, err := enc.GenerateBoxKeyPair()
+		require.NoError(t, err)
+
+		identities = append(identities, identity)
+		keys = append(keys, member)
+		members = append(members, ipfslog.NewGroupMember(identity.ID, member.Public))
+	}
+
+	options := &ipfslog.GroupOptions{Admins: []string{identities[0].ID}}
+
+	load := func(t *testing.T, i int, head iface.IPFSLogEntry) (*ipfslog.Group, *ipfslog.IPFSLog) {
+		t.Helper()
+
+		group, err := ipfslog.NewGroup(keys[i], options)
+		require.NoError(t, err)
+
+		l, err := ipfslog.NewFromEntryHash(ctx, store, identities[i], head.GetHash(), &ipfslog.LogOptions{ID: "X", IO: group.IO()}, &ipfslog.FetchOptions{})
+		require.NoError(t, err)
+		require.NoError(t, group.Sync(l))
+
+		for _, e := range l.Values().Slice() {
+			require.NoError(t, e.Verify(identities[i].Provider, group.IO()))
+		}
+
+		return group, l
+	}
+
+	_, err = ipfslog.NewGroup(keys[0], nil)
+	require.Error(t, err)
+
+	groupA, err := ipfslog.NewGroup(keys[0], options)
+	require.NoError(t, err)
+
+	logA, err := ipfslog.NewLog(store, identities[0], &ipfslog.LogOptions{ID: "X", IO: groupA.IO()})
+	require.NoError(t, err)
+
+	_, err = logA.Append(ctx, []byte("no epoch"), nil)
+	require.ErrorIs(t, err, errmsg.ErrGroupNoEpoch)
+	require.Equal(t, 0, logA.Len())
+
+	epoch1, err := groupA.Rotate(ctx, logA, []*ipfslog.GroupMember{members[0], members[1]}, nil)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), groupA.Epoch())
+
+	parsed, ok := ipfslog.ParseGroupEpoch(epoch1)
+	require.True(t, ok)
+	require.Len(t, parsed.Members, 2)
+
+	_, err = logA.Append(ctx, []byte("a1"), nil)
+	require.NoError(t, err)
+
+	a2, err := logA.Append(ctx, []byte("a2"), nil)
+	require.NoError(t, err)
+	require.Equal(t, parsed.KeyID(), a2.GetAdditionalData()[iface.KeyEncryptedPayloadKeyID])
+
+	var b1 iface.IPFSLogEntry
+
+	t.Run("members read and write entries of the epoch", func(t *testing.T) {
+		groupB, logB := load(t, 1, a2)
+		require.Equal(t, uint64(1), groupB.Epoch())
+		require.Equal(t, []string{"a1", "a2"}, groupPayloads(logB))
+
+		b1, err = logB.Append(ctx, []byte("b1"), nil)
+		require.NoError(t, err)
+
+		_, err = logA.Join(logB, -1)
+		require.NoError(t, err)
+		require.NoError(t, groupA.Sync(logA))
+		require.Equal(t, []string{"a1", "a2", "b1"}, groupPayloads(logA))
+	})
+
+	t.Run("non members can't read entries", func(t *testing.T) {
+		groupC, logC := load(t, 2, a2)
+		require.Equal(t, uint64(0), groupC.Epoch())
+		require.Equal(t, []string{"", ""}, groupPayloads(logC))
+
+		require.Empty(t, groupC.Members())
+
+		_, err := logC.Append(ctx, []byte("c1"), nil)
+		require.ErrorIs(t, err, errmsg.ErrGroupNoEpoch)
+	})
+
+	epoch2, err := groupA.AddMember(ctx, logA, members[2], nil)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), groupA.Epoch())
+
+	_, err = groupA.AddMember(ctx, logA, members[2], nil)
+	require.Error(t, err)
+
+	a3, err := logA.Append(ctx, []byte("a3"), nil)
+	require.NoError(t, err)
+
+	t.Run("added members can't read previous epochs", func(t *testing.T) {
+		groupC, logC := load(t, 2, a3)
+		require.Equal(t, uint64(2), groupC.Epoch())
+		require.Equal(t, []string{"", "", "", "a3"}, groupPayloads(logC))
+
+		require.Equal(t, []*ipfslog.GroupMember{members[0], members[1], members[2]}, groupC.Members())
+	})
+
+	epoch3, err := groupA.RemoveMember(ctx, logA, members[1].ID, nil)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), groupA.Epoch())
+
+	a4, err := logA.Append(ctx, []byte("a4"), nil)
+	require.NoError(t, err)
+
+	t.Run("removed members can't read next epochs", func(t *testing.T) {
+		groupB, logB := load(t, 1, a4)
+		require.Equal(t, uint64(2), groupB.Epoch())
+		require.Equal(t, []string{"a1", "a2", "b1", "a3", ""}, groupPayloads(logB))
+
+		groupC, logC := load(t, 2, a4)
+		require.Equal(t, uint64(3), groupC.Epoch())
+		require.Equal(t, []string{"", "", "", "a3", "a4"}, groupPayloads(logC))
+	})
+
+	t.Run("entries are read without the group", func(t *testing.T) {
+		cborio, err := cbor.IO(&entry.Entry{}, &entry.LamportClock{})
+		require.NoError(t, err)
+
+		for _, h := range []iface.IPFSLogEntry{b1, a4} {
+			e, err := entry.FromMultihashWithIO(ctx, store, h.GetHash(), identities[0].Provider, cborio)
+			require.NoError(t, err)
+			require.Empty(t, e.GetPayload())
+			require.NotEmpty(t, e.GetNext())
+			require.NoError(t, e.Verify(identities[0].Provider, cborio))
+		}
+	})
+
+	t.Run("removed members can't publish epochs", func(t *testing.T) {
+		groupB, logB := load(t, 1, a4)
+
+		_, err := groupB.Rotate(ctx, logB, members, nil)
+		require.Error(t, err)
+
+		parsed2, ok := ipfslog.ParseGroupEpoch(epoch2)
+		require.True(t, ok)
+
+		parsed3, ok := ipfslog.ParseGroupEpoch(epoch3)
+		require.True(t, ok)
+
+		// the removed member seals the forged epochs for itself and for the
+		// members
+		forge := func(number uint64, previous string) {
+			key := make([]byte, enc.SecretBoxKeySize)
+			_, err := rand.Read(key)
+			require.NoError(t, err)
+
+			sealed, err := enc.SealForRecipients(key, []*enc.BoxPublicKey{keys[0].Public, keys[1].Public, keys[2].Public})
+			require.NoError(t, err)
+
+			epoch := &ipfslog.GroupEpoch{
+				Type:      ipfslog.GroupEpochType,
+				Epoch:     number,
+				Previous:  previous,
+				Members:   members,
+				Algorithm: enc.AlgorithmXChaCha20Poly1305,
+			}
+
+			for _, s := range sealed {
+				epoch.Keys = append(epoch.Keys, base64.StdEncoding.EncodeToString(s))
+			}
+
+			payload, err := json.Marshal(epoch)
+			require.NoError(t, err)
+
+			_, err = logB.Append(ctx, payload, nil)
+			require.NoError(t, err)
+		}
+
+		forge(3, parsed2.KeyID())
+		forge(4, parsed3.KeyID())
+
+		_, err = logA.Join(logB, -1)
+		require.NoError(t, err)
+		require.NoError(t, groupA.Sync(logA))
+		require.Equal(t, uint64(3), groupA.Epoch())
+		require.Equal(t, []*ipfslog.GroupMember{members[0], members[2]}, groupA.Members())
+
+		a5, err := logA.Append(ctx, []byte("a5"), nil)
+		require.NoError(t, err)
+		require.Equal(t, parsed3.KeyID(), a5.GetAdditionalData()[iface.KeyEncryptedPayloadKeyID])
+
+		groupC, logC := load(t, 2, a5)
+		require.Equal(t, uint64(3), groupC.Epoch())
+		require.Contains(t, groupPayloads(logC), "a5")
+
+		_, logB = load(t, 1, a5)
+		require.NotContains(t, groupPayloads(logB), "a5")
+	})
+
+	t.Run("only admins publish the first epoch", func(t *testing.T) {
+		groupC, err := ipfslog.NewGroup(keys[2], options)
+		require.NoError(t, err)
+
+		logC, err := ipfslog.NewLog(store, identities[2], &ipfslog.LogOptions{ID: "Y", IO: groupC.IO()})
+		require.NoError(t, err)
+
+		_, err = groupC.Rotate(ctx, logC, members, nil)
+		require.Error(t, err)
+		require.Equal(t, uint64(0), groupC.Epoch())
+	})
+}
@@ -10,7 +10,10 @@ import (
 	"testing"
 	"time"
 
+	"berty.tech/go-ipfs-log/enc"
+	"berty.tech/go-ipfs-log/io/cbor"
 	"berty.tech/go-ipfs-log/io/pb"
+	"berty.tech/go-ipfs-log/storage"
 
 	ipfslog "berty.tech/go-ipfs-log"
 	"berty.tech/go-ipfs-log/entry"
@@ -21,6 +24,7 @@ import (
 	ks "berty.tech/go-ipfs-log/keystore"
 	cid "github.com/ipfs/go-cid"
 	dssync "github.com/ipfs/go-datastore/sync"
+	dag "github.com/ipfs/go-merkledag"
 	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
 	"github.com/stretchr/testify/require"
 )
@@ -1150,5 +1154,150 @@ func TestLogLoad(t *testing.T) {
 
 			require.Equal(t, 2, log.Entries.Len())
 		})
+
+		t.Run("creates a log from a v0 multihash", func(t *testing.T) {
+			// JSONLog as written by js-ipfs-log using ipfs.object.put
+			legacy := &dag.ProtoNode{}
+			legacy.SetData([]byte(`{"id":"A","heads":["QmZ8va2fSjRufV1sD6x5mwi6E5GrSjXHx7RiKFVBzkiUNZ"]}`))
+			require.NoError(t, ipfs.Put(ctx, legacy))
+
+			log, err := ipfslog.NewFromMultihash(ctx, ipfs, testIdentity, legacy.Cid(), &ipfslog.LogOptions{IO: pbio}, &ipfslog.FetchOptions{})
+			require.NoError(t, err)
+
+			require.Equal(t, "A", log.ID)
+			require.Equal(t, 2, log.Values().Len())
+			require.Equal(t, "hello world", string(log.Values().At(0).GetPayload()))
+			require.Equal(t, "hello again", string(log.Values().At(1).GetPayload()))
+			require.Equal(t, 1, len(log.Heads().Slice()))
+			require.Equal(t, v0Entries["helloAgain"].Hash.String(), log.Heads().At(0).GetHash().String())
+
+			c, err := log.ToMultihash(ctx)
+			require.NoError(t, err)
+			require.Equal(t, legacy.Cid().String(), c.String())
+		})
+
+		t.Run("pins v0 entries", func(t *testing.T) {
+			store := storage.NewMemory()
+
+			c, err := pbio.Write(ctx, store, entry.Normalize(v0Entries["hello"], nil), &iface.WriteOpts{Pin: true})
+			require.NoError(t, err)
+			require.Equal(t, v0Entries["hello"].Hash.String(), c.String())
+			require.True(t, store.IsPinned(c))
+		})
+
+		t.Run("refuses to write newer entries", func(t *testing.T) {
+			e := entry.Normalize(v0Entries["hello"], nil)
+			e.V = 2
+
+			_, err := pbio.Write(ctx, ipfs, e, nil)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), errmsg.ErrEntryVersionNotSupported.Error())
+		})
+	})
+}
+
+func TestLogLoadEncryptedHeads(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := storage.NewMemory()
+
+	datastore := dssync.MutexWrap(NewIdentityDataStore(t))
+	keystore, err := ks.NewKeystore(datastore)
+	require.NoError(t, err)
+
+	identity, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+		Keystore: keystore,
+		ID:       "userA",
+		Type:     "orbitdb",
+	})
+	require.NoError(t, err)
+
+	cborioDefault, err := cbor.IO(&entry.Entry{}, &entry.LamportClock{})
+	require.NoError(t, err)
+
+	headsKey, err := enc.NewXChaCha20Poly1305([]byte("0123456789abcdef0123456789abcdef"))
+	require.NoError(t, err)
+
+	newLog := func(t *testing.T, id string, io iface.IO) *ipfslog.IPFSLog {
+		t.Helper()
+
+		l, err := ipfslog.NewLog(store, identity, &ipfslog.LogOptions{ID: id, IO: io})
+		require.NoError(t, err)
+
+		for i := 1; i <= 2; i++ {
+			_, err := l.Append(ctx, []byte(fmt.Sprintf("hello%d", i)), nil)
+			require.NoError(t, err)
+		}
+
+		return l
+	}
+
+	t.Run("encrypts the heads with a shared key", func(t *testing.T) {
+		cborio := cborioDefault.ApplyOptions(&cbor.Options{HeadsKey: headsKey})
+		l := newLog(t, "private-log", cborio)
+
+		hash, err := l.ToMultihash(ctx)
+		require.NoError(t, err)
+
+		block, err := store.Get(ctx, hash)
+		require.NoError(t, err)
+		require.NotContains(t, string(block.RawData()), "private-log")
+		require.NotContains(t, string(block.RawData()), l.Heads().At(0).GetHash().String())
+
+		loaded, err := ipfslog.NewFromMultihash(ctx, store, identity, hash, &ipfslog.LogOptions{IO: cborio}, &ipfslog.FetchOptions{})
+		require.NoError(t, err)
+		require.Equal(t, "private-log", loaded.GetID())
+		require.Equal(t, []string{"hello1", "hello2"}, entriesAsStrings(loaded.Values()))
+
+		_, err = ipfslog.NewFromMultihash(ctx, store, identity, hash, &ipfslog.LogOptions{IO: cborioDefault}, &ipfslog.FetchOptions{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "decryption error")
+	})
+
+	t.Run("seals the heads for recipients", func(t *testing.T) {
+		var readers []*enc.BoxKeyPair
+		for i := 0; i < 3; i++ {
+			kp, err := enc.GenerateBoxKeyPair()
+			require.NoError(t, err)
+
+			readers = append(readers, kp)
+		}
+
+		writer := cborioDefault.ApplyOptions(&cbor.Options{
+			Recipients:   []*enc.BoxPublicKey{readers[0].Public, readers[1].Public},
+			RecipientKey: readers[0],
+			SealHeads:    true,
+		})
+		l := newLog(t, "sealed-log", writer)
+
+		hash, err := l.ToMultihash(ctx)
+		require.NoError(t, err)
+
+		block, err := store.Get(ctx, hash)
+		require.NoError(t, err)
+		require.NotContains(t, string(block.RawData()), "sealed-log")
+
+		loaded, err := ipfslog.NewFromMultihash(ctx, store, identity, hash, &ipfslog.LogOptions{IO: cborioDefault.ApplyOptions(&cbor.Options{RecipientKey: readers[1]})}, &ipfslog.FetchOptions{})
+		require.NoError(t, err)
+		require.Equal(t, []string{"hello1", "hello2"}, entriesAsStrings(loaded.Values()))
+
+		_, err = ipfslog.NewFromMultihash(ctx, store, identity, hash, &ipfslog.LogOptions{IO: cborioDefault.ApplyOptions(&cbor.Options{RecipientKey: readers[2]})}, &ipfslog.FetchOptions{})
+		require.Error(t, err)
+	})
+
+	t.Run("rejects heads of another log", func(t *testing.T) {
+		cborio := cborioDefault.ApplyOptions(&cbor.Options{HeadsKey: headsKey})
+		other := newLog(t, "other-log", cborio)
+
+		hash, err := cborio.Write(ctx, store, &iface.JSONLog{
+			ID:    "private-log",
+			Heads: []cid.Cid{other.Heads().At(0).GetHash()},
+		}, nil)
+		require.NoError(t, err)
+
+		_, err = ipfslog.NewFromMultihash(ctx, store, identity, hash, &ipfslog.LogOptions{IO: cborio}, &ipfslog.FetchOptions{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrHeadsMismatch.Error())
 	})
 }
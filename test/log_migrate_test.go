@@ -0,0 +1,163 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-ipfs-log/entry"
+	idp "berty.tech/go-ipfs-log/identityprovider"
+	"berty.tech/go-ipfs-log/iface"
+	"berty.tech/go-ipfs-log/io/ipldprime"
+	"berty.tech/go-ipfs-log/io/pb"
+	ks "berty.tech/go-ipfs-log/keystore"
+	"berty.tech/go-ipfs-log/storage"
+)
+
+func TestLogMigrate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	datastore := dssync.MutexWrap(NewIdentityDataStore(t))
+	keystore, err := ks.NewKeystore(datastore)
+	require.NoError(t, err)
+
+	var identities []*idp.Identity
+
+	for _, char := range []rune{'A', 'B'} {
+		identity, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+			Keystore: keystore,
+			ID:       fmt.Sprintf("user%c", char),
+			Type:     "orbitdb",
+		})
+		require.NoError(t, err)
+
+		identities = append(identities, identity)
+	}
+
+	t.Run("migrates a v0 log with attestations", func(t *testing.T) {
+		store := storage.NewMemory()
+		v0Entries := getEntriesV0Fixtures(t)
+
+		pbio, err := pb.IO(&entry.Entry{}, &entry.LamportClock{})
+		require.NoError(t, err)
+
+		for _, name := range []string{"helloWorld", "helloAgain"} {
+			_, err := pbio.Write(ctx, store, entry.Normalize(v0Entries[name], nil), nil)
+			require.NoError(t, err)
+		}
+
+		source, err := ipfslog.NewFromEntryHash(ctx, store, identities[0], v0Entries["helloAgain"].Hash, &ipfslog.LogOptions{ID: "A", IO: pbio}, &ipfslog.FetchOptions{})
+		require.NoError(t, err)
+		require.Equal(t, 2, source.Values().Len())
+
+		res, err := ipfslog.Migrate(ctx, source, store, identities[0], nil)
+		require.NoError(t, err)
+
+		require.Len(t, res.CIDs, 2)
+		require.Len(t, res.Attestations, 1)
+
+		values := res.Log.Values().Slice()
+		require.Len(t, values, 3)
+		require.Equal(t, "hello world", string(values[0].GetPayload()))
+		require.Equal(t, "hello again", string(values[1].GetPayload()))
+
+		helloWorld := res.CIDs[v0Entries["helloWorld"].Hash]
+		helloAgain := res.CIDs[v0Entries["helloAgain"].Hash]
+		require.Equal(t, helloWorld.String(), values[0].GetHash().String())
+		require.Equal(t, helloAgain.String(), values[1].GetHash().String())
+		require.Equal(t, []cid.Cid{helloWorld}, values[1].GetNext())
+
+		for _, e := range values {
+			require.Equal(t, uint64(2), e.GetV())
+			require.Equal(t, identities[0].PublicKey, e.GetKey())
+			require.NoError(t, e.Verify(identities[0].Provider, res.Log.IO()))
+		}
+
+		attestation, ok := ipfslog.ParseMigrationAttestation(values[2])
+		require.True(t, ok)
+		require.Len(t, attestation.Entries, 2)
+		require.Equal(t, v0Entries["helloWorld"].Hash, attestation.Entries[0].From)
+		require.Equal(t, helloWorld, attestation.Entries[0].To)
+		require.Equal(t, uint64(0), attestation.Entries[0].V)
+		require.Equal(t, v0Entries["helloWorld"].Sig, MustBytesFromHex(t, attestation.Entries[0].Sig))
+
+		_, ok = ipfslog.ParseMigrationAttestation(values[0])
+		require.False(t, ok)
+
+		hash, err := res.Log.ToMultihash(ctx)
+		require.NoError(t, err)
+
+		loaded, err := ipfslog.NewFromMultihash(ctx, store, identities[0], hash, &ipfslog.LogOptions{}, &ipfslog.FetchOptions{})
+		require.NoError(t, err)
+		require.Equal(t, res.Log.ToString(nil), loaded.ToString(nil))
+	})
+
+	t.Run("re-signs entries with the original keys", func(t *testing.T) {
+		store := storage.NewMemory()
+
+		logA, err := ipfslog.NewLog(store, identities[0], &ipfslog.LogOptions{ID: "X"})
+		require.NoError(t, err)
+
+		logB, err := ipfslog.NewLog(store, identities[1], &ipfslog.LogOptions{ID: "X"})
+		require.NoError(t, err)
+
+		for i := 0; i < 5; i++ {
+			_, err = logA.Append(ctx, []byte(fmt.Sprintf("a%d", i)), &iface.AppendOptions{PointerCount: 4})
+			require.NoError(t, err)
+
+			_, err = logB.Append(ctx, []byte(fmt.Sprintf("b%d", i)), &iface.AppendOptions{PointerCount: 4})
+			require.NoError(t, err)
+		}
+
+		_, err = logA.Join(logB, -1)
+		require.NoError(t, err)
+
+		_, err = logA.Append(ctx, []byte("merge"), &iface.AppendOptions{PointerCount: 4})
+		require.NoError(t, err)
+
+		primeIO, err := ipldprime.IO(&entry.Entry{}, &entry.LamportClock{})
+		require.NoError(t, err)
+		jsonIO := primeIO.ApplyOptions(&ipldprime.Options{Codec: ipldprime.DagJSON})
+
+		res, err := ipfslog.Migrate(ctx, logA, store, identities[0], &ipfslog.MigrateOptions{
+			IO:      jsonIO,
+			Signers: identities,
+			Pin:     true,
+		})
+		require.NoError(t, err)
+		require.Empty(t, res.Attestations)
+		require.Len(t, res.CIDs, logA.Values().Len())
+
+		source := logA.Values().Slice()
+		migrated := res.Log.Values().Slice()
+		require.Len(t, migrated, len(source))
+
+		for i := range source {
+			require.Equal(t, source[i].GetPayload(), migrated[i].GetPayload())
+			require.Equal(t, source[i].GetKey(), migrated[i].GetKey())
+			require.Equal(t, source[i].GetClock().GetID(), migrated[i].GetClock().GetID())
+			require.Equal(t, source[i].GetClock().GetTime(), migrated[i].GetClock().GetTime())
+			require.Equal(t, res.CIDs[source[i].GetHash()], migrated[i].GetHash())
+			require.Equal(t, uint64(ipldprime.DagJSON), migrated[i].GetHash().Prefix().Codec)
+			require.True(t, store.IsPinned(migrated[i].GetHash()))
+			require.NoError(t, migrated[i].Verify(identities[0].Provider, jsonIO))
+
+			for j, r := range source[i].GetRefs() {
+				require.Equal(t, res.CIDs[r], migrated[i].GetRefs()[j])
+			}
+		}
+
+		hash, err := res.Log.ToMultihash(ctx)
+		require.NoError(t, err)
+
+		loaded, err := ipfslog.NewFromMultihash(ctx, store, identities[0], hash, &ipfslog.LogOptions{IO: jsonIO}, &ipfslog.FetchOptions{})
+		require.NoError(t, err)
+		require.Equal(t, logA.ToString(nil), loaded.ToString(nil))
+	})
+}
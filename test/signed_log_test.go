@@ -253,3 +253,86 @@ func TestSignedLog(t *testing.T) {
 		require.Contains(t, err.Error(), errmsg.ErrLogJoinFailed.Error())
 	})
 }
+
+func TestSignedLogKeyTypes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := mocknet.New()
+	defer m.Close()
+	ipfs, closeNode := NewMemoryServices(ctx, t, m)
+	defer closeNode()
+
+	datastore := dssync.MutexWrap(NewIdentityDataStore(t))
+	keystore, err := ks.NewKeystore(datastore)
+	require.NoError(t, err)
+
+	reference, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+		Keystore: keystore,
+		ID:       "userA",
+		Type:     "orbitdb",
+	})
+	require.NoError(t, err)
+
+	for _, keyType := range []ks.KeyType{ks.KeyTypeEd25519, ks.KeyTypeECDSAP256} {
+		keyType := keyType
+
+		t.Run(string(keyType), func(t *testing.T) {
+			identity, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+				Keystore: keystore,
+				ID:       fmt.Sprintf("user-%s", keyType),
+				Type:     "orbitdb",
+				KeyType:  keyType,
+			})
+			require.NoError(t, err)
+
+			pubKey, err := identity.GetPublicKey()
+			require.NoError(t, err)
+
+			actual, err := ks.KeyTypeOf(pubKey)
+			require.NoError(t, err)
+			require.Equal(t, keyType, actual)
+
+			priv, err := keystore.GetKey(ctx, identity.ID)
+			require.NoError(t, err)
+			require.True(t, priv.GetPublic().Equals(pubKey))
+
+			l1, err := ipfslog.NewLog(ipfs, identity, &ipfslog.LogOptions{ID: "A"})
+			require.NoError(t, err)
+
+			e, err := l1.Append(ctx, []byte("one"), nil)
+			require.NoError(t, err)
+			require.NoError(t, e.Verify(identity.Provider, l1.IO()))
+
+			l2, err := ipfslog.NewLog(ipfs, reference, &ipfslog.LogOptions{ID: "A"})
+			require.NoError(t, err)
+
+			other, err := l2.Append(ctx, []byte("two"), nil)
+			require.NoError(t, err)
+
+			_, err = l2.Join(l1, -1)
+			require.NoError(t, err)
+			require.Equal(t, 2, l2.Values().Len())
+
+			l1.Values().At(0).SetSig(other.GetSig())
+
+			l3, err := ipfslog.NewLog(ipfs, reference, &ipfslog.LogOptions{ID: "A"})
+			require.NoError(t, err)
+
+			_, err = l3.Join(l1, -1)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), errmsg.ErrSigNotVerified.Error())
+		})
+	}
+
+	t.Run("rejects unknown key types", func(t *testing.T) {
+		_, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+			Keystore: keystore,
+			ID:       "userRSA",
+			Type:     "orbitdb",
+			KeyType:  "rsa",
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrUnsupportedKeyType.Error())
+	})
+}
@@ -0,0 +1,147 @@
+package test
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-ipfs-log/errmsg"
+	idp "berty.tech/go-ipfs-log/identityprovider"
+	ks "berty.tech/go-ipfs-log/keystore"
+)
+
+// countingSigner records the keys used by the identity layer.
+type countingSigner struct {
+	idp.Signer
+
+	lock  sync.Mutex
+	signs map[string]int
+}
+
+func (s *countingSigner) Sign(ctx context.Context, id string, data []byte) ([]byte, error) {
+	s.lock.Lock()
+	s.signs[id]++
+	s.lock.Unlock()
+
+	return s.Signer.Sign(ctx, id, data)
+}
+
+func TestRemoteSigner(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := mocknet.New()
+	defer m.Close()
+	ipfs, closeNode := NewMemoryServices(ctx, t, m)
+	defer closeNode()
+
+	// keys are only held by the keystore of the signer process
+	keystore, err := ks.NewKeystore(dssync.MutexWrap(ds.NewMapDatastore()))
+	require.NoError(t, err)
+
+	local := &countingSigner{Signer: idp.NewKeystoreSigner(keystore), signs: map[string]int{}}
+
+	server, err := idp.NewSignerServer(local)
+	require.NoError(t, err)
+
+	t.Run("signs entries over a connection", func(t *testing.T) {
+		clientConn, serverConn := net.Pipe()
+		go server.ServeConn(serverConn)
+
+		signer := idp.NewRemoteSigner(clientConn)
+		defer signer.Close()
+
+		identity, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+			Signer:  signer,
+			ID:      "userA",
+			Type:    "orbitdb",
+			KeyType: ks.KeyTypeEd25519,
+		})
+		require.NoError(t, err)
+		require.Equal(t, 1, local.signs["userA"])
+		require.Equal(t, 1, local.signs[identity.ID])
+
+		priv, err := keystore.GetKey(ctx, identity.ID)
+		require.NoError(t, err)
+
+		keyType, err := ks.KeyTypeOf(priv)
+		require.NoError(t, err)
+		require.Equal(t, ks.KeyTypeEd25519, keyType)
+
+		l1, err := ipfslog.NewLog(ipfs, identity, &ipfslog.LogOptions{ID: "A"})
+		require.NoError(t, err)
+
+		e, err := l1.Append(ctx, []byte("one"), nil)
+		require.NoError(t, err)
+		require.NoError(t, e.Verify(identity.Provider, l1.IO()))
+		require.Equal(t, 2, local.signs[identity.ID])
+
+		reference, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+			Keystore: keystore,
+			ID:       "userB",
+			Type:     "orbitdb",
+		})
+		require.NoError(t, err)
+
+		l2, err := ipfslog.NewLog(ipfs, reference, &ipfslog.LogOptions{ID: "A"})
+		require.NoError(t, err)
+
+		_, err = l2.Join(l1, -1)
+		require.NoError(t, err)
+		require.Equal(t, 1, l2.Values().Len())
+	})
+
+	t.Run("signs over a unix socket", func(t *testing.T) {
+		l, err := net.Listen("unix", filepath.Join(t.TempDir(), "signer.sock"))
+		require.NoError(t, err)
+		defer l.Close()
+
+		go server.Serve(l)
+
+		signer, err := idp.DialRemoteSigner("unix", l.Addr().String())
+		require.NoError(t, err)
+		defer signer.Close()
+
+		pubKey, err := signer.PublicKey(ctx, "userC", ks.KeyTypeECDSAP256)
+		require.NoError(t, err)
+
+		sig, err := signer.Sign(ctx, "userC", []byte("data"))
+		require.NoError(t, err)
+
+		ok, err := pubKey.Verify([]byte("data"), sig)
+		require.NoError(t, err)
+		require.True(t, ok)
+	})
+
+	t.Run("reports signer errors", func(t *testing.T) {
+		clientConn, serverConn := net.Pipe()
+		go server.ServeConn(serverConn)
+
+		signer := idp.NewRemoteSigner(clientConn)
+
+		_, err := signer.Sign(ctx, "unknown", []byte("data"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrSigSign.Error())
+
+		canceled, cancelCall := context.WithCancel(ctx)
+		cancelCall()
+
+		_, err = signer.Sign(canceled, "userA", []byte("data"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrSignerUnavailable.Error())
+
+		require.NoError(t, signer.Close())
+
+		_, err = signer.Sign(ctx, "userA", []byte("data"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), errmsg.ErrSignerUnavailable.Error())
+	})
+}
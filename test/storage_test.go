@@ -0,0 +1,92 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/ipfs/boxo/blockstore"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	idp "berty.tech/go-ipfs-log/identityprovider"
+	"berty.tech/go-ipfs-log/iface"
+	ks "berty.tech/go-ipfs-log/keystore"
+	"berty.tech/go-ipfs-log/storage"
+)
+
+func TestStorage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	datastore := dssync.MutexWrap(NewIdentityDataStore(t))
+	keystore, err := ks.NewKeystore(datastore)
+	require.NoError(t, err)
+
+	identity, err := idp.CreateIdentity(ctx, &idp.CreateIdentityOptions{
+		Keystore: keystore,
+		ID:       "userA",
+		Type:     "orbitdb",
+	})
+	require.NoError(t, err)
+
+	roundTrip := func(t *testing.T, store iface.Storage) {
+		t.Helper()
+
+		log1, err := ipfslog.NewLog(store, identity, &ipfslog.LogOptions{ID: "X"})
+		require.NoError(t, err)
+
+		for i := 0; i < 10; i++ {
+			_, err := log1.Append(ctx, []byte(fmt.Sprintf("entry%d", i)), nil)
+			require.NoError(t, err)
+		}
+
+		hash, err := log1.ToMultihash(ctx)
+		require.NoError(t, err)
+
+		has, err := store.Has(ctx, hash)
+		require.NoError(t, err)
+		require.True(t, has)
+
+		log2, err := ipfslog.NewFromMultihash(ctx, store, identity, hash, &ipfslog.LogOptions{}, &ipfslog.FetchOptions{})
+		require.NoError(t, err)
+		require.Equal(t, log1.ID, log2.ID)
+		require.Equal(t, log1.Values().Len(), log2.Values().Len())
+		require.Equal(t, log1.ToString(nil), log2.ToString(nil))
+	}
+
+	t.Run("memory", func(t *testing.T) {
+		store := storage.NewMemory()
+		roundTrip(t, store)
+		require.Equal(t, 11, store.Len())
+
+		log1, err := ipfslog.NewLog(store, identity, &ipfslog.LogOptions{ID: "X"})
+		require.NoError(t, err)
+
+		e, err := log1.Append(ctx, []byte("pinned"), &iface.AppendOptions{Pin: true})
+		require.NoError(t, err)
+		require.True(t, store.IsPinned(e.GetHash()))
+
+		e, err = log1.Append(ctx, []byte("unpinned"), nil)
+		require.NoError(t, err)
+		require.False(t, store.IsPinned(e.GetHash()))
+	})
+
+	t.Run("blockstore", func(t *testing.T) {
+		bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+		roundTrip(t, storage.NewBlockstore(bs))
+	})
+
+	t.Run("coreapi", func(t *testing.T) {
+		m := mocknet.New()
+		defer m.Close()
+
+		ipfs, closeNode := NewMemoryServices(ctx, t, m)
+		defer closeNode()
+
+		roundTrip(t, ipfs)
+	})
+}
@@ -3,7 +3,9 @@ package test
 import (
 	"testing"
 
+	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
 
 	"berty.tech/go-ipfs-log/entry"
 	"berty.tech/go-ipfs-log/identityprovider"
@@ -152,3 +154,27 @@ func getEntriesV1Fixtures(t *testing.T, id *identityprovider.Identity) []entry.E
 		},
 	}
 }
+
+// getLinkEncryptedEntriesFixtures returns the blocks of the entries "hello1",
+// "hello2" and "hello3" of the log "X", appended by userA using a cbor IO with
+// the link key "0123456789abcdef0123456789abcdef", as written before the
+// encrypted links were signed.
+func getLinkEncryptedEntriesFixtures(t *testing.T) []blocks.Block {
+	t.Helper()
+
+	fixtures := [][2]string{
+		{"bafyreigx6hzhrhxwtovdyulhvsumlu2lyelplne5htcxgzxsvv2nchsis4", "aa6176026269646158636b657978823034386265663232333165363464356337313437626434623861666238346162643431323665653864383333356534623036396163306136356337626537313163656135633162386434376263323065626165636463613538383630306464663238393436373565373862326566313763663439653762626166393830383033363163736967788c33303434303232303035393463306433353666396130366234326363656638616565313966643065353937623731323037343332326439666664303862623736353233363131393130323230373761383532333433646439653536646339653464373231323631656334613536303663343966343434386338386638336338303136613139326561633536636468617368f6646e6578748064726566738065636c6f636ba26269647882303438626566323233316536346435633731343762643462386166623834616264343132366565386438333335653462303639616330613635633762653731316365613563316238643437626332306562616563646361353838363030646466323839343637356537386232656631376366343965376262616639383038303336316474696d6501677061796c6f61646668656c6c6f31686964656e74697479a462696478423033653034383035333863326133393935316430353465313766663331666465343837636231303331643030343461303337623533616432653032386133653737636474797065676f726269746462697075626c69634b65797882303438626566323233316536346435633731343762643462386166623834616264343132366565386438333335653462303639616330613635633762653731316365613563316238643437626332306562616563646361353838363030646466323839343637356537386232656631376366343965376262616639383038303336316a7369676e617475726573a2626964788e33303435303232313030663566366631303537316431343334376161663334653532366365333431396664363464373566666137616137333639326362623661656236666263313437313032323033613365336661343166613866636262396663376331343861663562363430653266373034623230623361346530623933666333613664343464666662343165697075626c69634b6579788c3330343430323230323039383262383439326265306331383464633239646530613361336264383661383662613939373735366230626634316464616264323462343763356163663032323033373435666461333964376466363530613561343738653532626265383739663063623435633037343032356139333437313431346135363037373634306134"},
+		{"bafyreig6o4d6celgg74duurd3b5vbbu6nwwch5w42g635q4rgpb6zyhr4e", "ac6176026269646158636b657978823034386265663232333165363464356337313437626434623861666238346162643431323665653864383333356534623036396163306136356337626537313163656135633162386434376263323065626165636463613538383630306464663238393436373565373862326566313763663439653762626166393830383033363163736967788e333034353032323130306235626364316430653539626464656262353332393365393963613833663065316261613866373862313135356162343034363134333432386431396335653030323230366165326261363635643066643732636261613264653132356338323135626337373235373936656330333830643663333731653435326139626634373137356468617368f6646e6578748064726566738065636c6f636ba26269647882303438626566323233316536346435633731343762643462386166623834616264343132366565386438333335653462303639616330613635633762653731316365613563316238643437626332306562616563646361353838363030646466323839343637356537386232656631376366343965376262616639383038303336316474696d6502677061796c6f61646668656c6c6f32686964656e74697479a462696478423033653034383035333863326133393935316430353465313766663331666465343837636231303331643030343461303337623533616432653032386133653737636474797065676f726269746462697075626c69634b65797882303438626566323233316536346435633731343762643462386166623834616264343132366565386438333335653462303639616330613635633762653731316365613563316238643437626332306562616563646361353838363030646466323839343637356537386232656631376366343965376262616639383038303336316a7369676e617475726573a2626964788e33303435303232313030663566366631303537316431343334376161663334653532366365333431396664363464373566666137616137333639326362623661656236666263313437313032323033613365336661343166613866636262396663376331343861663562363430653266373034623230623361346530623933666333613664343464666662343165697075626c69634b6579788c333034343032323032303938326238343932626530633138346463323964653061336133626438366138366261393937373536623062663431646461626432346234376335616366303232303337343566646133396437646636353061356134373865353262626538373966306362343563303734303235613933343731343134613536303737363430613469656e635f6c696e6b7378a0635a515a487165474f6e5969786b6b58394d64322b4857616c394b666731706c7a436d4c422f4f3734746e4878366178525469683358794537697449706a4b5544534e474a5457334533376a4162494c4e423944504759445536573266793164656c55746b73716c322b4365524b6d5365662f48427245576d46644368467153494d724a455a6b53746d4a49465a514f682b7132443777646a4f32324679453d6f656e635f6c696e6b735f6e6f6e6365782049447631774333492f513976532f364c72327563535a34683055796669535832"},
+		{"bafyreidqrol26gztvsaohymumidwdli4p5n45mbnkph3d4ory3fvxu5ulm", "ac6176026269646158636b657978823034386265663232333165363464356337313437626434623861666238346162643431323665653864383333356534623036396163306136356337626537313163656135633162386434376263323065626165636463613538383630306464663238393436373565373862326566313763663439653762626166393830383033363163736967788e333034353032323130306636383564363866643063663061643966663236316234383334396365373337386636363233616239333062373637626533663032646162653461336166613030323230353130363134633333343838333661393036666533346235326563323262626361336163613536623862376463306564303233393765343330333265636166336468617368f6646e6578748064726566738065636c6f636ba26269647882303438626566323233316536346435633731343762643462386166623834616264343132366565386438333335653462303639616330613635633762653731316365613563316238643437626332306562616563646361353838363030646466323839343637356537386232656631376366343965376262616639383038303336316474696d6503677061796c6f61646668656c6c6f33686964656e74697479a462696478423033653034383035333863326133393935316430353465313766663331666465343837636231303331643030343461303337623533616432653032386133653737636474797065676f726269746462697075626c69634b65797882303438626566323233316536346435633731343762643462386166623834616264343132366565386438333335653462303639616330613635633762653731316365613563316238643437626332306562616563646361353838363030646466323839343637356537386232656631376366343965376262616639383038303336316a7369676e617475726573a2626964788e33303435303232313030663566366631303537316431343334376161663334653532366365333431396664363464373566666137616137333639326362623661656236666263313437313032323033613365336661343166613866636262396663376331343861663562363430653266373034623230623361346530623933666333613664343464666662343165697075626c69634b6579788c333034343032323032303938326238343932626530633138346463323964653061336133626438366138366261393937373536623062663431646461626432346234376335616366303232303337343566646133396437646636353061356134373865353262626538373966306362343563303734303235613933343731343134613536303737363430613469656e635f6c696e6b7378a06664336272544e446c50683366472f3738453450792b576f664f736b734d6d6f69334265377a6f4945536c57525856583777504b6d4c5a4d5065636d574a5a5933364567557964716779703965316658486a6f51476434794473524775414f44437273352f5a50446c71366c616d66483535663169796875446f5a466664577a46333958646a356d326f614e595056326346436872314f56423148356363413d6f656e635f6c696e6b735f6e6f6e63657820614c4a3079304152503138466d6970312f59556b59484a384b6d677055546361"},
+	}
+
+	out := make([]blocks.Block, len(fixtures))
+	for i, f := range fixtures {
+		b, err := blocks.NewBlockWithCid(MustBytesFromHex(t, f[1]), MustCID(t, f[0]))
+		require.NoError(t, err)
+
+		out[i] = b
+	}
+
+	return out
+}
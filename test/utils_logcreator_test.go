@@ -6,7 +6,7 @@ import (
 
 	ipfslog "berty.tech/go-ipfs-log"
 	idp "berty.tech/go-ipfs-log/identityprovider"
-	coreiface "github.com/ipfs/kubo/core/coreiface"
+	"berty.tech/go-ipfs-log/iface"
 )
 
 type CreatedLog struct {
@@ -15,7 +15,7 @@ type CreatedLog struct {
 	JSON         *ipfslog.JSONLog
 }
 
-func createLogsFor16Entries(ctx context.Context, ipfs coreiface.CoreAPI, identities []*idp.Identity) (*ipfslog.IPFSLog, error) {
+func createLogsFor16Entries(ctx context.Context, ipfs iface.Storage, identities []*idp.Identity) (*ipfslog.IPFSLog, error) {
 	logA, err := ipfslog.NewLog(ipfs, identities[0], &ipfslog.LogOptions{ID: "X"})
 	if err != nil {
 		return nil, err
@@ -85,7 +85,7 @@ func createLogsFor16Entries(ctx context.Context, ipfs coreiface.CoreAPI, identit
 	return l, nil
 }
 
-func CreateLogWithSixteenEntries(ctx context.Context, ipfs coreiface.CoreAPI, identities []*idp.Identity) (*CreatedLog, error) {
+func CreateLogWithSixteenEntries(ctx context.Context, ipfs iface.Storage, identities []*idp.Identity) (*CreatedLog, error) {
 	expectedData := []string{
 		"entryA1", "entryB1", "entryA2", "entryB2", "entryA3", "entryB3",
 		"entryA4", "entryB4", "entryA5", "entryB5",
@@ -102,7 +102,7 @@ func CreateLogWithSixteenEntries(ctx context.Context, ipfs coreiface.CoreAPI, id
 	return &CreatedLog{Log: l, ExpectedData: expectedData, JSON: l.ToJSONLog()}, nil
 }
 
-func createLogWithHundredEntries(ctx context.Context, ipfs coreiface.CoreAPI, identities []*idp.Identity) (*ipfslog.IPFSLog, []string, error) {
+func createLogWithHundredEntries(ctx context.Context, ipfs iface.Storage, identities []*idp.Identity) (*ipfslog.IPFSLog, []string, error) {
 	var expectedData []string
 	const amount = 100
 
@@ -145,7 +145,7 @@ func createLogWithHundredEntries(ctx context.Context, ipfs coreiface.CoreAPI, id
 	return logA, expectedData, nil
 }
 
-func CreateLogWithHundredEntries(ctx context.Context, ipfs coreiface.CoreAPI, identities []*idp.Identity) (*CreatedLog, error) {
+func CreateLogWithHundredEntries(ctx context.Context, ipfs iface.Storage, identities []*idp.Identity) (*CreatedLog, error) {
 	l, expectedData, err := createLogWithHundredEntries(ctx, ipfs, identities)
 	if err != nil {
 		return nil, err
@@ -6,12 +6,12 @@ import (
 	"testing"
 
 	"berty.tech/go-ipfs-log/iface"
+	"berty.tech/go-ipfs-log/storage"
 	"github.com/ipfs/go-datastore"
 	dssync "github.com/ipfs/go-datastore/sync"
 	config "github.com/ipfs/kubo/config"
 	ipfsCore "github.com/ipfs/kubo/core"
 	"github.com/ipfs/kubo/core/coreapi"
-	coreiface "github.com/ipfs/kubo/core/coreiface"
 	mock "github.com/ipfs/kubo/core/mock"
 	ipfs_repo "github.com/ipfs/kubo/repo"
 	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
@@ -41,7 +41,7 @@ func newRepo() (ipfs_repo.Repo, error) {
 	}, nil
 }
 
-func NewMemoryServices(ctx context.Context, t testing.TB, m mocknet.Mocknet) (coreiface.CoreAPI, func()) {
+func NewMemoryServices(ctx context.Context, t testing.TB, m mocknet.Mocknet) (iface.Storage, func()) {
 	t.Helper()
 
 	r, err := newRepo()
@@ -63,7 +63,7 @@ func NewMemoryServices(ctx context.Context, t testing.TB, m mocknet.Mocknet) (co
 	close := func() {
 		core.Close()
 	}
-	return api, close
+	return storage.NewCoreAPI(api), close
 }
 
 func lastEntry(entries []iface.IPFSLogEntry) iface.IPFSLogEntry {